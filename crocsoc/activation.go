@@ -0,0 +1,68 @@
+package crocsoc
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+)
+
+/*
+systemd socket activation (sd_listen_fds(3)): the service manager opens the
+listening sockets and passes them to the process starting at fd 3, setting
+
+	LISTEN_PID   pid of the process the sockets are meant for
+	LISTEN_FDS   number of sockets passed
+
+Serve them like any other listener:
+
+	lns, err := crocsoc.SystemdListeners()
+	...
+	http.Serve(lns[0], mux)
+*/
+
+// first fd passed by systemd, SD_LISTEN_FDS_START
+const listenFDsStart = 3
+
+// Returns the listeners passed by systemd socket activation, or none when the
+// process wasn't socket activated. The LISTEN_* variables are unset so child
+// processes don't inherit them.
+func SystemdListeners() ([]net.Listener, error) {
+	return listenersFromEnv(listenFDsStart)
+}
+
+func listenersFromEnv(start int) ([]net.Listener, error) {
+	defer os.Unsetenv("LISTEN_PID")
+	defer os.Unsetenv("LISTEN_FDS")
+	defer os.Unsetenv("LISTEN_FDNAMES")
+
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	// not activated, or the sockets are meant for another process
+	if err != nil || pid != os.Getpid() {
+		return nil, nil
+	}
+
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n < 0 {
+		return nil, fmt.Errorf("invalid LISTEN_FDS %q", os.Getenv("LISTEN_FDS"))
+	}
+
+	lns := make([]net.Listener, 0, n)
+	for fd := start; fd < start+n; fd++ {
+		f := os.NewFile(uintptr(fd), "LISTEN_FD_"+strconv.Itoa(fd))
+
+		// dups the fd, so the original is closed either way
+		ln, err := net.FileListener(f)
+		f.Close()
+
+		if err != nil {
+			for _, l := range lns {
+				l.Close()
+			}
+			return nil, fmt.Errorf("fd %d is not a listener: %v", fd, err)
+		}
+		lns = append(lns, ln)
+	}
+
+	return lns, nil
+}
@@ -0,0 +1,58 @@
+package crocsoc
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"syscall"
+	"testing"
+)
+
+func TestSystemdListeners(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer ln.Close()
+
+	// stand in for the fd systemd would pass, owned by listenersFromEnv
+	f, err := ln.(*net.TCPListener).File()
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	fd, err := syscall.Dup(int(f.Fd()))
+	f.Close()
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	t.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+	t.Setenv("LISTEN_FDS", "1")
+
+	lns, err := listenersFromEnv(fd)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if len(lns) != 1 {
+		t.Fatalf("want: 1 listener, got: %d", len(lns))
+	}
+	defer lns[0].Close()
+
+	if got, want := lns[0].Addr().String(), ln.Addr().String(); got != want {
+		t.Errorf("want: %s, got: %s", want, got)
+	}
+
+	if os.Getenv("LISTEN_FDS") != "" {
+		t.Errorf("LISTEN_FDS not unset")
+	}
+}
+
+func TestSystemdListenersOtherProcess(t *testing.T) {
+	t.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()+1))
+	t.Setenv("LISTEN_FDS", "1")
+
+	lns, err := SystemdListeners()
+	if err != nil || lns != nil {
+		t.Errorf("want: no listeners, got: %v %v", lns, err)
+	}
+}
@@ -0,0 +1,161 @@
+package crocsoc
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+)
+
+/*
+Auth token refresh over the open connection, so a connection outlives the
+token it was opened with without reconnecting every token lifetime. The
+client pushes a fresh token before the current one expires, as a text
+message of the ASCII ENQ byte followed by the token; the server validates
+it and answers with the new expiry in unix seconds:
+
+	client -> "\x05" "eyJhbGciOi..."
+	server -> "\x05" "1760620000"
+
+An expiry passing without a refresh, or a refresh that fails validation,
+closes the connection through CloseWithError(ErrAuthExpired), 1008 by
+default. Refresh messages are taken out of the inbound stream before the
+application sees them. Both sides opt in by announcing CapAuthRefresh
+during Negotiate; connections that didn't are still closed at expiry.
+*/
+
+// Capability announced in Hellos by sides speaking auth token refresh.
+const CapAuthRefresh = "auth-refresh"
+
+// first byte of a token refresh message and its answer
+const authRefreshByte = 0x05
+
+// Validates an auth token, returning when it expires.
+type TokenValidator func(token string) (time.Time, error)
+
+// Closes one connection when its auth token expires, unless the client
+// refreshes it first.
+type AuthRefresh struct {
+	Conn *WSConn
+
+	validate TokenValidator
+
+	mu      sync.Mutex
+	expires time.Time
+	timer   *time.Timer
+	stopped bool
+}
+
+// Starts enforcing expires, the expiry of the token conn was opened with.
+// Refreshes are accepted if both sides announced CapAuthRefresh, and
+// checked with validate. Must be called before conn is read from,
+// refreshes are taken out of its inbound messages. Validation runs on the
+// reading goroutine, so a slow validate holds up reads.
+func NewAuthRefresh(conn *WSConn, agreed Agreement, expires time.Time, validate TokenValidator) *AuthRefresh {
+	a := &AuthRefresh{
+		Conn:     conn,
+		validate: validate,
+		expires:  expires,
+	}
+	a.timer = time.AfterFunc(time.Until(expires), a.expire)
+
+	if agreed.Has(CapAuthRefresh) {
+		conn.InterceptInbound(a.intercept)
+	} else {
+		conn.InterceptInbound(a.watchClose)
+	}
+	return a
+}
+
+// Returns when the current token expires.
+func (a *AuthRefresh) Expires() time.Time {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.expires
+}
+
+// Stops enforcing the expiry. Refresh messages are still taken out of the
+// inbound stream but no longer answered.
+func (a *AuthRefresh) Stop() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.stopped = true
+	a.timer.Stop()
+}
+
+// Sends a refreshed token to the server, for clients written in Go.
+func RefreshToken(conn *WSConn, token string) error {
+	return conn.SendTextFrame(append([]byte{authRefreshByte}, token...))
+}
+
+// Parses the server's answer to RefreshToken, false for any other message.
+func ParseTokenExpiry(msg []byte) (time.Time, bool) {
+	if len(msg) < 2 || msg[0] != authRefreshByte {
+		return time.Time{}, false
+	}
+	secs, err := strconv.ParseInt(string(msg[1:]), 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(secs, 0), true
+}
+
+func (a *AuthRefresh) intercept(f *Frame) (*Frame, error) {
+	if f.Opcode != 0x1 || !f.Fin || len(f.Payload) < 2 || f.Payload[0] != authRefreshByte {
+		return a.watchClose(f)
+	}
+
+	a.mu.Lock()
+	stopped := a.stopped
+	a.mu.Unlock()
+	if stopped {
+		return nil, nil
+	}
+
+	expires, err := a.validate(string(f.Payload[1:]))
+	if err == nil && !expires.After(time.Now()) {
+		err = fmt.Errorf("token expired at %v", expires)
+	}
+	if err != nil {
+		a.Conn.logger().Warn("auth refresh rejected", "err", err)
+		a.Stop()
+		a.Conn.CloseWithError(fmt.Errorf("%w: %v", ErrAuthExpired, err))
+		return nil, nil
+	}
+
+	a.mu.Lock()
+	if a.stopped {
+		a.mu.Unlock()
+		return nil, nil
+	}
+	a.expires = expires
+	a.timer.Reset(time.Until(expires))
+	a.mu.Unlock()
+
+	if err := a.Conn.SendTextFrame(strconv.AppendInt([]byte{authRefreshByte}, expires.Unix(), 10)); err != nil {
+		return nil, err
+	}
+	return nil, nil
+}
+
+// stops the timer once the peer closes
+func (a *AuthRefresh) watchClose(f *Frame) (*Frame, error) {
+	if f.Opcode == 0x8 {
+		a.Stop()
+	}
+	return f, nil
+}
+
+func (a *AuthRefresh) expire() {
+	a.mu.Lock()
+	// a refresh may have raced the timer
+	if a.stopped || time.Now().Before(a.expires) {
+		a.mu.Unlock()
+		return
+	}
+	a.stopped = true
+	a.mu.Unlock()
+
+	a.Conn.CloseWithError(ErrAuthExpired)
+}
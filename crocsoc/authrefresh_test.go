@@ -0,0 +1,88 @@
+package crocsoc
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestAuthRefresh(t *testing.T) {
+	serverConn, clientConn := net.Pipe()
+	defer serverConn.Close()
+	defer clientConn.Close()
+
+	refreshed := time.Now().Add(time.Hour).Truncate(time.Second)
+	validate := func(token string) (time.Time, error) {
+		if token != "fresh" {
+			return time.Time{}, errors.New("bad signature")
+		}
+		return refreshed, nil
+	}
+
+	server := &WSConn{Conn: serverConn}
+	refresh := NewAuthRefresh(server, Agreement{Capabilities: []string{CapAuthRefresh}}, time.Now().Add(100*time.Millisecond), validate)
+
+	msgs := make(chan string, 1)
+	go func() {
+		for {
+			msg, err := server.ReadMessage()
+			if err != nil {
+				return
+			}
+			msgs <- string(msg)
+		}
+	}()
+
+	client := &WSConn{Conn: clientConn, Role: RoleClient}
+
+	go RefreshToken(client, "fresh")
+	f, err := readFrame(clientConn)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if expires, ok := ParseTokenExpiry(f.Payload); !ok || !expires.Equal(refreshed) {
+		t.Errorf("want: %v, got: %v %v", refreshed, expires, ok)
+	}
+	if expires := refresh.Expires(); !expires.Equal(refreshed) {
+		t.Errorf("want: %v, got: %v", refreshed, expires)
+	}
+
+	// the original expiry passes without a close
+	time.Sleep(200 * time.Millisecond)
+
+	// other messages reach the application
+	go client.SendTextFrame([]byte("hello"))
+	if got := <-msgs; got != "hello" {
+		t.Errorf("want: hello, got: %s", got)
+	}
+
+	// a bad token closes the connection
+	go RefreshToken(client, "forged")
+	f, err = readFrame(clientConn)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if f.Opcode != 0x8 || binary.BigEndian.Uint16(f.Payload) != 1008 {
+		t.Errorf("want: 1008 close, got: opcode %d %q", f.Opcode, f.Payload)
+	}
+}
+
+func TestAuthRefreshExpiry(t *testing.T) {
+	serverConn, clientConn := net.Pipe()
+	defer serverConn.Close()
+	defer clientConn.Close()
+
+	// client didn't announce the capability, expiry is still enforced
+	NewAuthRefresh(&WSConn{Conn: serverConn}, Agreement{}, time.Now().Add(50*time.Millisecond), nil)
+
+	clientConn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	f, err := readFrame(clientConn)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if code := binary.BigEndian.Uint16(f.Payload); f.Opcode != 0x8 || code != 1008 {
+		t.Errorf("want: 1008 close, got: opcode %d code %d", f.Opcode, code)
+	}
+}
@@ -0,0 +1,177 @@
+//go:build browser
+
+package crocsoc
+
+/*
+Interop tests against a real browser client. Runs headless Chrome against a
+test server; the page exercises text, binary, fragmented and close paths and
+posts its observations back.
+
+	CHROME_PATH=/usr/bin/chromium go test -tags browser -run TestBrowser -v ./crocsoc
+
+Skipped when no Chrome binary is found.
+*/
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"testing"
+	"time"
+)
+
+const browserPage = `<!doctype html>
+<script>
+const results = {};
+const ws = new WebSocket("ws://" + location.host + "/ws");
+ws.binaryType = "arraybuffer";
+
+const replies = [];
+ws.onmessage = (ev) => {
+	replies.push(ev.data);
+	if (replies.length === 1) {
+		results.text = ev.data;
+		ws.send(new Uint8Array([1, 2, 3, 255]));
+	} else if (replies.length === 2) {
+		results.binary = Array.from(new Uint8Array(ev.data));
+		ws.send("fragment");
+	} else if (replies.length === 3) {
+		results.fragmented = ev.data;
+		ws.send("close");
+	}
+};
+ws.onopen = () => ws.send("hello");
+ws.onerror = () => { results.error = true; };
+ws.onclose = (ev) => {
+	results.closeCode = ev.code;
+	results.closeReason = ev.reason;
+	results.wasClean = ev.wasClean;
+	fetch("/results", {method: "POST", body: JSON.stringify(results)});
+};
+</script>`
+
+type browserResults struct {
+	Text        string `json:"text"`
+	Binary      []int  `json:"binary"`
+	Fragmented  string `json:"fragmented"`
+	CloseCode   int    `json:"closeCode"`
+	CloseReason string `json:"closeReason"`
+	WasClean    bool   `json:"wasClean"`
+	Error       bool   `json:"error"`
+}
+
+func findChrome() string {
+	if p := os.Getenv("CHROME_PATH"); p != "" {
+		return p
+	}
+	for _, name := range []string{"google-chrome", "google-chrome-stable", "chromium", "chromium-browser", "headless_shell"} {
+		if p, err := exec.LookPath(name); err == nil {
+			return p
+		}
+	}
+	return ""
+}
+
+// echoes messages, answers "fragment" with a three frame message and
+// "close" by starting the close handshake
+func browserWS(w http.ResponseWriter, r *http.Request) {
+	if err := OpeningHandshake(w, r); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	conn, rw, err := w.(http.Hijacker).Hijack()
+	if err != nil {
+		return
+	}
+
+	ws := &WSConn{Conn: conn, RW: rw}
+
+	go func() {
+		for msg, err := range ws.Messages() {
+			if err != nil {
+				ws.Terminate()
+				return
+			}
+
+			switch string(msg.Data) {
+			case "fragment":
+				ws.WriteFrame(&Frame{Fin: false, Opcode: 0x1, Payload: []byte("frag")})
+				ws.WriteFrame(&Frame{Fin: false, Opcode: 0x0, Payload: []byte("men")})
+				ws.WriteFrame(&Frame{Fin: true, Opcode: 0x0, Payload: []byte("ted")})
+			case "close":
+				ws.CloseWrite(4000, "bye")
+			default:
+				ws.WriteFrame(&Frame{Fin: true, Opcode: msg.Opcode, Payload: msg.Data})
+			}
+		}
+	}()
+}
+
+func TestBrowser(t *testing.T) {
+	chrome := findChrome()
+	if chrome == "" {
+		t.Skip("no chrome binary found, set CHROME_PATH")
+	}
+
+	results := make(chan browserResults, 1)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte(browserPage))
+	})
+	mux.HandleFunc("/ws", browserWS)
+	mux.HandleFunc("/results", func(w http.ResponseWriter, r *http.Request) {
+		var res browserResults
+		json.NewDecoder(r.Body).Decode(&res)
+		results <- res
+	})
+
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, chrome,
+		"--headless=new",
+		"--no-sandbox",
+		"--disable-gpu",
+		"--no-first-run",
+		"--user-data-dir="+t.TempDir(),
+		// keeps the browser alive once the page has loaded
+		"--remote-debugging-port=0",
+		srv.URL,
+	)
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("failed to start chrome: %v", err)
+	}
+	defer cmd.Process.Kill()
+
+	var res browserResults
+	select {
+	case res = <-results:
+	case <-ctx.Done():
+		t.Fatalf("browser did not report results")
+	}
+
+	if res.Error {
+		t.Errorf("browser reported a websocket error")
+	}
+	if res.Text != "hello" {
+		t.Errorf("text echo: want: hello, got: %q", res.Text)
+	}
+	if len(res.Binary) != 4 || res.Binary[0] != 1 || res.Binary[3] != 255 {
+		t.Errorf("binary echo: want: [1 2 3 255], got: %v", res.Binary)
+	}
+	if res.Fragmented != "fragmented" {
+		t.Errorf("fragmented: want: fragmented, got: %q", res.Fragmented)
+	}
+	if res.CloseCode != 4000 || res.CloseReason != "bye" || !res.WasClean {
+		t.Errorf("close: want: clean 4000 bye, got: %d %q clean=%v", res.CloseCode, res.CloseReason, res.WasClean)
+	}
+}
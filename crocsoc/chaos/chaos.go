@@ -0,0 +1,287 @@
+// Package chaos wraps a WebSocket transport with fault injection, for tests
+// that need to prove reconnect and replay logic copes with a hostile network.
+// It is meant for tests only.
+//
+// Faults are applied per frame, so a connection must be wrapped after the
+// opening handshake:
+//
+//	ws.Conn = chaos.Wrap(ws.Conn, chaos.Config{DisconnectProb: 0.01, Seed: 1})
+package chaos
+
+import (
+	"encoding/binary"
+	"errors"
+	"math/rand/v2"
+	"net"
+	"sync"
+	"time"
+)
+
+// Returned by reads and writes that hit an injected disconnect or truncation.
+var ErrInjected = errors.New("chaos: injected fault")
+
+// Probabilities are per frame, in [0, 1]. The zero Config injects nothing.
+type Config struct {
+	// delay before each frame is delivered, plus up to Jitter extra
+	Latency time.Duration
+	Jitter  time.Duration
+
+	// chance a frame is held back and delivered after up to ReorderWindow
+	// later frames
+	ReorderProb   float64
+	ReorderWindow int
+
+	// chance only part of a frame is delivered, after which the connection
+	// is closed
+	TruncateProb float64
+
+	// chance the connection is closed instead of delivering a frame
+	DisconnectProb float64
+
+	// chance a masked frame has a byte of its masking key flipped, so the
+	// receiver unmasks garbage
+	CorruptMaskProb float64
+
+	// seeds fault decisions so a failing run can be reproduced
+	Seed uint64
+}
+
+// A net.Conn injecting faults into frames in both directions.
+type Conn struct {
+	net.Conn
+	cfg Config
+
+	// guards rng
+	mu  sync.Mutex
+	rng *rand.Rand
+
+	// read side state, only touched by Read
+	in    stream
+	ready []byte
+	rerr  error
+
+	// write side state
+	wmu sync.Mutex
+	out stream
+}
+
+// frames split from one direction of the byte stream
+type stream struct {
+	buf  []byte
+	held []heldFrame
+}
+
+type heldFrame struct {
+	frame []byte
+	after int
+}
+
+func Wrap(conn net.Conn, cfg Config) *Conn {
+	return &Conn{
+		Conn: conn,
+		cfg:  cfg,
+		rng:  rand.New(rand.NewPCG(cfg.Seed, cfg.Seed)),
+	}
+}
+
+func (c *Conn) Read(p []byte) (int, error) {
+	for len(c.ready) == 0 {
+		if c.rerr != nil {
+			return 0, c.rerr
+		}
+
+		if n := frameLen(c.in.buf); n > 0 {
+			frame := c.in.buf[:n:n]
+			c.in.buf = c.in.buf[n:]
+
+			for _, f := range c.reorder(&c.in, frame) {
+				if err := c.deliver(f, func(b []byte) error {
+					c.ready = append(c.ready, b...)
+					return nil
+				}); err != nil {
+					c.rerr = err
+					break
+				}
+			}
+			continue
+		}
+
+		buf := make([]byte, 4096)
+		m, err := c.Conn.Read(buf)
+		c.in.buf = append(c.in.buf, buf[:m]...)
+
+		if err != nil && m == 0 {
+			// underlying stream ended, let held frames through first
+			for _, h := range c.in.held {
+				c.ready = append(c.ready, h.frame...)
+			}
+			c.in.held = nil
+			c.rerr = err
+		}
+	}
+
+	n := copy(p, c.ready)
+	c.ready = c.ready[n:]
+	return n, nil
+}
+
+func (c *Conn) Write(p []byte) (int, error) {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+
+	c.out.buf = append(c.out.buf, p...)
+
+	for {
+		n := frameLen(c.out.buf)
+		if n == 0 {
+			return len(p), nil
+		}
+
+		frame := c.out.buf[:n:n]
+		c.out.buf = c.out.buf[n:]
+
+		for _, f := range c.reorder(&c.out, frame) {
+			if err := c.deliver(f, c.write); err != nil {
+				return 0, err
+			}
+		}
+	}
+}
+
+// Delivers frames still held back for reordering, then closes the
+// underlying connection.
+func (c *Conn) Close() error {
+	c.wmu.Lock()
+	for _, h := range c.out.held {
+		c.write(h.frame)
+	}
+	c.out.held = nil
+	c.wmu.Unlock()
+
+	return c.Conn.Close()
+}
+
+func (c *Conn) write(b []byte) error {
+	_, err := c.Conn.Write(b)
+	return err
+}
+
+// returns the frames to deliver now, holding frame back when chosen
+func (c *Conn) reorder(s *stream, frame []byte) [][]byte {
+	window := c.cfg.ReorderWindow
+	if window > 0 && len(s.held) < window && c.chance(c.cfg.ReorderProb) {
+		s.held = append(s.held, heldFrame{frame: frame, after: 1 + c.intn(window)})
+		return nil
+	}
+
+	out := [][]byte{frame}
+
+	keep := s.held[:0]
+	for _, h := range s.held {
+		h.after--
+		if h.after <= 0 {
+			out = append(out, h.frame)
+		} else {
+			keep = append(keep, h)
+		}
+	}
+	s.held = keep
+
+	return out
+}
+
+// applies latency, disconnects, truncation and mask corruption to one frame
+func (c *Conn) deliver(frame []byte, emit func([]byte) error) error {
+	if d := c.cfg.Latency + c.jitter(); d > 0 {
+		time.Sleep(d)
+	}
+
+	if c.chance(c.cfg.DisconnectProb) {
+		c.Conn.Close()
+		return ErrInjected
+	}
+
+	if c.chance(c.cfg.TruncateProb) {
+		emit(frame[:c.intn(len(frame))])
+		c.Conn.Close()
+		return ErrInjected
+	}
+
+	if frame[1]&0x80 != 0 && c.chance(c.cfg.CorruptMaskProb) {
+		// copy so the sender's buffer is left alone
+		frame = append([]byte(nil), frame...)
+		frame[maskOffset(frame)+c.intn(4)] ^= 0xFF
+	}
+
+	return emit(frame)
+}
+
+func (c *Conn) chance(p float64) bool {
+	if p <= 0 {
+		return false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.rng.Float64() < p
+}
+
+func (c *Conn) intn(n int) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.rng.IntN(n)
+}
+
+func (c *Conn) jitter() time.Duration {
+	if c.cfg.Jitter <= 0 {
+		return 0
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return time.Duration(c.rng.Int64N(int64(c.cfg.Jitter)))
+}
+
+// length of the complete frame at the start of b, 0 if b is incomplete
+func frameLen(b []byte) int {
+	if len(b) < 2 {
+		return 0
+	}
+
+	header := maskOffset(b)
+	if header > len(b) {
+		return 0
+	}
+
+	var payLen uint64
+	switch l := b[1] & 0x7F; l {
+	case 126:
+		payLen = uint64(binary.BigEndian.Uint16(b[2:4]))
+	case 127:
+		payLen = binary.BigEndian.Uint64(b[2:10])
+	default:
+		payLen = uint64(l)
+	}
+
+	if b[1]&0x80 != 0 {
+		header += 4
+	}
+
+	total := uint64(header) + payLen
+	if uint64(len(b)) < total {
+		return 0
+	}
+	return int(total)
+}
+
+// offset of the masking key, which is also the header length without it
+func maskOffset(b []byte) int {
+	switch b[1] & 0x7F {
+	case 126:
+		return 4
+	case 127:
+		return 10
+	default:
+		return 2
+	}
+}
@@ -0,0 +1,98 @@
+package chaos
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"slices"
+	"testing"
+
+	"github.com/pgxtips/crocsoc/crocsoc"
+)
+
+// writes n text frames "aaaa", "bbbb"... through a wrapped conn, returning
+// the first letter of each message the other end receives
+func sendThrough(t *testing.T, cfg Config, n int) ([]string, error) {
+	t.Helper()
+
+	serverConn, clientConn := net.Pipe()
+	client := &crocsoc.WSConn{Conn: Wrap(clientConn, cfg), Role: crocsoc.RoleClient}
+
+	got := make(chan []string)
+	go func() {
+		server := &crocsoc.WSConn{Conn: serverConn}
+
+		var msgs []string
+		for msg, err := range server.Messages() {
+			if err != nil {
+				break
+			}
+			msgs = append(msgs, string(msg.Data[:1]))
+		}
+
+		// unblock the writer if reading stopped early
+		serverConn.Close()
+		got <- msgs
+	}()
+
+	var werr error
+	for i := range n {
+		payload := bytes.Repeat([]byte{'a' + byte(i)}, 4)
+		if werr = client.SendTextFrame(payload); werr != nil {
+			break
+		}
+	}
+	client.Conn.Close()
+
+	return <-got, werr
+}
+
+func TestNoFaults(t *testing.T) {
+	got, err := sendThrough(t, Config{}, 5)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	if want := []string{"a", "b", "c", "d", "e"}; !slices.Equal(got, want) {
+		t.Errorf("want: %v, got: %v", want, got)
+	}
+}
+
+func TestReorder(t *testing.T) {
+	got, err := sendThrough(t, Config{ReorderProb: 0.5, ReorderWindow: 3, Seed: 7}, 10)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	want := []string{"a", "b", "c", "d", "e", "f", "g", "h", "i", "j"}
+	if slices.Equal(got, want) {
+		t.Errorf("frames not reordered: %v", got)
+	}
+
+	// nothing lost, only moved
+	slices.Sort(got)
+	if !slices.Equal(got, want) {
+		t.Errorf("want: %v, got: %v", want, got)
+	}
+}
+
+func TestDisconnect(t *testing.T) {
+	got, err := sendThrough(t, Config{DisconnectProb: 1}, 3)
+	if !errors.Is(err, ErrInjected) {
+		t.Errorf("want: ErrInjected, got: %v", err)
+	}
+
+	if len(got) != 0 {
+		t.Errorf("frames delivered after disconnect: %v", got)
+	}
+}
+
+func TestCorruptMask(t *testing.T) {
+	got, _ := sendThrough(t, Config{CorruptMaskProb: 1}, 3)
+
+	for _, msg := range got {
+		if msg >= "a" && msg <= "c" {
+			t.Errorf("frame delivered intact: %q", msg)
+		}
+	}
+}
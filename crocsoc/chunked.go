@@ -0,0 +1,282 @@
+package crocsoc
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/bits"
+	"sync"
+)
+
+/*
+Large payloads sent over and over with small changes between them, e.g. a
+dashboard's state, sent as content-defined chunks so only the chunks that
+changed cross the wire:
+
+	sender -> manifest  {"type":"manifest","size":1048576,"chunks":["<sha256>",...],"sent":3}
+	sender -> chunks    "sent" binary messages, sha256 (32 bytes) | chunk
+	                    bytes, one for each chunk the receiver doesn't hold yet
+
+The receiver rebuilds the payload from the manifest's chunks in order. Both
+sides then keep exactly the chunks of the latest manifest, so the sender
+knows what the receiver holds without being told.
+
+Chunk boundaries are found with FastCDC (Xia et al., USENIX ATC '16): a gear
+rolling hash over the bytes, cutting where its top bits are zero. Cuts
+depend only on the nearby content, so an insertion moves the boundaries
+around it and no others, where fixed size chunks would all shift.
+*/
+
+// Sizes of content-defined chunks, the zero value picks 2KB, 8KB and 64KB.
+// AvgSize is raised to at least 64 bytes.
+type ChunkOptions struct {
+	// no chunk is cut shorter, except the last
+	MinSize int
+	// chunks average about this size, rounded down to a power of two
+	AvgSize int
+	// no chunk is longer
+	MaxSize int
+}
+
+// largest payload a ChunkedReceiver accepts without a MaxSize
+const defaultMaxChunkedSize = 64 << 20
+
+type chunkManifest struct {
+	Type   string   `json:"type"`
+	Size   int      `json:"size"`
+	Chunks []string `json:"chunks"`
+	// number of chunk messages following
+	Sent int `json:"sent"`
+}
+
+// Sends payloads to one connection as the chunks the receiver is missing.
+type ChunkedSender struct {
+	Conn *WSConn
+	opts ChunkOptions
+
+	mu sync.Mutex
+	// chunks of the last manifest sent, which the receiver holds
+	held map[[32]byte]bool
+}
+
+// Starts a sender for conn, chunking with opts. The receiver must be new
+// too, as the sender assumes it holds no chunks.
+func NewChunkedSender(conn *WSConn, opts ChunkOptions) *ChunkedSender {
+	if opts.AvgSize <= 0 {
+		opts.AvgSize = 8 << 10
+	}
+	if opts.MinSize <= 0 {
+		opts.MinSize = opts.AvgSize / 4
+	}
+	if opts.MaxSize <= 0 {
+		opts.MaxSize = opts.AvgSize * 8
+	}
+	// smaller averages leave no room for MinSize, which cut needs above 0
+	opts.AvgSize = max(opts.AvgSize, 64)
+	opts.MinSize = max(min(opts.MinSize, opts.AvgSize), 1)
+	opts.MaxSize = max(opts.MaxSize, opts.AvgSize)
+
+	return &ChunkedSender{Conn: conn, opts: opts, held: map[[32]byte]bool{}}
+}
+
+// Sends data as a manifest followed by the chunks the receiver is missing.
+// Returns the number of chunk bytes sent.
+func (s *ChunkedSender) Send(data []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	chunks := chunk(data, s.opts)
+
+	manifest := chunkManifest{Type: "manifest", Size: len(data), Chunks: make([]string, len(chunks))}
+
+	// the receiver drops everything the manifest doesn't list
+	held := make(map[[32]byte]bool, len(chunks))
+	var missing [][]byte
+
+	for i, c := range chunks {
+		sum := sha256.Sum256(c)
+		manifest.Chunks[i] = hex.EncodeToString(sum[:])
+
+		if !held[sum] && !s.held[sum] {
+			missing = append(missing, append(sum[:], c...))
+		}
+		held[sum] = true
+	}
+	manifest.Sent = len(missing)
+
+	msg, _ := json.Marshal(manifest)
+	if err := s.Conn.SendTextFrame(msg); err != nil {
+		return 0, err
+	}
+
+	sent := 0
+	for _, m := range missing {
+		if err := s.Conn.SendBinaryFrame(m); err != nil {
+			// unknown what made it, send everything next time
+			s.held = map[[32]byte]bool{}
+			return sent, err
+		}
+		sent += len(m) - sha256.Size
+	}
+
+	s.held = held
+	return sent, nil
+}
+
+// Forgets which chunks the receiver holds, e.g. after it reconnected with
+// a new ChunkedReceiver, so the next Send sends every chunk.
+func (s *ChunkedSender) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.held = map[[32]byte]bool{}
+}
+
+// Rebuilds payloads sent by a ChunkedSender.
+type ChunkedReceiver struct {
+	Conn *WSConn
+	// largest payload accepted, 64MB when 0
+	MaxSize int
+
+	// chunks of the last manifest received
+	chunks map[[32]byte][]byte
+}
+
+// Starts a receiver for conn holding no chunks, to pair with a new
+// ChunkedSender or one just Reset.
+func NewChunkedReceiver(conn *WSConn) *ChunkedReceiver {
+	return &ChunkedReceiver{Conn: conn, chunks: map[[32]byte][]byte{}}
+}
+
+// Reads the next manifest and the chunks following it, returning the
+// rebuilt payload.
+func (r *ChunkedReceiver) Receive() ([]byte, error) {
+	opcode, msg, err := r.Conn.readMessage()
+	if err != nil {
+		return nil, err
+	}
+
+	var manifest chunkManifest
+	if opcode != 0x1 || json.Unmarshal(msg, &manifest) != nil || manifest.Type != "manifest" {
+		return nil, fmt.Errorf("expected chunk manifest")
+	}
+	// the size is allocated up front, don't take the peer's word for it
+	maxSize := r.MaxSize
+	if maxSize <= 0 {
+		maxSize = defaultMaxChunkedSize
+	}
+	if manifest.Size < 0 || manifest.Size > maxSize {
+		return nil, fmt.Errorf("manifest size %d outside 0 to %d bytes", manifest.Size, maxSize)
+	}
+
+	sums := make([][32]byte, len(manifest.Chunks))
+	listed := map[[32]byte]bool{}
+	for i, h := range manifest.Chunks {
+		b, err := hex.DecodeString(h)
+		if err != nil || len(b) != sha256.Size {
+			return nil, fmt.Errorf("invalid chunk hash %q", h)
+		}
+		sums[i] = [32]byte(b)
+		listed[sums[i]] = true
+	}
+
+	for range manifest.Sent {
+		opcode, msg, err := r.Conn.readMessage()
+		if err != nil {
+			return nil, err
+		}
+		if opcode != 0x2 || len(msg) < sha256.Size {
+			return nil, fmt.Errorf("expected chunk, got %d byte message", len(msg))
+		}
+
+		sum, data := [32]byte(msg[:sha256.Size]), msg[sha256.Size:]
+		if !listed[sum] {
+			return nil, fmt.Errorf("unexpected chunk %x", sum[:8])
+		}
+		if sha256.Sum256(data) != sum {
+			return nil, fmt.Errorf("%w: chunk %x", ErrChecksumMismatch, sum[:8])
+		}
+		r.chunks[sum] = data
+	}
+
+	out := make([]byte, 0, manifest.Size)
+	kept := make(map[[32]byte][]byte, len(sums))
+	for _, sum := range sums {
+		data, ok := r.chunks[sum]
+		if !ok {
+			return nil, fmt.Errorf("missing chunk %x", sum[:8])
+		}
+		// a held chunk listed over and over mustn't grow out past the size
+		if len(out)+len(data) > manifest.Size {
+			return nil, fmt.Errorf("chunks add up past the manifest's %d bytes", manifest.Size)
+		}
+		out = append(out, data...)
+		kept[sum] = data
+	}
+	r.chunks = kept
+
+	if len(out) != manifest.Size {
+		return nil, fmt.Errorf("rebuilt %d bytes, manifest says %d", len(out), manifest.Size)
+	}
+	return out, nil
+}
+
+// random values per byte for the gear hash, fixed so equal content always
+// chunks the same way
+var gear = func() (g [256]uint64) {
+	// splitmix64
+	x := uint64(0x6372_6f63_736f_6321)
+	for i := range g {
+		x += 0x9e3779b97f4a7c15
+		z := x
+		z = (z ^ z>>30) * 0xbf58476d1ce4e5b9
+		z = (z ^ z>>27) * 0x94d049bb133111eb
+		g[i] = z ^ z>>31
+	}
+	return g
+}()
+
+// splits data into content-defined chunks
+func chunk(data []byte, opts ChunkOptions) [][]byte {
+	var chunks [][]byte
+	for len(data) > 0 {
+		n := cut(data, opts)
+		chunks = append(chunks, data[:n])
+		data = data[n:]
+	}
+	return chunks
+}
+
+// returns the length of the chunk at the start of data. Normalized
+// chunking: a stricter mask before AvgSize and a looser one after keep
+// chunk sizes close to the average.
+func cut(data []byte, opts ChunkOptions) int {
+	n := len(data)
+	if n <= opts.MinSize {
+		return n
+	}
+	n = min(n, opts.MaxSize)
+
+	avgBits := bits.Len(uint(opts.AvgSize)) - 1
+	// masks over the top bits, which depend on the last 64 bytes
+	strict := ^uint64(0) << (64 - (avgBits + 1))
+	loose := ^uint64(0) << (64 - (avgBits - 1))
+
+	normal := min(opts.AvgSize, n)
+
+	var fp uint64
+	i := opts.MinSize
+	for ; i < normal; i++ {
+		fp = fp<<1 + gear[data[i]]
+		if fp&strict == 0 {
+			return i
+		}
+	}
+	for ; i < n; i++ {
+		fp = fp<<1 + gear[data[i]]
+		if fp&loose == 0 {
+			return i
+		}
+	}
+	return n
+}
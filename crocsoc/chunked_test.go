@@ -0,0 +1,164 @@
+package crocsoc
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"math/rand"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestChunk(t *testing.T) {
+	opts := ChunkOptions{MinSize: 2 << 10, AvgSize: 8 << 10, MaxSize: 64 << 10}
+
+	data := make([]byte, 1<<20)
+	rand.New(rand.NewSource(1)).Read(data)
+
+	chunks := chunk(data, opts)
+	for i, c := range chunks[:len(chunks)-1] {
+		if len(c) < opts.MinSize || len(c) > opts.MaxSize {
+			t.Errorf("chunk %d: %d bytes", i, len(c))
+		}
+	}
+	if got := bytes.Join(chunks, nil); !bytes.Equal(got, data) {
+		t.Errorf("chunks don't add up to the data")
+	}
+
+	// an insertion only changes the chunks around it
+	edited := append(append(append([]byte(nil), data[:500_000]...), "inserted"...), data[500_000:]...)
+
+	before := map[[32]byte]bool{}
+	for _, c := range chunks {
+		before[sha256.Sum256(c)] = true
+	}
+	changed := 0
+	for _, c := range chunk(edited, opts) {
+		if !before[sha256.Sum256(c)] {
+			changed++
+		}
+	}
+	if changed > 2 {
+		t.Errorf("want: at most 2 changed chunks, got: %d of %d", changed, len(chunks))
+	}
+}
+
+func TestChunkedSender(t *testing.T) {
+	serverConn, clientConn := net.Pipe()
+	defer serverConn.Close()
+	defer clientConn.Close()
+
+	sender := NewChunkedSender(&WSConn{Conn: serverConn}, ChunkOptions{})
+	receiver := NewChunkedReceiver(&WSConn{Conn: clientConn, Role: RoleClient})
+
+	state := make([]byte, 256<<10)
+	rand.New(rand.NewSource(2)).Read(state)
+
+	send := func(data []byte) int {
+		t.Helper()
+
+		sent := make(chan int, 1)
+		go func() {
+			n, err := sender.Send(data)
+			if err != nil {
+				t.Errorf("%v", err)
+			}
+			sent <- n
+		}()
+
+		got, err := receiver.Receive()
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		if !bytes.Equal(got, data) {
+			t.Errorf("want: %d bytes, got: %d bytes", len(data), len(got))
+		}
+		return <-sent
+	}
+
+	if n := send(state); n != len(state) {
+		t.Errorf("want: %d, got: %d", len(state), n)
+	}
+
+	// a small edit resends a chunk or two
+	copy(state[100_000:], "changed")
+	if n := send(state); n == 0 || n > 64<<10 {
+		t.Errorf("want: one or two chunks, got: %d bytes", n)
+	}
+
+	// nothing changed, nothing but the manifest
+	if n := send(state); n != 0 {
+		t.Errorf("want: 0, got: %d", n)
+	}
+
+	// after a reset every chunk goes again
+	sender.Reset()
+	if n := send(state); n != len(state) {
+		t.Errorf("want: %d, got: %d", len(state), n)
+	}
+}
+
+func TestChunkTinyAvgSize(t *testing.T) {
+	sender := NewChunkedSender(nil, ChunkOptions{AvgSize: 2})
+	if sender.opts.MinSize < 1 {
+		t.Errorf("want: MinSize at least 1, got: %d", sender.opts.MinSize)
+	}
+
+	data := make([]byte, 4<<10)
+	rand.New(rand.NewSource(3)).Read(data)
+
+	// loops forever with a MinSize of 0
+	total := 0
+	for _, c := range chunk(data, sender.opts) {
+		total += len(c)
+	}
+	if total != len(data) {
+		t.Errorf("want: %d, got: %d", len(data), total)
+	}
+}
+
+func TestChunkedReceiverManifestSize(t *testing.T) {
+	for _, size := range []int{-1, 1 << 40} {
+		serverConn, clientConn := net.Pipe()
+
+		go (&WSConn{Conn: serverConn}).SendTextFrame(fmt.Appendf(nil, `{"type":"manifest","size":%d,"chunks":[],"sent":0}`, size))
+
+		receiver := NewChunkedReceiver(&WSConn{Conn: clientConn, Role: RoleClient})
+		receiver.MaxSize = 1 << 20
+		if _, err := receiver.Receive(); err == nil {
+			t.Errorf("want: error for size %d, got: nil", size)
+		}
+
+		serverConn.Close()
+		clientConn.Close()
+	}
+}
+
+func TestChunkedReceiverRepeatedChunk(t *testing.T) {
+	serverConn, clientConn := net.Pipe()
+	defer serverConn.Close()
+	defer clientConn.Close()
+
+	data := bytes.Repeat([]byte("a"), 1024)
+	sum := sha256.Sum256(data)
+	hash := fmt.Sprintf("%x", sum)
+
+	go func() {
+		peer := &WSConn{Conn: serverConn}
+		peer.SendTextFrame(fmt.Appendf(nil, `{"type":"manifest","size":1024,"chunks":[%q],"sent":1}`, hash))
+		peer.SendBinaryFrame(append(sum[:], data...))
+
+		// the held chunk listed far more often than the size allows
+		chunks := strings.TrimSuffix(strings.Repeat(fmt.Sprintf("%q,", hash), 1000), ",")
+		peer.SendTextFrame(fmt.Appendf(nil, `{"type":"manifest","size":2048,"chunks":[%s],"sent":0}`, chunks))
+	}()
+
+	receiver := NewChunkedReceiver(&WSConn{Conn: clientConn, Role: RoleClient})
+	if _, err := receiver.Receive(); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if _, err := receiver.Receive(); err == nil {
+		t.Errorf("want: error, got: nil")
+	}
+}
@@ -0,0 +1,186 @@
+package crocsoc
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+/*
+Client side of the opening handshake (RFC-6455 4.1):
+
+If the status code received from the server is not 101, the client
+handles the response per HTTP [RFC2616] procedures.  In particular,
+the client might perform authentication if it receives a 401 status
+code; the server might redirect the client using a 3xx status code
+(but clients are not required to follow them), etc.  Otherwise,
+proceed as follows.
+
+[x] 1. If the response lacks an |Upgrade| header field or the |Upgrade|
+	header field contains a value that is not an ASCII case-
+	insensitive match for the value "websocket", the client MUST
+	_Fail the WebSocket Connection_.
+
+[x] 2. If the response lacks a |Connection| header field or the
+	|Connection| header field doesn't contain a token that is an
+	ASCII case-insensitive match for the value "Upgrade", the client
+	MUST _Fail the WebSocket Connection_.
+
+[x] 3. If the response lacks a |Sec-WebSocket-Accept| header field or
+	the |Sec-WebSocket-Accept| contains a value other than the
+	base64-encoded SHA-1 of the concatenation of the |Sec-WebSocket-
+	Key| (as a string, not base64-decoded) with the string "258EAFA5-
+	E914-47DA-95CA-C5AB0DC85B11" but ignoring any leading and
+	trailing whitespace, the client MUST _Fail the WebSocket
+	Connection_.
+*/
+
+/*
+The request MUST include a header field with the name
+|Sec-WebSocket-Key|.  The value of this header field MUST be a
+nonce consisting of a randomly selected 16-byte value that has
+been base64-encoded (see Section 4 of [RFC4648]).  The nonce
+MUST be selected randomly for each connection.
+*/
+
+// Returns a fresh Sec-WebSocket-Key nonce.
+func GenerateKey() string {
+	nonce := make([]byte, 16)
+	// never fails as of Go 1.24, it crashes the program instead
+	rand.Read(nonce)
+	return base64.StdEncoding.EncodeToString(nonce)
+}
+
+// Returns the Sec-WebSocket-Accept value a server must answer the client
+// key wk with. Same as SecAcceptSha, named alongside the other client
+// handshake helpers.
+func ComputeAccept(wk string) string {
+	return SecAcceptSha(wk)
+}
+
+// Builds the client's opening handshake for a ws:// or wss:// URL (http://
+// and https:// are accepted as equivalents) using the client key wk.
+// Optional headers such as Origin or Sec-WebSocket-Protocol are copied from
+// header, which may be nil.
+func BuildUpgradeRequest(rawURL string, wk string, header http.Header) (*http.Request, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid websocket url: %v", err)
+	}
+
+	switch u.Scheme {
+	case "ws", "http":
+		u.Scheme = "http"
+	case "wss", "https":
+		u.Scheme = "https"
+	default:
+		return nil, fmt.Errorf("unsupported websocket url scheme %q", u.Scheme)
+	}
+
+	// fragment identifiers are meaningless in websocket urls
+	if u.Fragment != "" {
+		return nil, fmt.Errorf("websocket url must not contain a fragment")
+	}
+
+	r, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	for k, v := range header {
+		r.Header[k] = append([]string(nil), v...)
+	}
+
+	r.Header.Set("Upgrade", "websocket")
+	r.Header.Set("Connection", "Upgrade")
+	r.Header.Set("Sec-WebSocket-Key", wk)
+	r.Header.Set("Sec-WebSocket-Version", "13")
+
+	return r, nil
+}
+
+// number of response body bytes kept on a HandshakeError
+const handshakeBodyLimit = 512
+
+// Returned when the server's handshake response is rejected by the client.
+// For 4xx/5xx responses Body holds the start of the response body, which is
+// usually the only hint as to why the upgrade was refused.
+type HandshakeError struct {
+	StatusCode int
+	Status     string
+	Body       string
+	Reason     string
+	// from the Retry-After header of a 429 or 503 rejection, see
+	// ReconnectDelay
+	RetryAfter time.Duration
+}
+
+func (e *HandshakeError) Error() string {
+	if e.Body != "" {
+		return fmt.Sprintf("websocket handshake failed: %s (status %d): %q", e.Reason, e.StatusCode, e.Body)
+	}
+	return fmt.Sprintf("websocket handshake failed: %s (status %d)", e.Reason, e.StatusCode)
+}
+
+// Ensures that the server's response to an upgrade request sent with the
+// client key wk completes the "4.1 Client Requirements" handshake.
+func ValidateResponse(resp *http.Response, wk string) error {
+	fail := func(reason string) *HandshakeError {
+		return &HandshakeError{
+			StatusCode: resp.StatusCode,
+			Status:     resp.Status,
+			Reason:     reason,
+		}
+	}
+
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		err := fail("unexpected status")
+
+		// keep the start of the body for rejected upgrades
+		if resp.StatusCode >= 400 && resp.Body != nil {
+			snippet, _ := io.ReadAll(io.LimitReader(resp.Body, handshakeBodyLimit))
+			err.Body = string(snippet)
+		}
+
+		if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable {
+			err.RetryAfter, _ = ParseRetryAfter(resp.Header.Get("Retry-After"))
+		}
+
+		return err
+	}
+
+	// check upgrade header is websocket
+	if !strings.EqualFold(strings.TrimSpace(resp.Header.Get("Upgrade")), "websocket") {
+		return fail("invalid upgrade value")
+	}
+
+	// check connection header contains upgrade value
+	if !headerHasToken(resp.Header, "Connection", "upgrade") {
+		return fail("connection missing upgrade value")
+	}
+
+	// check the server hashed our key
+	if !VerifyAccept(wk, resp.Header.Get("Sec-WebSocket-Accept")) {
+		return fail("invalid Sec-WebSocket-Accept")
+	}
+
+	return nil
+}
+
+// reports whether any comma separated value of the named header matches
+// token, ignoring case and surrounding whitespace.
+func headerHasToken(h http.Header, name, token string) bool {
+	for _, v := range h.Values(name) {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
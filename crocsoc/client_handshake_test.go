@@ -0,0 +1,129 @@
+package crocsoc
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func buildResponse(status int, headers map[string]string, body string) *http.Response {
+	resp := &http.Response{
+		StatusCode: status,
+		Status:     http.StatusText(status),
+		Header:     http.Header{},
+		Body:       io.NopCloser(strings.NewReader(body)),
+	}
+	for k, v := range headers {
+		resp.Header.Set(k, v)
+	}
+	return resp
+}
+
+func TestResponseHappy(t *testing.T) {
+	resp := buildResponse(http.StatusSwitchingProtocols, map[string]string{
+		"Upgrade":              "websocket",
+		"Connection":           "keep-alive, Upgrade",
+		"Sec-WebSocket-Accept": "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=",
+	}, "")
+
+	if err := ValidateResponse(resp, "dGhlIHNhbXBsZSBub25jZQ=="); err != nil {
+		t.Errorf("%v", err)
+	}
+}
+
+func TestResponseUnhappy(t *testing.T) {
+	valid := map[string]string{
+		"Upgrade":              "websocket",
+		"Connection":           "Upgrade",
+		"Sec-WebSocket-Accept": "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=",
+	}
+
+	for _, missing := range []string{"Upgrade", "Connection", "Sec-WebSocket-Accept"} {
+		resp := buildResponse(http.StatusSwitchingProtocols, valid, "")
+		resp.Header.Del(missing)
+
+		if err := ValidateResponse(resp, "dGhlIHNhbXBsZSBub25jZQ=="); err == nil {
+			t.Errorf("response without %s accepted", missing)
+		}
+	}
+
+	// accept computed from a different key
+	resp := buildResponse(http.StatusSwitchingProtocols, valid, "")
+	if err := ValidateResponse(resp, "AQIDBAUGBwgJCgsMDQ4PEA=="); err == nil {
+		t.Errorf("mismatched Sec-WebSocket-Accept accepted")
+	}
+}
+
+func TestResponseRejected(t *testing.T) {
+	body := "origin not allowed" + strings.Repeat(".", 1024)
+	resp := buildResponse(http.StatusForbidden, nil, body)
+
+	err := ValidateResponse(resp, "dGhlIHNhbXBsZSBub25jZQ==")
+
+	var herr *HandshakeError
+	if !errors.As(err, &herr) {
+		t.Fatalf("want: *HandshakeError, got: %v", err)
+	}
+
+	if herr.StatusCode != http.StatusForbidden {
+		t.Errorf("want: %d, got: %d", http.StatusForbidden, herr.StatusCode)
+	}
+
+	if len(herr.Body) != handshakeBodyLimit || !strings.HasPrefix(herr.Body, "origin not allowed") {
+		t.Errorf("unexpected body snippet: %q", herr.Body)
+	}
+}
+
+func TestGenerateKey(t *testing.T) {
+	a, b := GenerateKey(), GenerateKey()
+	if a == b {
+		t.Errorf("keys not random: %s", a)
+	}
+
+	// must pass the server's own validation
+	r := buildRequest(map[string]string{
+		"Upgrade":               "websocket",
+		"Connection":            "Upgrade",
+		"Sec-WebSocket-Key":     a,
+		"Sec-WebSocket-Version": "13",
+	})
+	if err := ValidateHeaders(r); err != nil {
+		t.Errorf("%v", err)
+	}
+}
+
+func TestComputeAccept(t *testing.T) {
+	want := "s3pPLMBiTxaQ9kYGzzhZRbK+xOo="
+	if got := ComputeAccept("dGhlIHNhbXBsZSBub25jZQ=="); got != want {
+		t.Errorf("want: %s, got: %s", want, got)
+	}
+}
+
+func TestBuildUpgradeRequest(t *testing.T) {
+	wk := GenerateKey()
+	r, err := BuildUpgradeRequest("wss://server.example.com/chat?room=1", wk, http.Header{
+		"Origin": {"http://example.com"},
+	})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	if r.URL.Scheme != "https" || r.Host != "server.example.com" || r.URL.RequestURI() != "/chat?room=1" {
+		t.Errorf("unexpected url: %v", r.URL)
+	}
+
+	if err := ValidateHeaders(r); err != nil {
+		t.Errorf("%v", err)
+	}
+	if r.Header.Get("Sec-WebSocket-Key") != wk || r.Header.Get("Origin") != "http://example.com" {
+		t.Errorf("unexpected headers: %v", r.Header)
+	}
+
+	for _, bad := range []string{"ftp://example.com", "ws://example.com/#frag", "://"} {
+		if _, err := BuildUpgradeRequest(bad, wk, nil); err == nil {
+			t.Errorf("%s accepted", bad)
+		}
+	}
+}
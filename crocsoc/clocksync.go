@@ -0,0 +1,114 @@
+package crocsoc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"sync"
+	"time"
+)
+
+/*
+NTP-lite clock sync over ping/pong application data. A pong must echo its
+ping's payload, so the peer can't stamp its own time into the reply.
+Instead both sides send time pings, carrying the sender's clock:
+
+	"CLK1" | unix ns (8 bytes, big endian)
+
+	- our ping echoed back in a pong gives the round trip time
+	- the peer's ping gives its clock at sending, which it was about
+	  RTT/2 ago, so offset = peer time - (now - RTT/2)
+
+Both estimates are smoothed like TCP's SRTT (RFC 6298), so one delayed
+frame doesn't throw them off. Estimates are only as good as the link is
+symmetric, and need ReadMessage to be running to see the pings and pongs.
+*/
+
+var timePingMagic = []byte("CLK1")
+
+// weight of a new sample, 1/8 as for SRTT
+const clockSmoothing = 8
+
+type clockSync struct {
+	mu     sync.Mutex
+	rtt    time.Duration
+	offset time.Duration
+	// whether rtt and offset hold a sample yet
+	hasRTT    bool
+	hasOffset bool
+}
+
+// Sends a ping carrying the local time, for the peer's clock offset estimate
+// and, once answered, this side's RTT estimate. Call it periodically on both
+// ends, e.g. alongside keepalive pings.
+func (c *WSConn) SendTimePing() error {
+	payload := binary.BigEndian.AppendUint64(append([]byte(nil), timePingMagic...), uint64(time.Now().UnixNano()))
+	return c.SendPingFrame(payload)
+}
+
+// Returns the smoothed round trip time measured by SendTimePing, 0 before the
+// first pong.
+func (c *WSConn) RTT() time.Duration {
+	c.clock.mu.Lock()
+	defer c.clock.mu.Unlock()
+	return c.clock.rtt
+}
+
+// Returns how far the peer's clock is ahead of the local one, and whether an
+// estimate is available yet. Needs an RTT and a time ping from the peer.
+func (c *WSConn) ClockOffset() (time.Duration, bool) {
+	c.clock.mu.Lock()
+	defer c.clock.mu.Unlock()
+	return c.clock.offset, c.clock.hasOffset
+}
+
+func (cs *clockSync) observePong(payload []byte) {
+	sent, ok := timePingTime(payload)
+	if !ok {
+		return
+	}
+
+	rtt := time.Since(sent)
+	// our own timestamp, so a negative rtt means the wall clock stepped
+	if rtt < 0 {
+		return
+	}
+
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	cs.rtt = smooth(cs.rtt, rtt, cs.hasRTT)
+	cs.hasRTT = true
+}
+
+func (cs *clockSync) observePing(payload []byte) {
+	peer, ok := timePingTime(payload)
+	if !ok {
+		return
+	}
+
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	if !cs.hasRTT {
+		return
+	}
+
+	offset := peer.Sub(time.Now().Add(-cs.rtt / 2))
+	cs.offset = smooth(cs.offset, offset, cs.hasOffset)
+	cs.hasOffset = true
+}
+
+func timePingTime(payload []byte) (time.Time, bool) {
+	if len(payload) != len(timePingMagic)+8 || !bytes.HasPrefix(payload, timePingMagic) {
+		return time.Time{}, false
+	}
+	ns := binary.BigEndian.Uint64(payload[len(timePingMagic):])
+	return time.Unix(0, int64(ns)), true
+}
+
+func smooth(avg, sample time.Duration, has bool) time.Duration {
+	if !has {
+		return sample
+	}
+	return avg + (sample-avg)/clockSmoothing
+}
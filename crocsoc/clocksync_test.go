@@ -0,0 +1,77 @@
+package crocsoc
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestClockSync(t *testing.T) {
+	var cs clockSync
+
+	// peer pings before we have an rtt are ignored
+	cs.observePing(timePing(time.Now()))
+	if cs.hasOffset {
+		t.Errorf("offset estimated without rtt")
+	}
+
+	cs.observePong(timePing(time.Now().Add(-20 * time.Millisecond)))
+	if cs.rtt < 20*time.Millisecond || cs.rtt > time.Second {
+		t.Errorf("want: ~20ms rtt, got: %v", cs.rtt)
+	}
+
+	// peer clock a second ahead, sent half an rtt ago
+	cs.observePing(timePing(time.Now().Add(time.Second - cs.rtt/2)))
+	if d := cs.offset - time.Second; d < -10*time.Millisecond || d > 10*time.Millisecond {
+		t.Errorf("want: ~1s offset, got: %v", cs.offset)
+	}
+
+	// one outlier only moves the estimate by an eighth
+	cs.observePing(timePing(time.Now().Add(9 * time.Second)))
+	if cs.offset > 2500*time.Millisecond {
+		t.Errorf("outlier not smoothed: %v", cs.offset)
+	}
+
+	// other pings leave the estimates alone
+	rtt := cs.rtt
+	cs.observePong([]byte("hello"))
+	if cs.rtt != rtt {
+		t.Errorf("rtt changed by a plain pong")
+	}
+}
+
+func TestSendTimePing(t *testing.T) {
+	serverConn, clientConn := net.Pipe()
+	defer serverConn.Close()
+	defer clientConn.Close()
+
+	server := &WSConn{Conn: serverConn}
+	client := &WSConn{Conn: clientConn, Role: RoleClient}
+
+	go func() {
+		// answers the ping, then closes
+		server.ReadMessage()
+	}()
+
+	if err := client.SendTimePing(); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	frame, err := readFrame(clientConn)
+	if err != nil || frame.Opcode != 0xA {
+		t.Fatalf("want pong, got: %v %v", frame, err)
+	}
+	client.clock.observePong(frame.Payload)
+
+	if client.RTT() <= 0 {
+		t.Errorf("want rtt, got: %v", client.RTT())
+	}
+	if _, ok := client.ClockOffset(); ok {
+		t.Errorf("offset without a peer time ping")
+	}
+}
+
+func timePing(at time.Time) []byte {
+	return binary.BigEndian.AppendUint64([]byte("CLK1"), uint64(at.UnixNano()))
+}
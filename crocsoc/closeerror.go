@@ -0,0 +1,49 @@
+package crocsoc
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"syscall"
+)
+
+// A connection that has ended, with the close code describing how. Writes
+// to a peer that went away without a close handshake fail with Code 1006
+// and the transport error in Err.
+type CloseError struct {
+	Code   uint16
+	Reason string
+	Err    error
+}
+
+func (e *CloseError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("websocket closed (%d %s): %v", e.Code, e.Reason, e.Err)
+	}
+	return fmt.Sprintf("websocket closed (%d %s)", e.Code, e.Reason)
+}
+
+func (e *CloseError) Unwrap() error {
+	return e.Err
+}
+
+/*
+1006 is a reserved value and MUST NOT be set as a status code in a
+Close control frame by an endpoint.  It is designated for use in
+applications expecting a status code to indicate that the
+connection was closed abnormally, e.g., without sending or
+receiving a Close control frame.
+*/
+func abnormalClosure(err error) *CloseError {
+	return &CloseError{Code: 1006, Reason: "abnormal closure", Err: err}
+}
+
+// whether a write failed because the peer hung up, with a FIN (EPIPE once
+// the peer has reset the half-open connection) or a RST
+func peerGone(err error) bool {
+	return errors.Is(err, syscall.EPIPE) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNABORTED) ||
+		errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrClosedPipe)
+}
@@ -0,0 +1,63 @@
+package crocsoc
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestWriteAfterPeerGone(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer ln.Close()
+
+	clientConn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	serverConn, err := ln.Accept()
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer serverConn.Close()
+
+	server := &WSConn{Conn: serverConn}
+
+	// FIN, the first writes may still be accepted before the peer's RST
+	clientConn.Close()
+
+	var werr error
+	for range 100 {
+		if werr = server.SendTextFrame([]byte("hello")); werr != nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	var cerr *CloseError
+	if !errors.As(werr, &cerr) || cerr.Code != 1006 {
+		t.Fatalf("want: CloseError 1006, got: %v", werr)
+	}
+
+	// later writes fail straight away with the same error
+	if err := server.SendTextFrame([]byte("again")); err != werr {
+		t.Errorf("want: %v, got: %v", werr, err)
+	}
+}
+
+func TestWriteAfterPeerGonePipe(t *testing.T) {
+	serverConn, clientConn := net.Pipe()
+	defer serverConn.Close()
+	clientConn.Close()
+
+	server := &WSConn{Conn: serverConn}
+
+	var cerr *CloseError
+	if err := server.SendTextFrame([]byte("hello")); !errors.As(err, &cerr) || cerr.Code != 1006 {
+		t.Errorf("want: CloseError 1006, got: %v", err)
+	}
+}
@@ -0,0 +1,88 @@
+package crocsoc
+
+import (
+	"errors"
+	"time"
+)
+
+/*
+Which close code a failure gets shouldn't depend on who wrote the handler.
+CloseWithError looks the error up in one table, by errors.Is, so wrapping
+a category error anywhere in a codebase gives the same close:
+
+	return fmt.Errorf("loading profile: %w", crocsoc.ErrAuthExpired)
+	...
+	conn.CloseWithError(err)   // 1008 {"code":"auth_expired"}
+
+Errors outside every category are internal: 1011 with no details.
+*/
+
+// Error categories with a close policy of their own, see CloseWithError.
+var (
+	ErrAuthExpired   = errors.New("auth expired")
+	ErrQuotaExceeded = errors.New("quota exceeded")
+	ErrInvalidInput  = errors.New("invalid input")
+)
+
+// How CloseWithError closes for a category of errors.
+type ClosePolicy struct {
+	Code uint16
+	// sent as the CloseReason code
+	Reason string
+	// sent as the CloseReason retryAfter when set
+	RetryAfter time.Duration
+}
+
+type closePolicyEntry struct {
+	target error
+	policy ClosePolicy
+}
+
+// checked in order, first match wins
+var closePolicies = []closePolicyEntry{
+	{ErrAuthExpired, ClosePolicy{Code: 1008, Reason: "auth_expired"}},
+	{ErrQuotaExceeded, ClosePolicy{Code: 1008, Reason: "quota_exceeded"}},
+	{ErrInvalidInput, ClosePolicy{Code: 1008, Reason: "invalid_input"}},
+}
+
+// Makes CloseWithError close with p for errors matching target by
+// errors.Is, replacing any policy target already had. Policies added later
+// are checked after earlier ones. Must be called before any connections are
+// served.
+func SetClosePolicy(target error, p ClosePolicy) {
+	for i, e := range closePolicies {
+		if e.target == target {
+			closePolicies[i].policy = p
+			return
+		}
+	}
+	closePolicies = append(closePolicies, closePolicyEntry{target, p})
+}
+
+// Returns the policy CloseWithError applies to err, false for internal
+// errors.
+func ClosePolicyFor(err error) (ClosePolicy, bool) {
+	for _, e := range closePolicies {
+		if errors.Is(err, e.target) {
+			return e.policy, true
+		}
+	}
+	return ClosePolicy{}, false
+}
+
+// Starts closing the connection because of err, with the code and reason
+// of its category's ClosePolicy. Errors in no category go through
+// CloseInternal. Keep reading to complete the close handshake.
+func (c *WSConn) CloseWithError(err error) error {
+	p, ok := ClosePolicyFor(err)
+	if !ok {
+		return c.CloseInternal(err)
+	}
+
+	c.logger().Info("closing on error", "error", err, "code", p.Code)
+
+	return c.CloseWithReason(p.Code, CloseReason{
+		Code:       p.Reason,
+		RetryAfter: int((p.RetryAfter + time.Second - 1) / time.Second),
+	})
+}
@@ -0,0 +1,48 @@
+package crocsoc
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestCloseWithError(t *testing.T) {
+	errBanned := errors.New("banned")
+	SetClosePolicy(errBanned, ClosePolicy{Code: 4003, Reason: "banned", RetryAfter: time.Hour})
+	defer func() { closePolicies = closePolicies[:len(closePolicies)-1] }()
+
+	tests := []struct {
+		err    error
+		code   uint16
+		reason string
+	}{
+		{fmt.Errorf("loading profile: %w", ErrAuthExpired), 1008, `{"code":"auth_expired"}`},
+		{ErrQuotaExceeded, 1008, `{"code":"quota_exceeded"}`},
+		{fmt.Errorf("bad field: %w", ErrInvalidInput), 1008, `{"code":"invalid_input"}`},
+		{errBanned, 4003, `{"code":"banned","retryAfter":3600}`},
+		{errors.New("db down"), 1011, internalErrorReason},
+	}
+
+	for _, tt := range tests {
+		serverConn, clientConn := net.Pipe()
+
+		server := &WSConn{Conn: serverConn}
+		go server.CloseWithError(tt.err)
+
+		f, err := readFrame(clientConn)
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+
+		code := binary.BigEndian.Uint16(f.Payload)
+		if reason := string(f.Payload[2:]); code != tt.code || reason != tt.reason {
+			t.Errorf("%v: want: %d %s, got: %d %s", tt.err, tt.code, tt.reason, code, reason)
+		}
+
+		serverConn.Close()
+		clientConn.Close()
+	}
+}
@@ -0,0 +1,79 @@
+package crocsoc
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+/*
+Close codes only say so much (1008 covers every policy violation), so
+clients can't tell a banned user from an exhausted quota. A structured
+reason carries a machine readable code as compact JSON in the close
+frame's reason, e.g.
+
+	{"code":"quota_exceeded","retryAfter":30}
+
+Clients that don't know about it still see a readable string.
+*/
+
+// most bytes of reason a close frame has room for, after the 2 byte code
+const maxCloseReason = 123
+
+// A machine readable close reason, see CloseWithReason.
+type CloseReason struct {
+	// application defined, e.g. "quota_exceeded"
+	Code string `json:"code"`
+	// seconds the client should wait before reconnecting, if set
+	RetryAfter int `json:"retryAfter,omitempty"`
+	// for humans, shortened to fit the frame if needed
+	Message string `json:"message,omitempty"`
+}
+
+// Encodes r as compact JSON fitting a close frame, cutting Message short
+// if needed. Fails when r does not fit even without Message.
+func EncodeCloseReason(r CloseReason) (string, error) {
+	for {
+		b, err := json.Marshal(r)
+		if err != nil {
+			return "", err
+		}
+		if len(b) <= maxCloseReason {
+			return string(b), nil
+		}
+
+		if r.Message == "" {
+			return "", fmt.Errorf("close reason exceeds %d bytes", maxCloseReason)
+		}
+
+		// drop a rune at a time, escaping makes the encoded length hard
+		// to predict
+		_, size := utf8.DecodeLastRuneInString(r.Message)
+		r.Message = r.Message[:len(r.Message)-size]
+	}
+}
+
+// Parses a close frame reason sent with CloseWithReason, e.g. an
+// EventClose's CloseReason. Reports false for plain text reasons.
+func ParseCloseReason(reason string) (CloseReason, bool) {
+	if !strings.HasPrefix(reason, "{") {
+		return CloseReason{}, false
+	}
+
+	var r CloseReason
+	if err := json.Unmarshal([]byte(reason), &r); err != nil || r.Code == "" {
+		return CloseReason{}, false
+	}
+	return r, true
+}
+
+// Starts the closing handshake with code and r encoded as the reason. Keep
+// reading to complete it.
+func (c *WSConn) CloseWithReason(code uint16, r CloseReason) error {
+	reason, err := EncodeCloseReason(r)
+	if err != nil {
+		return err
+	}
+	return c.SendCloseFrame(code, reason)
+}
@@ -0,0 +1,62 @@
+package crocsoc
+
+import (
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestEncodeCloseReason(t *testing.T) {
+	got, err := EncodeCloseReason(CloseReason{Code: "quota_exceeded", RetryAfter: 30})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if want := `{"code":"quota_exceeded","retryAfter":30}`; got != want {
+		t.Errorf("want: %s, got: %s", want, got)
+	}
+
+	// message cut to fit, without splitting a rune
+	long := CloseReason{Code: "banned", Message: strings.Repeat("é", 100)}
+	got, err = EncodeCloseReason(long)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if len(got) > maxCloseReason {
+		t.Errorf("reason is %d bytes", len(got))
+	}
+	r, ok := ParseCloseReason(got)
+	if !ok || r.Code != "banned" || !strings.HasPrefix(long.Message, r.Message) {
+		t.Errorf("unexpected reason: %+v", r)
+	}
+
+	if _, err := EncodeCloseReason(CloseReason{Code: strings.Repeat("x", 120)}); err == nil {
+		t.Errorf("oversized code accepted")
+	}
+}
+
+func TestParseCloseReason(t *testing.T) {
+	for _, plain := range []string{"", "Closing in response", "{not json", `{"message":"no code"}`} {
+		if _, ok := ParseCloseReason(plain); ok {
+			t.Errorf("%q parsed as structured", plain)
+		}
+	}
+}
+
+func TestCloseWithReason(t *testing.T) {
+	serverConn, clientConn := net.Pipe()
+	defer serverConn.Close()
+	defer clientConn.Close()
+
+	server := &WSConn{Conn: serverConn}
+	go server.CloseWithReason(1008, CloseReason{Code: "quota_exceeded", RetryAfter: 30})
+
+	f, err := readFrame(clientConn)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	r, ok := ParseCloseReason(string(f.Payload[2:]))
+	if !ok || r.Code != "quota_exceeded" || r.RetryAfter != 30 {
+		t.Errorf("unexpected reason: %+v", r)
+	}
+}
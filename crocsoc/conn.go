@@ -0,0 +1,75 @@
+package crocsoc
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+)
+
+// Message types of Conn.ReadMessage and Conn.WriteMessage, the opcodes of
+// the frames carrying them.
+const (
+	TextMessage   = 0x1
+	BinaryMessage = 0x2
+	CloseMessage  = 0x8
+	PingMessage   = 0x9
+	PongMessage   = 0xA
+)
+
+// A WebSocket connection with one symmetric message API for both roles:
+//
+//	for {
+//		mt, data, err := conn.ReadMessage()
+//		if err != nil {
+//			return err
+//		}
+//		conn.WriteMessage(mt, data)
+//	}
+//
+// The embedded WSConn gives access to everything else, such as close
+// handshakes, interceptors and events.
+type Conn struct {
+	*WSConn
+
+	// the message NextReader returned last, discarded before the next read
+	reader *messageReader
+}
+
+// Wraps an established connection, e.g. one hijacked after the opening
+// handshake. rw may be nil.
+func NewConn(netConn net.Conn, rw *bufio.ReadWriter, role Role) *Conn {
+	return &Conn{WSConn: &WSConn{Conn: netConn, RW: rw, Role: role}}
+}
+
+// Reads the next text or binary message, answering control frames that
+// arrive in between. Returns io.EOF once the connection has closed.
+func (c *Conn) ReadMessage() (msgType int, data []byte, err error) {
+	if err := c.discardReader(); err != nil {
+		return 0, nil, err
+	}
+
+	opcode, data, err := c.readMessage()
+	return int(opcode), data, err
+}
+
+// Sends data as one message of msgType. For CloseMessage data is the close
+// frame payload, see FormatCloseMessage. Safe for concurrent use.
+func (c *Conn) WriteMessage(msgType int, data []byte) error {
+	switch msgType {
+	case TextMessage, BinaryMessage, CloseMessage, PingMessage, PongMessage:
+	default:
+		return fmt.Errorf("unknown message type %d", msgType)
+	}
+
+	return c.WriteFrame(&Frame{Fin: true, Opcode: byte(msgType), Payload: data})
+}
+
+// Returns the payload of a close frame with code and reason.
+func FormatCloseMessage(code uint16, reason string) []byte {
+	return closeFrame(code, reason).Payload
+}
+
+// Returns the underlying network connection.
+func (c *Conn) NetConn() net.Conn {
+	return c.WSConn.Conn
+}
@@ -0,0 +1,53 @@
+package crocsoc
+
+import (
+	"errors"
+	"io"
+	"net"
+	"testing"
+)
+
+func TestConnMessages(t *testing.T) {
+	serverConn, clientConn := net.Pipe()
+	defer serverConn.Close()
+	defer clientConn.Close()
+
+	server := NewConn(serverConn, nil, RoleServer)
+	client := NewConn(clientConn, nil, RoleClient)
+
+	// echo
+	go func() {
+		for {
+			mt, data, err := server.ReadMessage()
+			if err != nil {
+				return
+			}
+			server.WriteMessage(mt, data)
+		}
+	}()
+
+	for _, mt := range []int{TextMessage, BinaryMessage} {
+		go client.WriteMessage(mt, []byte("hi"))
+
+		got, data, err := client.ReadMessage()
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		if got != mt || string(data) != "hi" {
+			t.Errorf("want: %d hi, got: %d %s", mt, got, data)
+		}
+	}
+
+	if err := client.WriteMessage(0x3, nil); err == nil {
+		t.Errorf("reserved opcode sent")
+	}
+
+	go client.WriteMessage(CloseMessage, FormatCloseMessage(1000, "bye"))
+	if _, _, err := client.ReadMessage(); !errors.Is(err, io.EOF) {
+		t.Errorf("want: EOF, got: %v", err)
+	}
+
+	if client.NetConn() != clientConn {
+		t.Errorf("NetConn is not the wrapped connection")
+	}
+}
@@ -3,18 +3,169 @@ package crocsoc
 import (
 	"net"
 	"bufio"
+	"fmt"
+	"io"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pgxtips/crocsoc/crocsoc/ratelimit"
 )
 
+// Which end of the connection a WSConn is. The role decides whether
+// outgoing frames are masked and who closes the TCP connection once the
+// close handshake completes.
+type Role int
+
+const (
+	RoleServer Role = iota
+	RoleClient
+)
+
+// how long a client waits for the server to close the TCP connection after
+// the close handshake before closing it itself
+const closeTimeout = 5 * time.Second
+
 type WSConn struct {
+	// process unique, assigned by WsHandler
+	ID   uint64
 	Conn net.Conn
 	RW   *bufio.ReadWriter
 	Subprotocol string
 	IsClosed    bool
+	Role        Role
+
+	// X-Request-ID of the upgrade request, or generated when absent
+	RequestID string
+	// whether the client connected over TLS, directly or via a trusted proxy
+	Secure bool
+	// whether permessage-deflate was negotiated, see Upgrader.EnableCompression
+	Compression bool
+	// carries the connection's id and request id, defaults to slog.Default
+	Logger *slog.Logger
+
+	// how long a frame write may take, including retries of transient
+	// errors; no deadline when 0
+	WriteTimeout time.Duration
+
+	// close with 1003 on binary messages
+	TextOnly bool
+	// close with 1003 on text messages containing NUL bytes
+	SniffText bool
+
+	// see SetReadLimit
+	readLimit atomic.Int64
+	// see SetRateLimit, nil for none
+	rateLimit atomic.Pointer[ratelimit.TokenBucket]
+	// see SetPingInterval
+	pinger pinger
+
+	closeReceived bool
+
+	// set by Events
+	events chan Event
+
+	// registered with InterceptInbound/InterceptOutbound
+	inbound  []FrameInterceptor
+	outbound []FrameInterceptor
+
+	// estimates from time pings, see SendTimePing
+	clock clockSync
+
+	// non-nil while reads are paused, closed by ResumeReads
+	pauseMu sync.Mutex
+	paused  chan struct{}
+
+	// guards writes to Conn and writeErr
+	writeMu sync.Mutex
+	// set once a close frame has been sent, or by Terminate without one
+	closeSent atomic.Bool
+	// set once a write finds the peer gone
+	writeErr *CloseError
+	// why we failed the connection with 1011, see CloseInternal
+	internalErr error
+	// accept inbound frames whether masked or not, for ReadMessage
+	anyMasking bool
 }
 
-func ServeConn(conn WSConn) {
+// Reads from conn until it closes, answering pings and the closing
+// handshake. Messages are discarded, handlers wanting them should use an
+// Upgrader instead.
+func ServeConn(conn *WSConn) {
 	defer conn.Conn.Close()
+	for _, err := range conn.Messages() {
+		if err != nil {
+			conn.logger().Debug("connection ended", "err", err)
+		}
+	}
+}
+
+/*
+The underlying TCP connection, in most normal cases, SHOULD be closed
+first by the server, so that it holds the TIME_WAIT state and not the
+client (as this would prevent it from re-opening the connection for 2
+maximum segment lifetimes (2MSL), while there is no corresponding
+server impact as a TIME_WAIT connection is immediately reopened upon
+a new SYN with a higher seq number).
+*/
+func (c *WSConn) closeTCP() {
+	if c.Role == RoleClient {
+		// wait for the server to close first, without hanging forever
+		c.Conn.SetReadDeadline(time.Now().Add(closeTimeout))
+		io.Copy(io.Discard, c.Conn)
+	}
+
+	c.Conn.Close()
+	c.IsClosed = true
+}
+
+// Starts the closing handshake by sending a close frame while leaving the
+// read side open, so messages the peer sends before its own close frame can
+// still be collected with ReadMessage. ReadMessage returns io.EOF once the
+// peer has answered and the connection is torn down.
+func (c *WSConn) CloseWrite(code uint16, reason string) error {
+	// control frame payloads are limited to 125 bytes, 2 of which are the code
+	if len(reason) > 123 {
+		return fmt.Errorf("close reason exceeds 123 bytes")
+	}
+
+	return c.SendCloseFrame(code, reason)
+}
+
+func (c *WSConn) sentClose() bool {
+	return c.closeSent.Load()
+}
+
+// Tears the connection down immediately without a close handshake. The TCP
+// linger time is set to zero so the kernel discards unsent data and resets
+// the connection rather than lingering in FIN_WAIT/TIME_WAIT; meant for
+// abusive peers that should not be given a graceful close.
+// Doesn't wait for writes in progress, so a peer that stopped reading can't
+// hold it up; they fail once the connection is closed.
+func (c *WSConn) Terminate() error {
+	c.closeSent.Store(true)
+
+	// skip the TLS close_notify alert, it is still a write to the peer
+	conn := c.Conn
 	for {
+		tc, ok := conn.(interface{ NetConn() net.Conn })
+		if !ok {
+			break
+		}
+		conn = tc.NetConn()
+	}
+
+	if tcp, ok := conn.(*net.TCPConn); ok {
+		tcp.SetLinger(0)
+	}
+
+	return conn.Close()
+}
 
+func (c *WSConn) logger() *slog.Logger {
+	if c.Logger != nil {
+		return c.Logger
 	}
+	return slog.Default()
 }
@@ -0,0 +1,122 @@
+package crocsoc
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestTerminate(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer ln.Close()
+
+	clientConn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer clientConn.Close()
+
+	serverConn, err := ln.Accept()
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	server := &WSConn{Conn: serverConn}
+	if err := server.Terminate(); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	// peer sees a reset rather than an orderly FIN
+	_, err = clientConn.Read(make([]byte, 1))
+	if !errors.Is(err, syscall.ECONNRESET) {
+		t.Errorf("want: connection reset, got: %v", err)
+	}
+
+	if err := server.SendTextFrame([]byte("late")); err == nil {
+		t.Errorf("write after terminate accepted")
+	}
+}
+
+func TestTerminateStuckWriter(t *testing.T) {
+	serverConn, clientConn := net.Pipe()
+	defer clientConn.Close()
+
+	server := &WSConn{Conn: serverConn}
+
+	// the peer never reads, so this write holds the write lock
+	written := make(chan error, 1)
+	go func() { written <- server.SendTextFrame([]byte("stuck")) }()
+	time.Sleep(10 * time.Millisecond)
+
+	done := make(chan error, 1)
+	go func() { done <- server.Terminate() }()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("Terminate blocked behind a stuck write")
+	}
+
+	if err := <-written; err == nil {
+		t.Errorf("stuck write succeeded after terminate")
+	}
+}
+
+func TestServeConn(t *testing.T) {
+	serverConn, clientConn := net.Pipe()
+	defer clientConn.Close()
+
+	done := make(chan struct{})
+	go func() {
+		ServeConn(&WSConn{Conn: serverConn})
+		close(done)
+	}()
+
+	client := &WSConn{Conn: clientConn, Role: RoleClient}
+	if err := client.SendTextFrame([]byte("hello")); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if err := client.SendCloseFrame(1000, ""); err != nil {
+		t.Fatalf("%v", err)
+	}
+	// the close reply
+	client.ReadMessage()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Errorf("ServeConn still running after the close handshake")
+	}
+}
+
+func TestWrongMasking(t *testing.T) {
+	for _, role := range []Role{RoleServer, RoleClient} {
+		local, remote := net.Pipe()
+
+		// the peer masks exactly when it shouldn't
+		go writeFrame(remote, &Frame{Fin: true, Opcode: 0x1, Payload: []byte("hello")}, role == RoleClient, time.Time{})
+
+		closed := make(chan *Frame, 1)
+		go func() {
+			f, _ := readFrame(remote)
+			closed <- f
+		}()
+
+		conn := &WSConn{Conn: local, Role: role}
+		if _, err := conn.ReadMessage(); !errors.Is(err, ErrMasking) {
+			t.Errorf("role %v: want: %v, got: %v", role, ErrMasking, err)
+		}
+
+		f := <-closed
+		if f == nil || f.Opcode != 0x8 || binary.BigEndian.Uint16(f.Payload) != 1002 {
+			t.Errorf("role %v: want: 1002 close, got: %+v", role, f)
+		}
+		remote.Close()
+	}
+}
@@ -0,0 +1,147 @@
+package crocsoc
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"sync"
+)
+
+/*
+Credit based flow control, for clients too slow to keep up (a background
+browser tab) that shouldn't lose messages either. The server starts with a
+window of N credits, spends one per message and waits at zero; the client
+grants credits back as it finishes processing messages, with a text
+message of the ASCII ACK byte followed by the count in decimal:
+
+	"\x06" "5"     5 more messages may be sent
+
+Browsers can't send pings or pongs, so grants travel as data messages and
+are taken out of the inbound stream before the application sees them. In
+JavaScript:
+
+	ws.onmessage = (ev) => { handle(ev.data); ws.send("\x06" + "1"); };
+
+Both sides opt in by announcing CapCreditFlow during Negotiate.
+*/
+
+// Capability announced in Hellos by sides speaking credit flow control.
+const CapCreditFlow = "credit-flow"
+
+// first byte of a credit grant message
+const creditGrant = 0x06
+
+// Sends messages to one connection no faster than it grants credits.
+type CreditSender struct {
+	Conn *WSConn
+
+	// false when the client didn't announce CapCreditFlow
+	limited bool
+
+	mu      sync.Mutex
+	credits int
+	closed  bool
+	// closed and replaced whenever credits arrive or the conn closes
+	wake chan struct{}
+}
+
+// Starts credit flow control on conn with window initial credits, if both
+// sides announced CapCreditFlow; otherwise sends are never held back.
+// Must be called before conn is read from, grants are taken out of its
+// inbound messages.
+func NewCreditSender(conn *WSConn, agreed Agreement, window int) *CreditSender {
+	s := &CreditSender{
+		Conn:    conn,
+		limited: agreed.Has(CapCreditFlow),
+		credits: window,
+		wake:    make(chan struct{}),
+	}
+
+	if s.limited {
+		conn.InterceptInbound(s.intercept)
+	}
+	return s
+}
+
+// Sends a text message once a credit is available, or returns ctx's error.
+func (s *CreditSender) SendText(ctx context.Context, data []byte) error {
+	if err := s.acquire(ctx); err != nil {
+		return err
+	}
+	return s.Conn.SendTextFrame(data)
+}
+
+// Sends a binary message once a credit is available, or returns ctx's
+// error.
+func (s *CreditSender) SendBinary(ctx context.Context, data []byte) error {
+	if err := s.acquire(ctx); err != nil {
+		return err
+	}
+	return s.Conn.SendBinaryFrame(data)
+}
+
+// Returns the number of messages that can be sent without waiting.
+func (s *CreditSender) Credits() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.credits
+}
+
+// Grants the sending side n more credits, for clients written in Go.
+func GrantCredits(conn *WSConn, n int) error {
+	return conn.SendTextFrame(strconv.AppendInt([]byte{creditGrant}, int64(n), 10))
+}
+
+func (s *CreditSender) acquire(ctx context.Context) error {
+	if !s.limited {
+		return nil
+	}
+
+	for {
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			return net.ErrClosed
+		}
+		if s.credits > 0 {
+			s.credits--
+			s.mu.Unlock()
+			return nil
+		}
+		wake := s.wake
+		s.mu.Unlock()
+
+		select {
+		case <-wake:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// takes grants out of the inbound stream, and wakes waiting senders when
+// the peer closes
+func (s *CreditSender) intercept(f *Frame) (*Frame, error) {
+	switch {
+	case f.Opcode == 0x8:
+		s.update(func() { s.closed = true })
+	case f.Opcode == 0x1 && f.Fin && len(f.Payload) > 1 && f.Payload[0] == creditGrant:
+		n, err := strconv.Atoi(string(f.Payload[1:]))
+		if err != nil || n <= 0 {
+			// not a grant after all
+			return f, nil
+		}
+		s.update(func() { s.credits += n })
+		return nil, nil
+	}
+	return f, nil
+}
+
+func (s *CreditSender) update(fn func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	fn()
+	close(s.wake)
+	s.wake = make(chan struct{})
+}
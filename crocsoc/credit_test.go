@@ -0,0 +1,92 @@
+package crocsoc
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestCreditSender(t *testing.T) {
+	serverConn, clientConn := net.Pipe()
+	defer serverConn.Close()
+	defer clientConn.Close()
+
+	server := &WSConn{Conn: serverConn}
+	sender := NewCreditSender(server, Agreement{Capabilities: []string{CapCreditFlow}}, 2)
+
+	// the server reads grants and whatever else the client sends
+	msgs := make(chan string, 1)
+	go func() {
+		for {
+			msg, err := server.ReadMessage()
+			if err != nil {
+				return
+			}
+			msgs <- string(msg)
+		}
+	}()
+
+	client := &WSConn{Conn: clientConn, Role: RoleClient}
+	received := make(chan string, 10)
+	go func() {
+		for {
+			msg, err := client.ReadMessage()
+			if err != nil {
+				return
+			}
+			received <- string(msg)
+		}
+	}()
+
+	ctx := context.Background()
+	sender.SendText(ctx, []byte("a"))
+	sender.SendText(ctx, []byte("b"))
+
+	// window spent
+	short, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	if err := sender.SendText(short, []byte("c")); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("want: DeadlineExceeded, got: %v", err)
+	}
+
+	sent := make(chan error)
+	go func() { sent <- sender.SendText(ctx, []byte("c")) }()
+
+	GrantCredits(client, 1)
+	if err := <-sent; err != nil {
+		t.Errorf("%v", err)
+	}
+
+	for _, want := range []string{"a", "b", "c"} {
+		if got := <-received; got != want {
+			t.Errorf("want: %s, got: %s", want, got)
+		}
+	}
+
+	// grants never reach the application, other messages do
+	client.SendTextFrame([]byte("\x06nope"))
+	if got := <-msgs; got != "\x06nope" {
+		t.Errorf("want: \\x06nope, got: %q", got)
+	}
+	if n := sender.Credits(); n != 0 {
+		t.Errorf("want: 0, got: %d", n)
+	}
+}
+
+func TestCreditSenderUnlimited(t *testing.T) {
+	serverConn, clientConn := net.Pipe()
+	defer serverConn.Close()
+	defer clientConn.Close()
+
+	// client didn't announce the capability
+	sender := NewCreditSender(&WSConn{Conn: serverConn}, Agreement{}, 0)
+	go (&WSConn{Conn: clientConn, Role: RoleClient}).ReadMessage()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := sender.SendText(ctx, []byte("a")); err != nil {
+		t.Errorf("%v", err)
+	}
+}
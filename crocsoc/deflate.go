@@ -0,0 +1,253 @@
+package crocsoc
+
+import (
+	"bytes"
+	"compress/flate"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+/*
+permessage-deflate (RFC 7692). Negotiated through Sec-WebSocket-Extensions,
+after which the first frame of a compressed message has RSV1 set and the
+message's payload is DEFLATE data, with the empty block that ends a flush
+stripped from the end:
+
+	7.2.1.  Compression
+
+	   1.  Compress all the octets of the payload of the message using
+	       DEFLATE.
+
+	   2.  If the resulting data does not end with an empty DEFLATE block
+	       with no compression (the "BTYPE" bits are set to 00), append an
+	       empty DEFLATE block with no compression to the tail end.
+
+	   3.  Remove 4 octets (that are 0x00 0x00 0xff 0xff) from the tail end.
+	       After this step, the last octet of the compressed data contains
+	       (possibly part of) the DEFLATE header bits with the "BTYPE" bits
+	       set to 00.
+
+	7.2.2.  Decompression
+
+	   1.  Append 4 octets of 0x00 0x00 0xff 0xff to the tail end of the
+	       payload of the message.
+
+	   2.  Decompress the resulting data using DEFLATE.
+
+Every message is compressed on its own: both sides are asked to drop their
+sliding window between messages (server_no_context_takeover,
+client_no_context_takeover), which costs some ratio but no per-connection
+compressor state. Only messages sent in a single frame are compressed, and
+small ones aren't worth it.
+
+Inbound messages that fit in one frame are inflated before interceptors
+see them. Interceptors see the fragments of a compressed fragmented message
+as they arrived, with RSV1 set on the first, and it is inflated once
+reassembled.
+*/
+
+const deflateExtension = "permessage-deflate"
+
+// messages shorter than this are sent uncompressed
+const minCompressSize = 64
+
+// how far a message may inflate on a connection without a read limit, so a
+// few KB of compressed zeros can't take all the memory
+const maxInflateSize = 32 << 20
+
+// the empty stored block ending a flush, stripped from compressed payloads
+var deflateTail = []byte{0x00, 0x00, 0xff, 0xff}
+
+// a final empty stored block, so the inflater reports io.EOF at the end of a
+// message rather than io.ErrUnexpectedEOF
+var deflateFinal = []byte{0x01, 0x00, 0x00, 0xff, 0xff}
+
+// flate writers allocate over half a megabyte, so share them
+var flateWriters = sync.Pool{
+	New: func() any {
+		fw, _ := flate.NewWriter(nil, flate.BestSpeed)
+		return fw
+	},
+}
+
+// Returned by ReadMessage for frames with RSV bits no negotiated extension
+// defines, after sending a 1002 close.
+var ErrReservedBits = errors.New("reserved bits set")
+
+// Returns the Sec-WebSocket-Extensions response value accepting r's first
+// acceptable permessage-deflate offer, "" when there is none.
+func negotiateDeflate(r *http.Request) string {
+	offers, err := ParseExtensions(r.Header.Values("Sec-WebSocket-Extensions")...)
+	if err != nil {
+		return ""
+	}
+
+	for _, offer := range offers {
+		if strings.EqualFold(offer.Name, deflateExtension) && deflateOfferOK(offer) {
+			return deflateResponse
+		}
+	}
+	return ""
+}
+
+// offered by Dial and sent back by Upgrader: both sides compress every
+// message on its own
+const deflateResponse = "permessage-deflate; server_no_context_takeover; client_no_context_takeover"
+
+// whether an offer's parameters can be agreed to, see RFC 7692 7.1
+func deflateOfferOK(offer Extension) bool {
+	seen := map[string]bool{}
+
+	for _, p := range offer.Params {
+		name := strings.ToLower(p.Name)
+		// a parameter given twice declines the offer
+		if seen[name] {
+			return false
+		}
+		seen[name] = true
+
+		switch name {
+		case "server_no_context_takeover", "client_no_context_takeover":
+			if p.Value != "" {
+				return false
+			}
+		case "server_max_window_bits":
+			// flate always compresses with a 32KB window
+			if p.Value != "15" {
+				return false
+			}
+		case "client_max_window_bits":
+			// the inflater handles any window up to 32KB
+			if p.Value != "" && !validWindowBits(p.Value) {
+				return false
+			}
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func validWindowBits(v string) bool {
+	switch v {
+	case "8", "9", "10", "11", "12", "13", "14", "15":
+		return true
+	}
+	return false
+}
+
+// Checks the server's Sec-WebSocket-Extensions response to a client that
+// offered deflateResponse (offered) or nothing, returning whether
+// permessage-deflate is in use.
+func acceptDeflateResponse(values []string, offered bool) (bool, error) {
+	exts, err := ParseExtensions(values...)
+	if err != nil {
+		return false, err
+	}
+	if len(exts) == 0 {
+		return false, nil
+	}
+
+	if !offered || len(exts) > 1 || !strings.EqualFold(exts[0].Name, deflateExtension) {
+		return false, fmt.Errorf("unrequested extensions %q", FormatExtensions(exts))
+	}
+
+	// the server must agree to drop its window, the client may always
+	// drop its own
+	ext := exts[0]
+	if _, ok := ext.Param("server_no_context_takeover"); !ok {
+		return false, fmt.Errorf("server kept context takeover")
+	}
+	for _, p := range ext.Params {
+		switch strings.ToLower(p.Name) {
+		case "server_no_context_takeover", "client_no_context_takeover":
+		case "server_max_window_bits", "client_max_window_bits":
+			if !validWindowBits(p.Value) {
+				return false, fmt.Errorf("invalid %s %q", p.Name, p.Value)
+			}
+		default:
+			return false, fmt.Errorf("unknown permessage-deflate parameter %q", p.Name)
+		}
+	}
+	return true, nil
+}
+
+// compresses one message's payload
+func deflate(p []byte) ([]byte, error) {
+	var buf bytes.Buffer
+
+	fw := flateWriters.Get().(*flate.Writer)
+	defer flateWriters.Put(fw)
+	fw.Reset(&buf)
+
+	if _, err := fw.Write(p); err != nil {
+		return nil, err
+	}
+	if err := fw.Flush(); err != nil {
+		return nil, err
+	}
+
+	return bytes.TrimSuffix(buf.Bytes(), deflateTail), nil
+}
+
+// decompresses one message's payload, failing with errFrameTooBig if it
+// inflates past limit. A negative limit means maxInflateSize.
+func inflate(p []byte, limit int64) ([]byte, error) {
+	limit = inflateLimit(limit)
+
+	fr := flate.NewReader(io.MultiReader(bytes.NewReader(p), bytes.NewReader(deflateTail), bytes.NewReader(deflateFinal)))
+	defer fr.Close()
+
+	out, err := io.ReadAll(io.LimitReader(fr, limit+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to inflate message: %v", err)
+	}
+	if int64(len(out)) > limit {
+		return nil, errFrameTooBig
+	}
+	return out, nil
+}
+
+// returns the limit on inflated bytes for limit, as returned by
+// remainingAfter
+func inflateLimit(limit int64) int64 {
+	if limit < 0 {
+		return maxInflateSize
+	}
+	return limit
+}
+
+// returns f compressed when permessage-deflate is on and the message is
+// worth compressing
+func (c *WSConn) deflateFrame(f *Frame) (*Frame, error) {
+	if !c.Compression || !f.Fin || (f.Opcode != 0x1 && f.Opcode != 0x2) || len(f.Payload) < minCompressSize {
+		return f, nil
+	}
+
+	payload, err := deflate(f.Payload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compress message: %v", err)
+	}
+	return &Frame{Fin: true, Rsv: 0x4, Opcode: f.Opcode, Payload: payload}, nil
+}
+
+// checks a frame's RSV bits against the negotiated extensions, failing the
+// connection with 1002 when they are wrong. first says whether the frame
+// starts a message.
+func (c *WSConn) checkReserved(f *Frame, first bool) error {
+	if f.Rsv == 0 {
+		return nil
+	}
+	// RSV1 marks a compressed message, on its first frame only
+	if f.Rsv == 0x4 && c.Compression && first && !isControlFrame(f) {
+		return nil
+	}
+
+	c.logger().Warn("rejecting frame", "reason", "reserved bits", "rsv", f.Rsv)
+	c.SendCloseFrame(1002, "reserved bits set")
+	return fmt.Errorf("%w: %#x", ErrReservedBits, f.Rsv)
+}
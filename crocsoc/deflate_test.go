@@ -0,0 +1,218 @@
+package crocsoc
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNegotiateDeflate(t *testing.T) {
+	tests := []struct {
+		offer string
+		ok    bool
+	}{
+		{"permessage-deflate", true},
+		{"permessage-deflate; client_max_window_bits", true},
+		{"permessage-deflate; client_max_window_bits=10; server_no_context_takeover", true},
+		{"permessage-deflate; server_max_window_bits=10, permessage-deflate", true},
+		{"permessage-deflate; server_max_window_bits=10", false},
+		{"permessage-deflate; client_no_context_takeover; client_no_context_takeover", false},
+		{"permessage-deflate; mystery", false},
+		{"x-webkit-deflate-frame", false},
+		{"", false},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("Sec-WebSocket-Extensions", tt.offer)
+
+		if got := negotiateDeflate(r); (got != "") != tt.ok {
+			t.Errorf("%q: want: %v, got: %q", tt.offer, tt.ok, got)
+		}
+	}
+}
+
+func TestDeflateRoundTrip(t *testing.T) {
+	msg := bytes.Repeat([]byte("hello crocsoc "), 100)
+
+	compressed, err := deflate(msg)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if len(compressed) >= len(msg) || bytes.HasSuffix(compressed, deflateTail) {
+		t.Errorf("unexpected compressed payload of %d bytes", len(compressed))
+	}
+
+	got, err := inflate(compressed, -1)
+	if err != nil || !bytes.Equal(got, msg) {
+		t.Errorf("want: %d bytes, got: %d bytes %v", len(msg), len(got), err)
+	}
+
+	if _, err := inflate(compressed, int64(len(msg)-1)); !errors.Is(err, errFrameTooBig) {
+		t.Errorf("want: errFrameTooBig, got: %v", err)
+	}
+}
+
+func TestInflateDefaultLimit(t *testing.T) {
+	// a few KB compressed, 33MB inflated
+	bomb, err := deflate(make([]byte, maxInflateSize+1<<20))
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	if _, err := inflate(bomb, -1); !errors.Is(err, errFrameTooBig) {
+		t.Errorf("want: errFrameTooBig, got: %v", err)
+	}
+}
+
+func TestCompression(t *testing.T) {
+	upgrader := Upgrader{EnableCompression: true}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.NetConn().Close()
+
+		for {
+			mt, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			conn.WriteMessage(mt, data)
+		}
+	}))
+	defer srv.Close()
+
+	conn, resp, err := Dial(context.Background(), srv.URL, &DialOptions{EnableCompression: true})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer conn.NetConn().Close()
+
+	if !conn.Compression || !strings.HasPrefix(resp.Header.Get("Sec-WebSocket-Extensions"), "permessage-deflate") {
+		t.Fatalf("compression not negotiated: %q", resp.Header.Get("Sec-WebSocket-Extensions"))
+	}
+
+	for _, msg := range []string{"short", strings.Repeat("compress me ", 1000)} {
+		conn.WriteMessage(TextMessage, []byte(msg))
+
+		mt, data, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		if mt != TextMessage || string(data) != msg {
+			t.Errorf("want: %d bytes, got: %d bytes", len(msg), len(data))
+		}
+	}
+
+	// not offered, not used
+	plain, resp, err := Dial(context.Background(), srv.URL, nil)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer plain.NetConn().Close()
+
+	if plain.Compression || resp.Header.Get("Sec-WebSocket-Extensions") != "" {
+		t.Errorf("compression negotiated without an offer")
+	}
+}
+
+func TestCompressedFrames(t *testing.T) {
+	serverConn, clientConn := net.Pipe()
+	defer serverConn.Close()
+	defer clientConn.Close()
+
+	server := &WSConn{Conn: serverConn, Compression: true}
+	msg := bytes.Repeat([]byte("abc"), 100)
+
+	// outbound messages carry RSV1 and shrink
+	go server.SendTextFrame(msg)
+	f, err := readFrame(clientConn)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if f.Rsv != 0x4 || len(f.Payload) >= len(msg) {
+		t.Errorf("want: compressed frame, got: rsv %#x, %d bytes", f.Rsv, len(f.Payload))
+	}
+
+	// a compressed message split over two frames
+	compressed, _ := deflate(msg)
+	go func() {
+		half := len(compressed) / 2
+		writeFrame(clientConn, &Frame{Rsv: 0x4, Opcode: 0x1, Payload: compressed[:half]}, true, time.Time{})
+		writeFrame(clientConn, &Frame{Fin: true, Opcode: 0x0, Payload: compressed[half:]}, true, time.Time{})
+	}()
+
+	got, err := server.ReadMessage()
+	if err != nil || !bytes.Equal(got, msg) {
+		t.Errorf("want: %d bytes, got: %d bytes %v", len(msg), len(got), err)
+	}
+}
+
+func TestCompressedTooBig(t *testing.T) {
+	serverConn, clientConn := net.Pipe()
+	defer serverConn.Close()
+	defer clientConn.Close()
+
+	server := &WSConn{Conn: serverConn, Compression: true}
+	server.SetReadLimit(1000)
+
+	// a few hundred bytes that inflate to a megabyte
+	bomb, _ := deflate(make([]byte, 1<<20))
+	go writeFrame(clientConn, &Frame{Fin: true, Rsv: 0x4, Opcode: 0x2, Payload: bomb}, true, time.Time{})
+	go readFrame(clientConn)
+
+	if _, err := server.ReadMessage(); !errors.Is(err, ErrMessageTooBig) {
+		t.Errorf("want: ErrMessageTooBig, got: %v", err)
+	}
+}
+
+func TestReservedBits(t *testing.T) {
+	tests := []struct {
+		compression bool
+		frame       *Frame
+	}{
+		// RSV1 without permessage-deflate
+		{false, &Frame{Fin: true, Rsv: 0x4, Opcode: 0x1, Payload: []byte("x")}},
+		// RSV2 and RSV3 are never defined
+		{true, &Frame{Fin: true, Rsv: 0x2, Opcode: 0x1, Payload: []byte("x")}},
+		{true, &Frame{Fin: true, Rsv: 0x1, Opcode: 0x1, Payload: []byte("x")}},
+		// RSV1 on a control frame
+		{true, &Frame{Fin: true, Rsv: 0x4, Opcode: 0x9}},
+	}
+
+	for _, tt := range tests {
+		serverConn, clientConn := net.Pipe()
+
+		server := &WSConn{Conn: serverConn, Compression: tt.compression}
+		go writeFrame(clientConn, tt.frame, true, time.Time{})
+
+		closed := make(chan uint16, 1)
+		go func() {
+			f, err := readFrame(clientConn)
+			if err == nil && f.Opcode == 0x8 {
+				closed <- binary.BigEndian.Uint16(f.Payload)
+			}
+			close(closed)
+		}()
+
+		if _, err := server.ReadMessage(); !errors.Is(err, ErrReservedBits) {
+			t.Errorf("rsv %#x: want: ErrReservedBits, got: %v", tt.frame.Rsv, err)
+		}
+		if code := <-closed; code != 1002 {
+			t.Errorf("rsv %#x: want: 1002, got: %d", tt.frame.Rsv, code)
+		}
+
+		serverConn.Close()
+		clientConn.Close()
+	}
+}
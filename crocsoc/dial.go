@@ -0,0 +1,144 @@
+package crocsoc
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// Options for Dial, the zero value dials with defaults.
+type DialOptions struct {
+	// extra upgrade request headers, e.g. Authorization or Origin
+	Header http.Header
+	// offered in Sec-WebSocket-Protocol, in order of preference
+	Subprotocols []string
+	// offer permessage-deflate, used when the server accepts it. Without
+	// SetReadLimit, messages may inflate to 32MB.
+	EnableCompression bool
+
+	// used for wss:// URLs, ServerName defaults to the URL's host
+	TLSConfig *tls.Config
+	// dials the TCP connection, net.Dialer's DialContext by default
+	NetDialContext func(ctx context.Context, network, addr string) (net.Conn, error)
+}
+
+// Opens a client connection to a ws:// or wss:// URL, cancelling the dial
+// and handshake with ctx. The handshake response is returned whenever one
+// was read, so callers can look at a rejection's status and headers; a
+// rejection's error is a *HandshakeError.
+func Dial(ctx context.Context, rawURL string, opts *DialOptions) (*Conn, *http.Response, error) {
+	if opts == nil {
+		opts = &DialOptions{}
+	}
+
+	wk := GenerateKey()
+
+	req, err := BuildUpgradeRequest(rawURL, wk, opts.Header)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(opts.Subprotocols) > 0 {
+		req.Header.Set("Sec-WebSocket-Protocol", strings.Join(opts.Subprotocols, ", "))
+	}
+	if opts.EnableCompression {
+		req.Header.Set("Sec-WebSocket-Extensions", deflateResponse)
+	}
+
+	host := req.URL.Host
+	if req.URL.Port() == "" {
+		if req.URL.Scheme == "https" {
+			host = net.JoinHostPort(req.URL.Hostname(), "443")
+		} else {
+			host = net.JoinHostPort(req.URL.Hostname(), "80")
+		}
+	}
+
+	dial := opts.NetDialContext
+	if dial == nil {
+		var d net.Dialer
+		dial = d.DialContext
+	}
+
+	conn, err := dial(ctx, "tcp", host)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to dial %s: %v", host, err)
+	}
+
+	// unblocks the handshake when ctx ends part way through
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	if req.URL.Scheme == "https" {
+		cfg := &tls.Config{}
+		if opts.TLSConfig != nil {
+			cfg = opts.TLSConfig.Clone()
+		}
+		if cfg.ServerName == "" {
+			cfg.ServerName = req.URL.Hostname()
+		}
+
+		tc := tls.Client(conn, cfg)
+		if err := tc.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, nil, fmt.Errorf("tls handshake failed: %v", err)
+		}
+		conn = tc
+	}
+
+	fail := func(resp *http.Response, err error) (*Conn, *http.Response, error) {
+		conn.Close()
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		}
+		return nil, resp, err
+	}
+
+	if err := req.Write(conn); err != nil {
+		return fail(nil, fmt.Errorf("failed to send upgrade request: %v", err))
+	}
+
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		return fail(nil, fmt.Errorf("failed to read upgrade response: %v", err))
+	}
+
+	if err := ValidateResponse(resp, wk); err != nil {
+		return fail(resp, err)
+	}
+
+	// the server may only pick one of the subprotocols offered
+	protocol := resp.Header.Get("Sec-WebSocket-Protocol")
+	if protocol != "" && !slices.Contains(opts.Subprotocols, protocol) {
+		return fail(resp, &HandshakeError{
+			StatusCode: resp.StatusCode,
+			Status:     resp.Status,
+			Reason:     fmt.Sprintf("unrequested subprotocol %q", protocol),
+		})
+	}
+
+	compression, err := acceptDeflateResponse(resp.Header.Values("Sec-WebSocket-Extensions"), opts.EnableCompression)
+	if err != nil {
+		return fail(resp, &HandshakeError{
+			StatusCode: resp.StatusCode,
+			Status:     resp.Status,
+			Reason:     err.Error(),
+		})
+	}
+
+	if !stop() {
+		// ctx ended and closed conn after the response arrived
+		return fail(resp, ctx.Err())
+	}
+
+	// frames may have arrived along with the response
+	c := NewConn(&readerConn{Conn: conn, r: br}, nil, RoleClient)
+	c.Subprotocol = protocol
+	c.Compression = compression
+	return c, resp, nil
+}
@@ -0,0 +1,107 @@
+package crocsoc
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDial(t *testing.T) {
+	var upgrader Upgrader
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer t" {
+			http.Error(w, "who are you", http.StatusUnauthorized)
+			return
+		}
+
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.NetConn().Close()
+
+		for {
+			mt, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			conn.WriteMessage(mt, data)
+		}
+	}))
+	defer srv.Close()
+
+	url := "ws" + strings.TrimPrefix(srv.URL, "http")
+	ctx := context.Background()
+
+	conn, resp, err := Dial(ctx, url, &DialOptions{Header: http.Header{"Authorization": {"Bearer t"}}})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer conn.NetConn().Close()
+
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Errorf("want: 101, got: %d", resp.StatusCode)
+	}
+
+	conn.WriteMessage(BinaryMessage, []byte("hello"))
+	mt, data, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if mt != BinaryMessage || string(data) != "hello" {
+		t.Errorf("want: hello, got: %d %s", mt, data)
+	}
+
+	// rejections come back with the response
+	_, resp, err = Dial(ctx, url, nil)
+	var hsErr *HandshakeError
+	if !errors.As(err, &hsErr) || resp == nil || resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("want: 401 HandshakeError, got: %v", err)
+	}
+}
+
+func TestDialUnrequestedSubprotocol(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Upgrade", "websocket")
+		w.Header().Set("Connection", "Upgrade")
+		w.Header().Set("Sec-WebSocket-Accept", SecAcceptSha(r.Header.Get("Sec-WebSocket-Key")))
+		w.Header().Set("Sec-WebSocket-Protocol", "mqtt")
+		w.WriteHeader(http.StatusSwitchingProtocols)
+	}))
+	defer srv.Close()
+
+	_, _, err := Dial(context.Background(), srv.URL, &DialOptions{Subprotocols: []string{"chat"}})
+	var hsErr *HandshakeError
+	if !errors.As(err, &hsErr) {
+		t.Errorf("want: HandshakeError, got: %v", err)
+	}
+}
+
+func TestDialContext(t *testing.T) {
+	// accepts but never answers the upgrade
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer ln.Close()
+	go func() {
+		conn, err := ln.Accept()
+		if err == nil {
+			defer conn.Close()
+			time.Sleep(5 * time.Second)
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	if _, _, err := Dial(ctx, "ws://"+ln.Addr().String(), nil); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("want: DeadlineExceeded, got: %v", err)
+	}
+}
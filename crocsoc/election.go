@@ -0,0 +1,105 @@
+package crocsoc
+
+import (
+	"encoding/json"
+	"sync"
+)
+
+/*
+Primary tab election. With several tabs open, work that must happen once
+per user (notifications, sync) should run in one tab only. The server
+arbitrates: the oldest tab in the Session is primary, and when it leaves
+the next oldest takes over. Every tab is told whether it is primary with a
+text message:
+
+	{"type":"primary","primary":true,"tab":"a"}
+
+tab being the primary's id, sent when a tab joins and to every tab when
+the primary changes.
+*/
+
+// A change of primary, see ElectPrimary.
+type PrimaryChange struct {
+	// the new primary, empty when the last tab left
+	Tab  string
+	Conn *WSConn
+	// the previous primary, empty for the session's first tab
+	Previous string
+}
+
+type primaryNotice struct {
+	Type    string `json:"type"`
+	Primary bool   `json:"primary"`
+	Tab     string `json:"tab"`
+}
+
+type election struct {
+	// serializes announcements so tabs see changes in order
+	mu         sync.Mutex
+	primary    *WSConn
+	primaryTab string
+	onChange   func(PrimaryChange)
+}
+
+// Starts announcing the primary tab to the session's connections, calling
+// onChange, which may be nil, after every failover. Must be called before
+// any connections are added.
+func (s *Session) ElectPrimary(onChange func(PrimaryChange)) {
+	s.election = &election{onChange: onChange}
+}
+
+// Returns the primary tab, the oldest one in the session.
+func (s *Session) Primary() (string, *WSConn, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.tabs) == 0 {
+		return "", nil, false
+	}
+	return s.tabs[0].id, s.tabs[0].conn, true
+}
+
+// tells the tabs about the current primary: just joined when it is not
+// nil, every tab when the primary changed
+func (s *Session) announce(joined *WSConn) {
+	e := s.election
+	if e == nil {
+		return
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	s.mu.Lock()
+	tabs := append([]sessionTab(nil), s.tabs...)
+	s.mu.Unlock()
+
+	var current sessionTab
+	if len(tabs) > 0 {
+		current = tabs[0]
+	}
+
+	if current.conn == e.primary {
+		if joined != nil && joined != current.conn {
+			sendPrimaryNotice(joined, false, current.id)
+		}
+		return
+	}
+
+	previous := e.primaryTab
+	e.primary = current.conn
+	e.primaryTab = current.id
+
+	for _, t := range tabs {
+		sendPrimaryNotice(t.conn, t.conn == current.conn, current.id)
+	}
+
+	if e.onChange != nil {
+		e.onChange(PrimaryChange{Tab: current.id, Conn: current.conn, Previous: previous})
+	}
+}
+
+func sendPrimaryNotice(conn *WSConn, primary bool, tab string) {
+	data, _ := json.Marshal(primaryNotice{Type: "primary", Primary: primary, Tab: tab})
+	conn.SendTextFrame(data)
+}
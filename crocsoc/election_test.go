@@ -0,0 +1,71 @@
+package crocsoc
+
+import (
+	"encoding/json"
+	"net"
+	"testing"
+)
+
+// adds a tab to sess, returning the notices its client end reads
+func addNoticedTab(t *testing.T, sess *Session, tab string) (*WSConn, chan primaryNotice) {
+	t.Helper()
+
+	serverConn, clientConn := net.Pipe()
+	t.Cleanup(func() {
+		serverConn.Close()
+		clientConn.Close()
+	})
+
+	client := &WSConn{Conn: clientConn, Role: RoleClient}
+	notices := make(chan primaryNotice, 4)
+	go func() {
+		for {
+			msg, err := client.ReadMessage()
+			if err != nil {
+				return
+			}
+
+			var n primaryNotice
+			json.Unmarshal(msg, &n)
+			notices <- n
+		}
+	}()
+
+	sess.Add(&WSConn{Conn: serverConn}, tab)
+	return client, notices
+}
+
+func TestElectPrimary(t *testing.T) {
+	sess := NewSession()
+	defer sess.Close()
+
+	changes := make(chan PrimaryChange, 4)
+	sess.ElectPrimary(func(c PrimaryChange) { changes <- c })
+
+	a, aNotices := addNoticedTab(t, sess, "a")
+	if n := <-aNotices; !n.Primary || n.Tab != "a" {
+		t.Errorf("unexpected notice: %+v", n)
+	}
+	if c := <-changes; c.Tab != "a" || c.Previous != "" {
+		t.Errorf("unexpected change: %+v", c)
+	}
+
+	_, bNotices := addNoticedTab(t, sess, "b")
+	if n := <-bNotices; n.Primary || n.Tab != "a" {
+		t.Errorf("unexpected notice: %+v", n)
+	}
+
+	if tab, _, ok := sess.Primary(); !ok || tab != "a" {
+		t.Errorf("want: a, got: %s", tab)
+	}
+
+	// the primary tab closes, b takes over
+	a.SendCloseFrame(1000, "")
+
+	if n := <-bNotices; !n.Primary || n.Tab != "b" {
+		t.Errorf("unexpected notice: %+v", n)
+	}
+	if c := <-changes; c.Tab != "b" || c.Previous != "a" {
+		t.Errorf("unexpected change: %+v", c)
+	}
+}
@@ -0,0 +1,156 @@
+package crocsoc
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+/*
+EnvelopeCodec wraps every payload with metadata so tracing can cross the
+WebSocket boundary. With a text codec the message is a JSON object, the
+payload going in "data" when it is JSON and in "text" otherwise:
+
+	{"id":"4bf92f3577b34da6","ts":"2024-05-01T12:00:00.123Z",
+	 "traceparent":"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+	 "data":{"body":"hi"}}
+
+With a binary codec the message is the same metadata object (without
+data), length prefixed, followed by the payload:
+
+	length (2 bytes, big endian) | metadata JSON | payload
+
+traceparent is a W3C Trace Context header value, filled in by the sender
+from its tracer's propagator.
+*/
+
+// Metadata carried with each message by EnvelopeCodec.
+type Envelope struct {
+	// random when left empty
+	ID string `json:"id"`
+	// now when left zero
+	Time        time.Time `json:"ts"`
+	Traceparent string    `json:"traceparent,omitempty"`
+}
+
+// A value with its envelope. Send one to choose the metadata, or receive
+// one to read it:
+//
+//	conn := crocsoc.Typed[crocsoc.Enveloped[Chat]](ws, crocsoc.EnvelopeCodec(crocsoc.JSONCodec))
+//	conn.Send(crocsoc.Enveloped[Chat]{Envelope: crocsoc.Envelope{Traceparent: tp}, Value: msg})
+//
+// Plain values can be sent and received too, getting a fresh envelope and
+// dropping the received one.
+type Enveloped[T any] struct {
+	Envelope
+	Value T
+}
+
+func (e Enveloped[T]) envelopeParts() (Envelope, any) {
+	return e.Envelope, e.Value
+}
+
+func (e *Enveloped[T]) envelopeTargets() (*Envelope, any) {
+	return &e.Envelope, &e.Value
+}
+
+// Wraps the payloads inner produces in an Envelope, see Enveloped.
+func EnvelopeCodec(inner Codec) Codec {
+	return envelopeCodec{inner: inner}
+}
+
+type envelopeCodec struct {
+	inner Codec
+}
+
+type textEnvelope struct {
+	Envelope
+	Data json.RawMessage `json:"data,omitempty"`
+	Text *string         `json:"text,omitempty"`
+}
+
+func (c envelopeCodec) Binary() bool { return c.inner.Binary() }
+
+func (c envelopeCodec) Marshal(v any) ([]byte, error) {
+	var env Envelope
+	if e, ok := v.(interface{ envelopeParts() (Envelope, any) }); ok {
+		env, v = e.envelopeParts()
+	}
+
+	if env.ID == "" {
+		id := make([]byte, 8)
+		rand.Read(id)
+		env.ID = hex.EncodeToString(id)
+	}
+	if env.Time.IsZero() {
+		env.Time = time.Now()
+	}
+
+	payload, err := c.inner.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.inner.Binary() {
+		meta, err := json.Marshal(env)
+		if err != nil {
+			return nil, err
+		}
+		if len(meta) > 0xFFFF {
+			return nil, fmt.Errorf("envelope metadata exceeds 65535 bytes")
+		}
+
+		out := binary.BigEndian.AppendUint16(nil, uint16(len(meta)))
+		out = append(out, meta...)
+		return append(out, payload...), nil
+	}
+
+	te := textEnvelope{Envelope: env}
+	if json.Valid(payload) {
+		te.Data = payload
+	} else {
+		text := string(payload)
+		te.Text = &text
+	}
+	return json.Marshal(te)
+}
+
+func (c envelopeCodec) Unmarshal(data []byte, v any) error {
+	var env Envelope
+	var payload []byte
+
+	if c.inner.Binary() {
+		if len(data) < 2 {
+			return fmt.Errorf("envelope too short")
+		}
+		n := int(binary.BigEndian.Uint16(data))
+		if len(data) < 2+n {
+			return fmt.Errorf("envelope metadata truncated")
+		}
+		if err := json.Unmarshal(data[2:2+n], &env); err != nil {
+			return fmt.Errorf("malformed envelope: %v", err)
+		}
+		payload = data[2+n:]
+	} else {
+		var te textEnvelope
+		if err := json.Unmarshal(data, &te); err != nil {
+			return fmt.Errorf("malformed envelope: %v", err)
+		}
+		env = te.Envelope
+		payload = te.Data
+		if te.Text != nil {
+			payload = []byte(*te.Text)
+		}
+	}
+
+	if e, ok := v.(interface{ envelopeTargets() (*Envelope, any) }); ok {
+		var target *Envelope
+		target, v = e.envelopeTargets()
+		*target = env
+	}
+
+	return c.inner.Unmarshal(payload, v)
+}
@@ -0,0 +1,83 @@
+package crocsoc
+
+import (
+	"bytes"
+	"encoding/json"
+	"net"
+	"testing"
+)
+
+// passes []byte payloads through as binary
+type rawCodec struct{}
+
+func (rawCodec) Marshal(v any) ([]byte, error) { return v.([]byte), nil }
+func (rawCodec) Unmarshal(data []byte, v any) error {
+	*v.(*[]byte) = append([]byte(nil), data...)
+	return nil
+}
+func (rawCodec) Binary() bool { return true }
+
+func TestEnvelopeText(t *testing.T) {
+	serverConn, clientConn := net.Pipe()
+	defer serverConn.Close()
+	defer clientConn.Close()
+
+	codec := EnvelopeCodec(JSONCodec)
+	sender := Typed[Enveloped[chatMessage]](&WSConn{Conn: serverConn}, codec)
+	receiver := Typed[Enveloped[chatMessage]](&WSConn{Conn: clientConn, Role: RoleClient}, codec)
+
+	tp := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	go sender.Send(Enveloped[chatMessage]{Envelope: Envelope{Traceparent: tp}, Value: chatMessage{Text: "hi"}})
+
+	got, err := receiver.Receive()
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	if got.Value.Text != "hi" || got.Traceparent != tp || got.ID == "" || got.Time.IsZero() {
+		t.Errorf("unexpected message: %+v", got)
+	}
+}
+
+func TestEnvelopePlainValues(t *testing.T) {
+	codec := EnvelopeCodec(JSONCodec)
+
+	data, err := codec.Marshal(chatMessage{Text: "hi"})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	var wire map[string]json.RawMessage
+	json.Unmarshal(data, &wire)
+	if string(wire["data"]) != `{"user":"","text":"hi"}` || wire["id"] == nil || wire["ts"] == nil {
+		t.Errorf("unexpected envelope: %s", data)
+	}
+
+	// metadata dropped
+	var msg chatMessage
+	if err := codec.Unmarshal(data, &msg); err != nil || msg.Text != "hi" {
+		t.Errorf("want: hi, got: %+v %v", msg, err)
+	}
+}
+
+func TestEnvelopeBinary(t *testing.T) {
+	codec := EnvelopeCodec(rawCodec{})
+
+	payload := []byte{0, 1, 2, 0xFF}
+	data, err := codec.Marshal(Enveloped[[]byte]{Envelope: Envelope{ID: "m1"}, Value: payload})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	var got Enveloped[[]byte]
+	if err := codec.Unmarshal(data, &got); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if got.ID != "m1" || !bytes.Equal(got.Value, payload) {
+		t.Errorf("unexpected message: %+v", got)
+	}
+
+	if err := codec.Unmarshal([]byte{0, 9, '{'}, &got); err == nil {
+		t.Errorf("truncated envelope accepted")
+	}
+}
@@ -0,0 +1,84 @@
+package crocsoc
+
+import (
+	"errors"
+	"io"
+)
+
+type EventType int
+
+const (
+	EventOpen EventType = iota
+	EventMessage
+	EventPing
+	EventPong
+	EventClose
+	EventError
+)
+
+// Something that happened on a connection, as delivered by WSConn.Events.
+type Event struct {
+	Type EventType
+
+	// opcode of a message event, 0x1 text or 0x2 binary
+	Opcode byte
+	// message payload, or the application data of a ping/pong
+	Data []byte
+
+	// set on close events, 1006 when the peer went away without a close frame
+	CloseCode   uint16
+	CloseReason string
+
+	// set on error events, and on close events after CloseInternal to the
+	// error the connection was failed for
+	Err error
+}
+
+// number of events buffered before the reading goroutine blocks
+const eventBuffer = 16
+
+// Starts reading the connection on its own goroutine and reports everything
+// that happens on it, for select-based orchestration instead of a read loop.
+// The first event is always EventOpen; the channel is closed after the final
+// EventClose or EventError. After an error the connection is left as is for
+// the caller to Terminate.
+//
+// Reading stops while the channel is full, so a slow consumer applies
+// backpressure to the peer. Must not be combined with ReadMessage.
+func (c *WSConn) Events() <-chan Event {
+	ch := make(chan Event, eventBuffer)
+	c.events = ch
+
+	go func() {
+		defer close(ch)
+
+		ch <- Event{Type: EventOpen}
+
+		for {
+			opcode, msg, err := c.readMessage()
+
+			if errors.Is(err, io.EOF) {
+				// close frames report themselves, this is a dropped connection
+				if !c.closeReceived {
+					ch <- Event{Type: EventClose, CloseCode: 1006, Err: c.closeCause()}
+				}
+				return
+			}
+
+			if err != nil {
+				ch <- Event{Type: EventError, Err: err}
+				return
+			}
+
+			ch <- Event{Type: EventMessage, Opcode: opcode, Data: msg}
+		}
+	}()
+
+	return ch
+}
+
+func (c *WSConn) emit(ev Event) {
+	if c.events != nil {
+		c.events <- ev
+	}
+}
@@ -0,0 +1,67 @@
+package crocsoc
+
+import (
+	"net"
+	"testing"
+)
+
+func TestEvents(t *testing.T) {
+	serverConn, clientConn := net.Pipe()
+	defer clientConn.Close()
+	client := &WSConn{Conn: clientConn, Role: RoleClient}
+
+	server := &WSConn{Conn: serverConn}
+	events := server.Events()
+
+	go func() {
+		client.SendPongFrame(nil) // unsolicited pong
+		client.SendTextFrame([]byte("Hello"))
+		client.SendCloseFrame(1001, "going away")
+
+		// drain the close reply
+		readFrame(clientConn)
+	}()
+
+	want := []EventType{EventOpen, EventPong, EventMessage, EventClose}
+	var got []Event
+	for ev := range events {
+		got = append(got, ev)
+	}
+
+	if len(got) != len(want) {
+		t.Fatalf("want: %d events, got: %+v", len(want), got)
+	}
+
+	for i := range want {
+		if got[i].Type != want[i] {
+			t.Errorf("event %d: want: %v, got: %v", i, want[i], got[i].Type)
+		}
+	}
+
+	if got[2].Opcode != 0x1 || string(got[2].Data) != "Hello" {
+		t.Errorf("unexpected message event: %+v", got[2])
+	}
+
+	if got[3].CloseCode != 1001 || got[3].CloseReason != "going away" {
+		t.Errorf("unexpected close event: %+v", got[3])
+	}
+}
+
+func TestEventsDroppedConnection(t *testing.T) {
+	serverConn, clientConn := net.Pipe()
+
+	server := &WSConn{Conn: serverConn}
+	events := server.Events()
+
+	<-events // open
+	clientConn.Close()
+
+	ev, ok := <-events
+	if !ok || ev.Type != EventClose || ev.CloseCode != 1006 {
+		t.Errorf("want: close 1006, got: %+v", ev)
+	}
+
+	if _, ok := <-events; ok {
+		t.Errorf("channel not closed after close event")
+	}
+}
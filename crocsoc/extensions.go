@@ -0,0 +1,255 @@
+package crocsoc
+
+import (
+	"fmt"
+	"strings"
+)
+
+/*
+Sec-WebSocket-Extensions header values (RFC-6455 9.1):
+
+	Sec-WebSocket-Extensions = extension-list
+	extension-list = 1#extension
+	extension = extension-token *( ";" extension-param )
+	extension-token = registered-token
+	registered-token = token
+	extension-param = token [ "=" (token | quoted-string) ]
+		;When using the quoted-string syntax variant, the value
+		;after quoted-string unescaping MUST conform to the
+		;'token' ABNF.
+
+Note that like other HTTP header fields, this header field MAY be
+split or combined across multiple lines.  Ergo, the following are
+equivalent:
+
+	Sec-WebSocket-Extensions: foo
+	Sec-WebSocket-Extensions: bar; baz=2
+
+is exactly equivalent to
+
+	Sec-WebSocket-Extensions: foo, bar; baz=2
+
+token and quoted-string are as defined by RFC 2616 2.2, with the list rule
+allowing empty elements and linear whitespace around separators.
+*/
+
+// One entry of an extension list, e.g. permessage-deflate; client_max_window_bits
+type Extension struct {
+	Name   string
+	Params []ExtensionParam
+}
+
+type ExtensionParam struct {
+	Name string
+	// empty when the parameter has no value, values are never empty
+	// strings otherwise as they must be tokens
+	Value string
+}
+
+// Returns the value of the named parameter, and whether it is present.
+func (e Extension) Param(name string) (string, bool) {
+	for _, p := range e.Params {
+		if strings.EqualFold(p.Name, name) {
+			return p.Value, true
+		}
+	}
+	return "", false
+}
+
+func (e Extension) String() string {
+	var b strings.Builder
+
+	b.WriteString(e.Name)
+	for _, p := range e.Params {
+		b.WriteString("; ")
+		b.WriteString(p.Name)
+		if p.Value != "" {
+			b.WriteString("=")
+			b.WriteString(p.Value)
+		}
+	}
+	return b.String()
+}
+
+// Parses one or more Sec-WebSocket-Extensions header values, as returned by
+// http.Header.Values, into a single list in offer order.
+func ParseExtensions(values ...string) ([]Extension, error) {
+	var exts []Extension
+
+	for _, v := range values {
+		p := extParser{s: v}
+
+		parsed, err := p.list()
+		if err != nil {
+			return nil, fmt.Errorf("invalid Sec-WebSocket-Extensions %q: %v", v, err)
+		}
+		exts = append(exts, parsed...)
+	}
+
+	return exts, nil
+}
+
+// Serializes exts as a single Sec-WebSocket-Extensions header value.
+func FormatExtensions(exts []Extension) string {
+	parts := make([]string, len(exts))
+	for i, e := range exts {
+		parts[i] = e.String()
+	}
+	return strings.Join(parts, ", ")
+}
+
+type extParser struct {
+	s string
+	i int
+}
+
+func (p *extParser) list() ([]Extension, error) {
+	var exts []Extension
+
+	for {
+		p.skipSpace()
+		if p.done() {
+			return exts, nil
+		}
+
+		// empty list element
+		if p.peek() == ',' {
+			p.i++
+			continue
+		}
+
+		ext, err := p.extension()
+		if err != nil {
+			return nil, err
+		}
+		exts = append(exts, ext)
+
+		p.skipSpace()
+		if p.done() {
+			return exts, nil
+		}
+		if p.peek() != ',' {
+			return nil, fmt.Errorf("unexpected %q at offset %d", p.peek(), p.i)
+		}
+		p.i++
+	}
+}
+
+func (p *extParser) extension() (Extension, error) {
+	name := p.token()
+	if name == "" {
+		return Extension{}, fmt.Errorf("missing extension name at offset %d", p.i)
+	}
+
+	ext := Extension{Name: name}
+
+	for {
+		p.skipSpace()
+		if p.done() || p.peek() == ',' {
+			return ext, nil
+		}
+
+		if p.peek() != ';' {
+			return Extension{}, fmt.Errorf("unexpected %q at offset %d", p.peek(), p.i)
+		}
+		p.i++
+		p.skipSpace()
+
+		param := ExtensionParam{Name: p.token()}
+		if param.Name == "" {
+			return Extension{}, fmt.Errorf("missing parameter name at offset %d", p.i)
+		}
+
+		p.skipSpace()
+		if !p.done() && p.peek() == '=' {
+			p.i++
+			p.skipSpace()
+
+			value, err := p.value()
+			if err != nil {
+				return Extension{}, err
+			}
+			param.Value = value
+		}
+
+		ext.Params = append(ext.Params, param)
+	}
+}
+
+// token | quoted-string, where the unescaped quoted-string must be a token
+func (p *extParser) value() (string, error) {
+	if p.done() || p.peek() != '"' {
+		v := p.token()
+		if v == "" {
+			return "", fmt.Errorf("missing parameter value at offset %d", p.i)
+		}
+		return v, nil
+	}
+
+	start := p.i
+	p.i++
+
+	var b strings.Builder
+	for {
+		if p.done() {
+			return "", fmt.Errorf("unterminated quoted-string at offset %d", start)
+		}
+
+		c := p.s[p.i]
+		p.i++
+
+		switch {
+		case c == '"':
+			v := b.String()
+			if !isToken(v) {
+				return "", fmt.Errorf("quoted value %q is not a token", v)
+			}
+			return v, nil
+		case c == '\\':
+			if p.done() {
+				return "", fmt.Errorf("unterminated quoted-pair at offset %d", p.i)
+			}
+			b.WriteByte(p.s[p.i])
+			p.i++
+		default:
+			b.WriteByte(c)
+		}
+	}
+}
+
+func (p *extParser) token() string {
+	start := p.i
+	for !p.done() && isTokenChar(p.s[p.i]) {
+		p.i++
+	}
+	return p.s[start:p.i]
+}
+
+func (p *extParser) skipSpace() {
+	for !p.done() && (p.s[p.i] == ' ' || p.s[p.i] == '\t') {
+		p.i++
+	}
+}
+
+func (p *extParser) peek() byte { return p.s[p.i] }
+func (p *extParser) done() bool { return p.i >= len(p.s) }
+
+func isToken(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := range len(s) {
+		if !isTokenChar(s[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// any CHAR except CTLs or separators
+func isTokenChar(c byte) bool {
+	if c <= 0x20 || c >= 0x7F {
+		return false
+	}
+	return !strings.ContainsRune(`()<>@,;:\"/[]?={}`, rune(c))
+}
@@ -0,0 +1,112 @@
+package crocsoc
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseExtensions(t *testing.T) {
+	tests := []struct {
+		in   []string
+		want []Extension
+	}{
+		{[]string{""}, nil},
+		{[]string{"foo"}, []Extension{{Name: "foo"}}},
+		{[]string{"foo, bar"}, []Extension{{Name: "foo"}, {Name: "bar"}}},
+		// split across header lines, RFC example
+		{[]string{"foo", "bar; baz=2"}, []Extension{
+			{Name: "foo"},
+			{Name: "bar", Params: []ExtensionParam{{Name: "baz", Value: "2"}}},
+		}},
+		{[]string{"permessage-deflate; client_max_window_bits; server_max_window_bits=10"}, []Extension{
+			{Name: "permessage-deflate", Params: []ExtensionParam{
+				{Name: "client_max_window_bits"},
+				{Name: "server_max_window_bits", Value: "10"},
+			}},
+		}},
+		// quoted values are unescaped
+		{[]string{`foo; a="10"; b="x\yz"`}, []Extension{
+			{Name: "foo", Params: []ExtensionParam{{Name: "a", Value: "10"}, {Name: "b", Value: "xyz"}}},
+		}},
+		// linear whitespace around separators and empty list elements
+		{[]string{" \tfoo ;  a = 1 ,, ,bar\t"}, []Extension{
+			{Name: "foo", Params: []ExtensionParam{{Name: "a", Value: "1"}}},
+			{Name: "bar"},
+		}},
+		// the same extension offered twice with different params
+		{[]string{"permessage-deflate; client_max_window_bits=10, permessage-deflate"}, []Extension{
+			{Name: "permessage-deflate", Params: []ExtensionParam{{Name: "client_max_window_bits", Value: "10"}}},
+			{Name: "permessage-deflate"},
+		}},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseExtensions(tt.in...)
+		if err != nil {
+			t.Errorf("%q: %v", tt.in, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%q: want: %+v, got: %+v", tt.in, tt.want, got)
+		}
+	}
+}
+
+func TestParseExtensionsInvalid(t *testing.T) {
+	invalid := []string{
+		";",                // missing name
+		"foo;",             // missing param name
+		"foo; =1",          // missing param name
+		"foo; a=",          // missing value
+		"foo; a=,",         // missing value
+		"foo bar",          // two tokens
+		"foo; a=1 2",       // two values
+		`foo; a="1`,        // unterminated quoted-string
+		`foo; a="1\`,       // unterminated quoted-pair
+		`foo; a=""`,        // quoted value must be a token
+		`foo; a="x y"`,     // quoted value must be a token
+		`foo; a="x;y"`,     // quoted value must be a token
+		"fo@o",             // separator in token
+		"foo; a=b/c",       // separator in token
+		"foo\x01",          // control character
+		"fée",              // non ascii
+		`"foo"`,            // names cannot be quoted
+		"foo; a=1; b=2 ; ", // trailing separator
+	}
+
+	for _, in := range invalid {
+		if got, err := ParseExtensions(in); err == nil {
+			t.Errorf("%q accepted as %+v", in, got)
+		}
+	}
+}
+
+func TestFormatExtensions(t *testing.T) {
+	exts := []Extension{
+		{Name: "permessage-deflate", Params: []ExtensionParam{
+			{Name: "client_max_window_bits"},
+			{Name: "server_max_window_bits", Value: "10"},
+		}},
+		{Name: "foo"},
+	}
+
+	want := "permessage-deflate; client_max_window_bits; server_max_window_bits=10, foo"
+	got := FormatExtensions(exts)
+	if got != want {
+		t.Errorf("want: %s, got: %s", want, got)
+	}
+
+	// round trips
+	parsed, err := ParseExtensions(got)
+	if err != nil || !reflect.DeepEqual(parsed, exts) {
+		t.Errorf("round trip: want: %+v, got: %+v %v", exts, parsed, err)
+	}
+
+	if v, ok := parsed[0].Param("SERVER_MAX_WINDOW_BITS"); !ok || v != "10" {
+		t.Errorf("Param: want: 10, got: %q %v", v, ok)
+	}
+	if _, ok := parsed[1].Param("client_max_window_bits"); ok {
+		t.Errorf("Param: found missing parameter")
+	}
+}
@@ -0,0 +1,44 @@
+package crocsoc
+
+/*
+Application backpressure. While reads are paused the connection isn't read
+from at all, so once the kernel's receive buffer fills TCP flow control
+stops the peer from sending, instead of the application buffering inbound
+messages without bound.
+
+Control frames aren't read either, so pings go unanswered while paused;
+keep pauses shorter than the peer's ping timeout.
+*/
+
+// Stops ReadMessage from reading the next message until ResumeReads. A read
+// already in progress completes.
+func (c *WSConn) PauseReads() {
+	c.pauseMu.Lock()
+	defer c.pauseMu.Unlock()
+
+	if c.paused == nil {
+		c.paused = make(chan struct{})
+	}
+}
+
+// Lets reads paused by PauseReads continue.
+func (c *WSConn) ResumeReads() {
+	c.pauseMu.Lock()
+	defer c.pauseMu.Unlock()
+
+	if c.paused != nil {
+		close(c.paused)
+		c.paused = nil
+	}
+}
+
+// blocks while reads are paused
+func (c *WSConn) waitReadable() {
+	c.pauseMu.Lock()
+	paused := c.paused
+	c.pauseMu.Unlock()
+
+	if paused != nil {
+		<-paused
+	}
+}
@@ -0,0 +1,50 @@
+package crocsoc
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestPauseReads(t *testing.T) {
+	serverConn, clientConn := net.Pipe()
+	defer serverConn.Close()
+	defer clientConn.Close()
+	client := &WSConn{Conn: clientConn, Role: RoleClient}
+
+	server := &WSConn{Conn: serverConn}
+	server.PauseReads()
+
+	sent := make(chan error, 1)
+	go func() {
+		sent <- client.SendTextFrame([]byte("hello"))
+	}()
+
+	got := make(chan string)
+	go func() {
+		msg, err := server.ReadMessage()
+		if err != nil {
+			t.Errorf("%v", err)
+		}
+		got <- string(msg)
+	}()
+
+	// nothing reads the pipe while paused, so the write can't complete
+	select {
+	case <-sent:
+		t.Fatalf("frame read while paused")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	server.ResumeReads()
+
+	if msg := <-got; msg != "hello" {
+		t.Errorf("want: hello, got: %s", msg)
+	}
+	if err := <-sent; err != nil {
+		t.Errorf("%v", err)
+	}
+
+	// resuming twice is harmless
+	server.ResumeReads()
+}
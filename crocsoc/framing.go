@@ -1,56 +1,105 @@
 package crocsoc
 
 import (
+	"bytes"
+	"context"
+	"crypto/rand"
 	"encoding/binary"
 	"errors"
 	"fmt"
 	"io"
+	"math"
 	"net"
+	"runtime/trace"
+	"time"
 	"unicode/utf8"
+
+	"github.com/pgxtips/crocsoc/crocsoc/wire"
 )
 
 type Frame struct{
 	Fin bool
+	// RSV1-3 as the low three bits, RSV1 (0x4) marking the first frame of
+	// a permessage-deflate compressed message
+	Rsv byte
 	Opcode byte 
 	Payload []byte
 }
 
+// Returned by ReadMessage for control frames over 125 bytes or fragmented,
+// after sending a 1002 close.
+var ErrInvalidControlFrame = errors.New("invalid control frame")
+
+// Returned by ReadMessage for unmasked frames from a client or masked ones
+// from a server, after sending a 1002 close.
+var ErrMasking = errors.New("wrong frame masking")
+
+// Reads the next text or binary message from a server side connection.
+// A connection dropped without a close handshake is reported as an empty
+// message with a nil error. Unlike WSConn.ReadMessage, unmasked frames are
+// accepted, as sent by the package level Send functions.
 func ReadMessage(conn net.Conn) ([]byte, error) {
+	ws := &WSConn{Conn: conn, anyMasking: true}
+
+	msg, err := ws.ReadMessage()
+
+	// connection closed normally
+	if errors.Is(err, io.EOF) && !ws.closeReceived {
+		return []byte{}, nil
+	}
+
+	return msg, err
+}
+
+// Reads the next text or binary message, answering any control frames that
+// arrive in between as the connection's Role requires. Returns io.EOF once
+// the connection has closed.
+func (c *WSConn) ReadMessage() ([]byte, error) {
+	_, msg, err := c.readMessage()
+	return msg, err
+}
+
+// as ReadMessage, also returning the message's opcode
+func (c *WSConn) readMessage() (opcode byte, msg []byte, err error) {
+	// paused reads block between messages, not part way through one
+	c.waitReadable()
+
+	defer trace.StartRegion(context.Background(), "crocsoc.ReadMessage").End()
+
 	frags := []*Frame{}
 	var initialOpcode byte
 
-	for {
-		frame, err := readFrame(conn)
-
+	// started once the first data frame of the message arrives
+	var span Span
+	defer func() {
+		if span == nil {
+			return
+		}
 		if err != nil {
-			// connection closed normally
-			if errors.Is(err, io.EOF) {
-				return []byte{}, nil
-			}
-			return []byte{}, fmt.Errorf("error reading message: %v", err)
+			span.RecordError(err)
 		}
+		span.End()
+	}()
 
-		// handle control frames
-		if isControlFrame(frame){
-			err := handleControlFrame(frame, conn)
-			if err != nil {
-				return []byte{}, err 
-			}
-
-			continue
+	for {
+		frame, err := c.nextFrame(c.remainingLimit(frags), len(frags) == 0)
+		if err != nil {
+			return 0, []byte{}, err
 		}
 
 		// first new frame of new batch
 		if len(frags) == 0 {
+			_, span = tracer.Start(context.Background(), "crocsoc.message")
+
 			initialOpcode = frame.Opcode
 			// only text and binary frames accepted
 			if initialOpcode != 0x1 && initialOpcode != 0x2 {
-				return []byte{}, fmt.Errorf("unsupported opcode %x", initialOpcode)
+				return 0, []byte{}, fmt.Errorf("unsupported opcode %x", initialOpcode)
 			}
 		} else {
 			// all subsequent fragments must be continuation frames opcode 0x0
 			if frame.Opcode != 0x0 {
-				return []byte{}, fmt.Errorf("unexpected opcode %x in continuation frame", frame.Opcode)
+				return 0, []byte{}, fmt.Errorf("unexpected opcode %x in continuation frame", frame.Opcode)
 			}
 		}
 
@@ -63,24 +112,140 @@ func ReadMessage(conn net.Conn) ([]byte, error) {
 				payload = append(payload, f.Payload...)
 			}
 
+			// a fragmented compressed message
+			if frags[0].Rsv == 0x4 {
+				payload, err = inflate(payload, c.remainingLimit(nil))
+				if errors.Is(err, errFrameTooBig) {
+					return 0, []byte{}, c.rejectTooBig()
+				}
+				if err != nil {
+					return 0, []byte{}, err
+				}
+			}
+
+			span.SetAttributes(
+				Attribute{Key: "websocket.opcode", Value: int(initialOpcode)},
+				Attribute{Key: "websocket.bytes", Value: len(payload)},
+			)
+
+			if err := c.sniff(initialOpcode, payload); err != nil {
+				return 0, []byte{}, err
+			}
+
 			// text frame
 			if initialOpcode == 0x1 {
 				if !utf8.Valid(payload) {
-					return []byte{}, fmt.Errorf("invalid UTF-8 in text frame")
+					return 0, []byte{}, fmt.Errorf("invalid UTF-8 in text frame")
 				}
-				return payload, nil
+				return initialOpcode, payload, nil
 			}
 
 			// @todo: binary frame (for now just error)
 			if initialOpcode == 0x2 {
-				return payload, nil
+				return initialOpcode, payload, nil
 			}
 
-			return []byte{}, fmt.Errorf("unknown opcode: %x", frame.Opcode)
+			return 0, []byte{}, fmt.Errorf("unknown opcode: %x", frame.Opcode)
 		}
 	}
 }
 
+// reads frames until the next data frame, which is checked, inflated when
+// it is a whole compressed message, and run through the inbound
+// interceptors. Control frames in between are answered. limit is the
+// longest payload accepted and first says whether the frame starts a
+// message.
+func (c *WSConn) nextFrame(limit int64, first bool) (*Frame, error) {
+	for {
+		frame, err := readFrameLimit(c.Conn, limit, c.maskRule())
+
+		if errors.Is(err, errFrameTooBig) {
+			return nil, c.rejectTooBig()
+		}
+		if errors.Is(err, ErrInvalidControlFrame) {
+			return nil, c.rejectProtocol("invalid control frame", err)
+		}
+		if errors.Is(err, ErrMasking) {
+			return nil, c.rejectProtocol("wrong masking", err)
+		}
+
+		if err != nil {
+			// connection closed normally
+			if errors.Is(err, io.EOF) {
+				c.IsClosed = true
+				return nil, io.EOF
+			}
+			return nil, fmt.Errorf("error reading message: %v", err)
+		}
+
+		if err := c.checkReserved(frame, first); err != nil {
+			return nil, err
+		}
+
+		// compressed messages in a single frame are inflated before
+		// interceptors see them
+		if frame.Rsv == 0x4 && frame.Fin {
+			frame.Payload, err = inflate(frame.Payload, limit)
+			if errors.Is(err, errFrameTooBig) {
+				return nil, c.rejectTooBig()
+			}
+			if err != nil {
+				return nil, err
+			}
+			frame.Rsv = 0
+		}
+
+		frame, err = c.intercept(c.inbound, frame)
+		if err != nil {
+			var perr *PanicError
+			if errors.As(err, &perr) {
+				c.CloseInternal(err)
+				c.Conn.Close()
+				c.IsClosed = true
+			}
+			return nil, err
+		}
+
+		// dropped by an interceptor
+		if frame == nil {
+			continue
+		}
+
+		// handle control frames
+		if isControlFrame(frame){
+			err := c.handleControlFrame(frame)
+			if err != nil {
+				return nil, err 
+			}
+
+			continue
+		}
+
+		// each message counts once against the rate limit
+		if first {
+			if err := c.checkRate(); err != nil {
+				return nil, err
+			}
+		}
+
+		return frame, nil
+	}
+}
+
+/*
+If an endpoint receives a frame that violates the protocol it MUST fail
+the connection with 1002, leaving the rest of the frame unread, so the
+close handshake can't be completed.
+*/
+func (c *WSConn) rejectProtocol(reason string, err error) error {
+	c.logger().Warn("rejecting frame", "reason", reason, "err", err)
+	c.SendCloseFrame(1002, reason)
+
+	c.Conn.Close()
+	c.IsClosed = true
+	return err
+}
+
 func isControlFrame(f *Frame) bool{
 	switch f.Opcode{
 		case 0x8, // close
@@ -92,7 +257,7 @@ func isControlFrame(f *Frame) bool{
 	}
 }
 
-func handleControlFrame(f *Frame, conn net.Conn) error{
+func (c *WSConn) handleControlFrame(f *Frame) error{
 	switch f.Opcode {
 	//close
 	case 0x8:
@@ -104,194 +269,339 @@ func handleControlFrame(f *Frame, conn net.Conn) error{
 			reason = string(f.Payload[2:])
 		}
 
-		fmt.Printf("Received close frame: code=%d, reason=%q\n", code, reason)
+		c.logger().Info("received close frame", "code", code, "reason", reason)
 
-		err := SendCloseFrame(conn, 1000, "Closing in response")
+		c.emit(Event{Type: EventClose, CloseCode: code, CloseReason: reason, Err: c.closeCause()})
 
-		if err != nil {
-			return err
+		_, span := tracer.Start(context.Background(), "crocsoc.close")
+		span.SetAttributes(Attribute{Key: "websocket.close.code", Value: int(code)})
+		defer span.End()
+
+		c.closeReceived = true
+
+		// peer is answering our close frame, nothing left to send
+		if !c.sentClose() {
+			err := c.SendCloseFrame(1000, "Closing in response")
+
+			if err != nil {
+				return err
+			}
 		}
 
-		conn.Close()
+		c.closeTCP()
 
 		return io.EOF
 
 	// ping 
 	case 0x9:
-		fmt.Println("Received ping")
-		return SendPongFrame(conn, f.Payload)
+		c.logger().Debug("received ping")
+		c.emit(Event{Type: EventPing, Data: f.Payload})
+		c.clock.observePing(f.Payload)
+
+		// no frames may follow our close frame
+		if c.sentClose() {
+			return nil
+		}
+		return c.SendPongFrame(f.Payload)
 	// pong 
 	case 0xA:
-		fmt.Println("Received pong")
+		c.logger().Debug("received pong")
+		c.emit(Event{Type: EventPong, Data: f.Payload})
+		c.clock.observePong(f.Payload)
 		return nil
 	default:
 		return fmt.Errorf("unknown control frame opcode: %x", f.Opcode)
 	}
 }
 
+// reads any frame, as tests read what a WSConn sent
 func readFrame(conn net.Conn) (*Frame, error) {
-	header := [2]byte{};
-	_, err := io.ReadFull(conn, header[:])
+	h, err := readHeader(conn)
+	if err != nil {
+		return nil, err
+	}
+	return readFramePayload(conn, h, -1)
+}
+
+// which mask bit inbound frames must have
+type maskRule int
+
+const (
+	maskAny maskRule = iota
+	maskRequired
+	maskForbidden
+)
+
+// servers only take masked frames, clients only unmasked ones
+func (c *WSConn) maskRule() maskRule {
+	switch {
+	case c.anyMasking:
+		return maskAny
+	case c.Role == RoleClient:
+		return maskForbidden
+	default:
+		return maskRequired
+	}
+}
+
+/*
+The server MUST close the connection upon receiving a frame that is not
+masked. [...] A client MUST close a connection if it detects a masked
+frame.
+
+As readFrame, failing with ErrMasking when the frame's mask bit breaks
+rule, and with errFrameTooBig before reading a data frame payload longer
+than limit. A negative limit means no limit.
+*/
+func readFrameLimit(conn net.Conn, limit int64, rule maskRule) (*Frame, error) {
+	h, err := readHeader(conn)
+	if err != nil {
+		return nil, err
+	}
+
+	if (rule == maskRequired && !h.Masked) || (rule == maskForbidden && h.Masked) {
+		return nil, fmt.Errorf("%w: mask bit %v", ErrMasking, h.Masked)
+	}
+
+	return readFramePayload(conn, h, limit)
+}
+
+func readHeader(conn net.Conn) (wire.Header, error) {
+	// header layout and length encoding are handled by wire, see
+	// RFC-6455 5.2
+	h, err := wire.ReadHeader(conn)
 
 	if err != nil {
 		// connection closed normally
 		if errors.Is(err, io.EOF) {
-			return nil, io.EOF
+			return h, io.EOF
 		}
 
-		return nil, fmt.Errorf("failed to read frame header: %v", err)
+		return h, fmt.Errorf("failed to read frame header: %v", err)
 	}
+	return h, nil
+}
 
-	// first byte of header:
-	// fin (1 bit), rsv1 (1 bit), rsv2 (1 bit), rsv3 (1 bit), opcode (4 bit)
-	b0 := header[0]
-	fin := b0 & 0x80 != 0
-	opcode := b0 & 0x0F
+// reads the payload following h, unmasked
+func readFramePayload(conn net.Conn, h wire.Header, limit int64) (*Frame, error) {
+	// control frames are at most 125 bytes and never fragmented, checked
+	// before the payload is allocated
+	if wire.IsControl(h.Opcode) && (h.Length > 125 || !h.Fin) {
+		return nil, fmt.Errorf("%w: opcode %x, %d bytes, fin %v", ErrInvalidControlFrame, h.Opcode, h.Length, h.Fin)
+	}
 
-	// second byte of header:
-	b1 := header[1]
-	mask := b1 & 0x80 != 0
-	payLen := int(b1 & 0x7F)
+	if limit >= 0 && !wire.IsControl(h.Opcode) && h.Length > limit {
+		return nil, errFrameTooBig
+	}
 
-/*
--> If payload length 0-125, that is the payload length.  
--> If 126, the following 2 bytes interpreted as a 16-bit unsigned integer 
-are the payload length.  
--> If 127, the following 8 bytes interpreted as a 64-bit unsigned integer (the
-most significant bit MUST be 0) are the payload length.  
-
-Multibyte length quantities are expressed in network byte order.  
-Note that in all cases, the minimal number of bytes MUST be used to encode
-the length, for example, the length of a 124-byte-long string
-CAN'T be encoded as the sequence 126, 0, 124.  
-
-The payload length is the length of the "Extension data" + the length of the
-"Application data".  The length of the "Extension data" may be
-zero, in which case the payload length is the length of the "Application data".
-*/
-	if payLen == 126 {
-		var ext [2]byte
-		io.ReadFull(conn, ext[:])
-		payLen = int(binary.BigEndian.Uint16(ext[:]))
-	} else if payLen == 127 {
-		var ext [8]byte
-		io.ReadFull(conn, ext[:])
-		payLen64 := binary.BigEndian.Uint64(ext[:])
-		payLen = int(payLen64)
-	}
-
-	maskingKey := [4]byte{};
-	if mask {
-		io.ReadFull(conn, maskingKey[:])
+	// lengths up to 2^63-1 are valid on the wire but can't be allocated
+	if h.Length > math.MaxInt {
+		return nil, errFrameTooBig
 	}
 
-	payload := make([]byte, payLen)
-	io.ReadFull(conn, payload)
+	payload, err := readPayload(conn, h.Length)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read frame payload: %v", err)
+	}
 
-	if mask {
-		for i := range payLen {
-			/*
-				Masking key (4 byte mask): [A B C D]
-				Payload: [p0 ^ A, p1 ^ B, p2 ^ C, p3 ^ D, p4 ^ A,  p5 ^ B...]
-			*/
-			payload[i] ^= maskingKey[i%4]
-		}
+	if h.Masked {
+		wire.Mask(h.MaskKey, 0, payload)
 	}
 
 	return &Frame{
-		Fin: fin,
-		Opcode: opcode,
+		Fin: h.Fin,
+		Rsv: h.Rsv,
+		Opcode: h.Opcode,
 		Payload: payload,
 	}, nil
 }
 
+// payloads up to this long are allocated in one go, longer ones grow as
+// they arrive so a declared length costs nothing until it's sent
+const payloadAllocSize = 1 << 20
+
+func readPayload(conn net.Conn, n int64) ([]byte, error) {
+	if n <= payloadAllocSize {
+		payload := make([]byte, n)
+		_, err := io.ReadFull(conn, payload)
+		return payload, err
+	}
+
+	var buf bytes.Buffer
+	buf.Grow(payloadAllocSize)
+	if _, err := io.CopyN(&buf, conn, n); err != nil {
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
 func SendTextFrame(conn net.Conn, data []byte) error {
+	return (&WSConn{Conn: conn}).SendTextFrame(data)
+}
+
+func SendPongFrame(conn net.Conn, payload []byte) error {
+	return (&WSConn{Conn: conn}).SendPongFrame(payload)
+}
+
+func SendCloseFrame(conn net.Conn, code uint16, reason string) error {
+	return (&WSConn{Conn: conn}).SendCloseFrame(code, reason)
+}
+
+func SendBinaryFrame(conn net.Conn, data []byte) error {
+	return (&WSConn{Conn: conn}).SendBinaryFrame(data)
+}
+
+// Writes a single server to client (unmasked) frame.
+func WriteFrame(conn net.Conn, f *Frame) error {
+	return writeFrame(conn, f, false, time.Time{})
+}
+
+func (c *WSConn) SendTextFrame(data []byte) error {
 	frame := &Frame{
 		Fin:     true,
 		Opcode:  0x1, // text frame
 		Payload: data,
 	}
-	return WriteFrame(conn, frame)
+	return c.WriteFrame(frame)
 }
 
-func SendPongFrame(conn net.Conn, payload []byte) error {
+func (c *WSConn) SendPingFrame(payload []byte) error {
+	frame := &Frame{
+		Fin:     true,
+		Opcode:  0x9, // ping frame
+		Payload: payload,
+	}
+	return c.WriteFrame(frame)
+}
+
+func (c *WSConn) SendPongFrame(payload []byte) error {
 	frame := &Frame{
 		Fin:     true,
 		Opcode:  0xA, // pong frame
 		Payload: payload,
 	}
-	return WriteFrame(conn, frame)
+	return c.WriteFrame(frame)
 }
 
-func SendCloseFrame(conn net.Conn, code uint16, reason string) error {
+func (c *WSConn) SendCloseFrame(code uint16, reason string) error {
+	return c.WriteFrame(closeFrame(code, reason))
+}
+
+func closeFrame(code uint16, reason string) *Frame {
 	payload := make([]byte, 2+len(reason))
 	binary.BigEndian.PutUint16(payload[:2], code)
 	copy(payload[2:], reason)
 
-	frame := &Frame{
+	return &Frame{
 		Fin:     true,
 		Opcode:  0x8, // close frame
 		Payload: payload,
 	}
-	return WriteFrame(conn, frame)
 }
 
-func SendBinaryFrame(conn net.Conn, data []byte) error {
+func (c *WSConn) SendBinaryFrame(data []byte) error {
 	frame := &Frame{
 		Fin:     true,
 		Opcode:  0x2, // binary frame
 		Payload: data,
 	}
-	return WriteFrame(conn, frame)
+	return c.WriteFrame(frame)
 }
 
-func WriteFrame(conn net.Conn, f *Frame) error {
-	// header[0] byte
-	var b0 byte
+// Writes a single frame, masked when the connection is in the client role.
+// Safe for concurrent use; fails once a close frame has been sent.
+func (c *WSConn) WriteFrame(f *Frame) error {
+	defer trace.StartRegion(context.Background(), "crocsoc.WriteFrame").End()
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	// the peer is gone, don't let every later write rediscover it
+	if c.writeErr != nil {
+		return c.writeErr
+	}
+
+	if c.closeSent.Load() {
+		return fmt.Errorf("write after close")
+	}
 
-	if f.Fin {
-		b0 |= 0x80
+	f, err := c.intercept(c.outbound, f)
+	if err != nil {
+		var perr *PanicError
+		if errors.As(err, &perr) {
+			// writeMu is held, so the close frame can't go through
+			// SendCloseFrame
+			c.closeSent.Store(true)
+			c.internalErr = err
+			writeFrame(c.Conn, closeFrame(1011, internalErrorReason), c.Role == RoleClient, time.Time{})
+			c.Conn.Close()
+		}
+		return err
 	}
 
-	b0 |= f.Opcode & 0x0F
+	// dropped by an interceptor
+	if f == nil {
+		return nil
+	}
 
-	header := []byte{b0}
+	f, err = c.deflateFrame(f)
+	if err != nil {
+		return err
+	}
 
-	// header[1] byte
-	// no mask bit needed (server -> client)
-	var b1 byte = 0x0
+	if f.Opcode == 0x8 {
+		c.closeSent.Store(true)
+	}
 
-	payloadLen := len(f.Payload)
+	var deadline time.Time
+	if c.WriteTimeout > 0 {
+		deadline = time.Now().Add(c.WriteTimeout)
+		c.Conn.SetWriteDeadline(deadline)
+		defer c.Conn.SetWriteDeadline(time.Time{})
+	}
 
-	switch {
-	case payloadLen <= 125:
-		b1 |= byte(payloadLen)
-		header = append(header, b1)
-	// uint16
-	case payloadLen <= 65535:
-		b1 |= 126
-		header = append(header, b1)
-
-		ext := make([]byte, 2)
-		binary.BigEndian.PutUint16(ext, uint16(payloadLen))
-		header = append(header, ext...)
+	err = writeFrame(c.Conn, f, c.Role == RoleClient, deadline)
+	if err != nil && peerGone(err) {
+		c.logger().Info("peer went away", "error", err)
+		c.writeErr = abnormalClosure(err)
+		return c.writeErr
+	}
 
-	default:
-		b1 |= 127
-		header = append(header, b1)
+	return err
+}
 
-		ext := make([]byte, 8)
-		binary.BigEndian.PutUint64(ext, uint64(payloadLen))
-		header = append(header, ext...)
+// transient write errors are retried until deadline, see writeRetry
+func writeFrame(conn net.Conn, f *Frame, mask bool, deadline time.Time) error {
+	h := wire.Header{
+		Fin:    f.Fin,
+		Rsv:    f.Rsv,
+		Opcode: f.Opcode,
+		// mask bit only set client -> server
+		Masked: mask,
+		Length: int64(len(f.Payload)),
+	}
+
+	payload := f.Payload
+
+	// a fresh masking key per frame, applied to a copy so the caller's
+	// payload is left untouched
+	if mask {
+		if _, err := rand.Read(h.MaskKey[:]); err != nil {
+			return fmt.Errorf("failed to generate masking key: %v", err)
+		}
+
+		payload = append([]byte(nil), f.Payload...)
+		wire.Mask(h.MaskKey, 0, payload)
 	}
 
 	// send header first seperately to allow larger payloads
-	_, err := conn.Write(header)
+	err := writeRetry(conn, wire.AppendHeader(nil, h), deadline)
 	if err != nil {
 		return err
 	}
 
-	_, err = conn.Write(f.Payload)
-	return err
+	return writeRetry(conn, payload, deadline)
 }
-
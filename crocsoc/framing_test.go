@@ -2,6 +2,7 @@ package crocsoc
 
 import (
 	"bytes"
+	"io"
 	"net"
 	"sync"
 	"testing"
@@ -249,3 +250,124 @@ func TestBinary64kFrame(t *testing.T) {
 }
 
 
+func TestClientRoleMasksFrames(t *testing.T) {
+	serverConn, clientConn := net.Pipe()
+	defer serverConn.Close()
+	defer clientConn.Close()
+
+	client := &WSConn{Conn: clientConn, Role: RoleClient}
+	payload := []byte("Hello")
+
+	go func() {
+		err := client.SendTextFrame(payload)
+		if err != nil {
+			t.Errorf("client write error: %v", err)
+		}
+	}()
+
+	// header + masking key + payload
+	raw := make([]byte, 2+4+len(payload))
+	_, err := io.ReadFull(serverConn, raw)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	if raw[1]&0x80 == 0 {
+		t.Fatalf("client frame missing mask bit")
+	}
+
+	key, masked := raw[2:6], raw[6:]
+	for i := range masked {
+		masked[i] ^= key[i%4]
+	}
+
+	if string(masked) != "Hello" {
+		t.Errorf("want: Hello, got: %s", masked)
+	}
+
+	// masking must not modify the caller's payload
+	if string(payload) != "Hello" {
+		t.Errorf("payload modified by masking: %s", payload)
+	}
+}
+
+func TestServerRoleUnmasked(t *testing.T) {
+	serverConn, clientConn := net.Pipe()
+	defer serverConn.Close()
+	defer clientConn.Close()
+
+	server := &WSConn{Conn: serverConn, Role: RoleServer}
+
+	go func() {
+		err := server.SendTextFrame([]byte("Hello"))
+		if err != nil {
+			t.Errorf("server write error: %v", err)
+		}
+	}()
+
+	// header + payload, no masking key
+	raw := make([]byte, 2+5)
+	_, err := io.ReadFull(clientConn, raw)
+	if err != nil {
+		t.Fatalf("client read error: %v", err)
+	}
+
+	if raw[1]&0x80 != 0 {
+		t.Errorf("server frame has mask bit set")
+	}
+}
+
+func TestCloseWrite(t *testing.T) {
+	serverConn, clientConn := net.Pipe()
+	defer clientConn.Close()
+	client := &WSConn{Conn: clientConn, Role: RoleClient}
+
+	server := &WSConn{Conn: serverConn}
+
+	done := make(chan struct{})
+
+	// client receives the close frame, sends a final message, then answers
+	go func() {
+		defer close(done)
+
+		frame, err := readFrame(clientConn)
+		if err != nil || frame.Opcode != 0x8 {
+			t.Errorf("want close frame, got: %v %v", frame, err)
+			return
+		}
+
+		client.SendTextFrame([]byte("bye"))
+		client.SendCloseFrame(1000, "")
+
+		// server must not send a second close frame
+		if _, err := readFrame(clientConn); err != io.EOF {
+			t.Errorf("want: EOF, got: %v", err)
+		}
+	}()
+
+	if err := server.CloseWrite(1000, "done sending"); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	if err := server.SendTextFrame([]byte("late")); err == nil {
+		t.Errorf("write after close frame accepted")
+	}
+
+	msg, err := server.ReadMessage()
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if string(msg) != "bye" {
+		t.Errorf("want: bye, got: %s", msg)
+	}
+
+	if _, err := server.ReadMessage(); err != io.EOF {
+		t.Errorf("want: EOF, got: %v", err)
+	}
+
+	if !server.IsClosed {
+		t.Errorf("connection not marked closed")
+	}
+
+	<-done
+}
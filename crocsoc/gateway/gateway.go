@@ -0,0 +1,127 @@
+// Package gateway exposes raw TCP protocols over WebSocket infrastructure.
+// Each accepted TCP connection gets its own WebSocket connection to an
+// upstream, with bytes read from TCP sent as binary messages and messages
+// received written back to TCP.
+//
+//	ln, _ := net.Listen("tcp", ":5432")
+//	gw := &gateway.Gateway{Upstream: "wss://edge.example.com/tunnel"}
+//	log.Fatal(gw.Serve(ln))
+package gateway
+
+import (
+	"context"
+	"crypto/tls"
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/pgxtips/crocsoc/crocsoc"
+)
+
+type Gateway struct {
+	// ws:// or wss:// URL each TCP connection is bridged to
+	Upstream string
+	// extra upgrade request headers, e.g. Authorization
+	Header http.Header
+	// used for wss:// upstreams, ServerName defaults to the upstream host
+	TLSConfig *tls.Config
+
+	// most bytes sent per binary message, 32KB by default
+	ChunkSize int
+	// how long to wait for the upstream to answer a close, 5s by default
+	CloseTimeout time.Duration
+}
+
+// Accepts TCP connections on ln and bridges each to the upstream until ln
+// fails, returning the Accept error.
+func (g *Gateway) Serve(ln net.Listener) error {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return err
+		}
+
+		go func() {
+			if err := g.Bridge(context.Background(), conn); err != nil {
+				slog.Warn("gateway bridge failed", "remote_addr", conn.RemoteAddr(), "err", err)
+			}
+		}()
+	}
+}
+
+// Connects to the upstream and relays between it and conn until either
+// side hangs up, then closes conn. A TCP EOF starts the close handshake
+// with the upstream.
+func (g *Gateway) Bridge(ctx context.Context, conn net.Conn) error {
+	defer conn.Close()
+
+	ws, err := g.dial(ctx)
+	if err != nil {
+		return err
+	}
+	defer ws.Conn.Close()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		// once TCP stops taking writes keep reading, so the close
+		// handshake still completes
+		tcpGone := false
+		for {
+			msg, err := ws.ReadMessage()
+			if err != nil {
+				conn.Close()
+				return
+			}
+
+			if !tcpGone {
+				if _, err := conn.Write(msg); err != nil {
+					tcpGone = true
+					conn.Close()
+				}
+			}
+		}
+	}()
+
+	size := g.ChunkSize
+	if size <= 0 {
+		size = 32 << 10
+	}
+
+	buf := make([]byte, size)
+	for {
+		n, err := conn.Read(buf)
+		if n > 0 {
+			if werr := ws.SendBinaryFrame(buf[:n]); werr != nil {
+				break
+			}
+		}
+		if err != nil {
+			break
+		}
+	}
+
+	// fails harmlessly when the upstream closed first
+	ws.CloseWrite(1000, "")
+
+	// an upstream that never answers the close mustn't hold the bridge
+	timeout := g.CloseTimeout
+	if timeout <= 0 {
+		timeout = 5 * time.Second
+	}
+	ws.Conn.SetReadDeadline(time.Now().Add(timeout))
+	<-done
+
+	return nil
+}
+
+// performs the client side opening handshake with the upstream
+func (g *Gateway) dial(ctx context.Context) (*crocsoc.WSConn, error) {
+	conn, _, err := crocsoc.Dial(ctx, g.Upstream, &crocsoc.DialOptions{Header: g.Header, TLSConfig: g.TLSConfig})
+	if err != nil {
+		return nil, err
+	}
+	return conn.WSConn, nil
+}
@@ -0,0 +1,122 @@
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/pgxtips/crocsoc/crocsoc"
+)
+
+// upstream echoing every message back upper cased
+func echoUpstream(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := crocsoc.OpeningHandshake(w, r); err != nil {
+			t.Errorf("%v", err)
+			return
+		}
+
+		conn, _, err := http.NewResponseController(w).Hijack()
+		if err != nil {
+			t.Errorf("%v", err)
+			return
+		}
+
+		ws := &crocsoc.WSConn{Conn: conn}
+		for {
+			msg, err := ws.ReadMessage()
+			if err != nil {
+				return
+			}
+			ws.SendBinaryFrame(bytes.ToUpper(msg))
+		}
+	}))
+}
+
+func TestBridge(t *testing.T) {
+	srv := echoUpstream(t)
+	defer srv.Close()
+
+	gw := &Gateway{Upstream: "ws" + srv.URL[len("http"):], ChunkSize: 4}
+
+	local, remote := net.Pipe()
+	bridged := make(chan error)
+	go func() { bridged <- gw.Bridge(context.Background(), remote) }()
+
+	if _, err := local.Write([]byte("hello")); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	// chunked into "hell" and "o"
+	got := make([]byte, 5)
+	if _, err := io.ReadFull(local, got); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if string(got) != "HELLO" {
+		t.Errorf("want: HELLO, got: %s", got)
+	}
+
+	local.Close()
+	if err := <-bridged; err != nil {
+		t.Errorf("%v", err)
+	}
+}
+
+func TestBridgeUpstreamRejects(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	defer srv.Close()
+
+	gw := &Gateway{Upstream: "ws" + srv.URL[len("http"):]}
+
+	local, remote := net.Pipe()
+	defer local.Close()
+
+	var herr *crocsoc.HandshakeError
+	err := gw.Bridge(context.Background(), remote)
+	if err == nil || !errors.As(err, &herr) || herr.StatusCode != http.StatusNotFound {
+		t.Errorf("want: 404 HandshakeError, got: %v", err)
+	}
+}
+
+func TestBridgeUpstreamIgnoresClose(t *testing.T) {
+	hang := make(chan struct{})
+	defer close(hang)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := crocsoc.OpeningHandshake(w, r); err != nil {
+			t.Errorf("%v", err)
+			return
+		}
+
+		conn, _, err := http.NewResponseController(w).Hijack()
+		if err != nil {
+			t.Errorf("%v", err)
+			return
+		}
+		defer conn.Close()
+
+		// takes the close frame, never answers it
+		go io.Copy(io.Discard, conn)
+		<-hang
+	}))
+	defer srv.Close()
+
+	gw := &Gateway{Upstream: "ws" + srv.URL[len("http"):], CloseTimeout: 50 * time.Millisecond}
+
+	local, remote := net.Pipe()
+	bridged := make(chan error)
+	go func() { bridged <- gw.Bridge(context.Background(), remote) }()
+
+	local.Close()
+	select {
+	case <-bridged:
+	case <-time.After(time.Second):
+		t.Errorf("Bridge still waiting on the upstream's close")
+	}
+}
@@ -0,0 +1,219 @@
+//go:build unix
+
+// Package handoff passes live WebSocket connections to another process over
+// a unix socket, so a deploy can replace the server without clients
+// noticing. It is experimental.
+//
+// The old process sends each connection along with the little state a
+// WSConn needs, the new one receives it and carries on reading and writing
+// frames:
+//
+//	// old process, once the new one is listening on sock
+//	uc, _ := net.DialUnix("unix", nil, &net.UnixAddr{Name: sock, Net: "unix"})
+//	handoff.Send(uc, conn)
+//
+//	// new process
+//	conn, err := handoff.Receive(uc)
+//
+// Only plain TCP connections can be handed off, TLS session state stays
+// in the process that negotiated it. Connections must be idle when sent:
+// no frame half read or half written, and nothing else reading them.
+package handoff
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"syscall"
+
+	"github.com/pgxtips/crocsoc/crocsoc"
+)
+
+/*
+Each connection is one message on the unix socket:
+
+	length (4 bytes, big endian) | JSON State
+
+with the connection's file descriptor attached to it as SCM_RIGHTS
+ancillary data.
+*/
+
+// largest State accepted, bufio buffers are 4KB by default
+const maxState = 1 << 20
+
+// What a WSConn needs to carry on in another process.
+type State struct {
+	ID          uint64
+	RequestID   string
+	Subprotocol string
+	Role        crocsoc.Role
+	Secure      bool
+	Compression bool
+
+	// read from the socket but not yet parsed, e.g. by the http server
+	// before the connection was hijacked
+	Buffered []byte
+}
+
+// Sends conn and its state over uc, then closes conn in this process
+// without a close frame; the peer keeps talking to the receiver.
+func Send(uc *net.UnixConn, conn *crocsoc.WSConn) error {
+	// look through wrappers such as the Upgrader's read buffer, whose
+	// contents are sent along from RW
+	nc := conn.Conn
+	for {
+		w, ok := nc.(interface{ NetConn() net.Conn })
+		if !ok {
+			break
+		}
+		nc = w.NetConn()
+	}
+
+	tcp, ok := nc.(*net.TCPConn)
+	if !ok {
+		return fmt.Errorf("handoff: cannot hand off %T, only plain TCP connections", nc)
+	}
+
+	state := State{
+		ID:          conn.ID,
+		RequestID:   conn.RequestID,
+		Subprotocol: conn.Subprotocol,
+		Role:        conn.Role,
+		Secure:      conn.Secure,
+		Compression: conn.Compression,
+	}
+	if conn.RW != nil {
+		state.Buffered, _ = conn.RW.Reader.Peek(conn.RW.Reader.Buffered())
+	}
+
+	body, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	msg := binary.BigEndian.AppendUint32(nil, uint32(len(body)))
+	msg = append(msg, body...)
+
+	// a duplicate descriptor, the original goes with tcp
+	f, err := tcp.File()
+	if err != nil {
+		return fmt.Errorf("handoff: %v", err)
+	}
+	defer f.Close()
+
+	n, _, err := uc.WriteMsgUnix(msg, syscall.UnixRights(int(f.Fd())), nil)
+	if err != nil {
+		return fmt.Errorf("handoff: %v", err)
+	}
+	if n != len(msg) {
+		return fmt.Errorf("handoff: short write")
+	}
+
+	// the receiver has its own descriptor now, closing ours leaves the
+	// connection open
+	tcp.Close()
+	conn.IsClosed = true
+
+	return nil
+}
+
+// Receives a connection sent with Send, returning io.EOF once the sender
+// has hung up.
+func Receive(uc *net.UnixConn) (*crocsoc.WSConn, error) {
+	// the descriptor arrives with the first byte of the message, read no
+	// further than the length so it can't arrive with the next one
+	head := make([]byte, 4)
+	oob := make([]byte, syscall.CmsgSpace(4))
+
+	n, oobn, _, _, err := uc.ReadMsgUnix(head, oob)
+	if err != nil {
+		return nil, err
+	}
+	if n == 0 {
+		return nil, io.EOF
+	}
+
+	file, err := parseRights(oob[:oobn])
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	if _, err := io.ReadFull(uc, head[n:]); err != nil {
+		return nil, fmt.Errorf("handoff: truncated message: %v", err)
+	}
+
+	size := binary.BigEndian.Uint32(head)
+	if size > maxState {
+		return nil, fmt.Errorf("handoff: state of %d bytes too large", size)
+	}
+
+	body := make([]byte, size)
+	if _, err := io.ReadFull(uc, body); err != nil {
+		return nil, fmt.Errorf("handoff: truncated message: %v", err)
+	}
+
+	var state State
+	if err := json.Unmarshal(body, &state); err != nil {
+		return nil, fmt.Errorf("handoff: malformed state: %v", err)
+	}
+
+	nc, err := net.FileConn(file)
+	if err != nil {
+		return nil, fmt.Errorf("handoff: %v", err)
+	}
+
+	if len(state.Buffered) > 0 {
+		nc = &bufferedConn{Conn: nc, buf: state.Buffered}
+	}
+
+	return &crocsoc.WSConn{
+		ID:          state.ID,
+		Conn:        nc,
+		RequestID:   state.RequestID,
+		Subprotocol: state.Subprotocol,
+		Role:        state.Role,
+		Secure:      state.Secure,
+		Compression: state.Compression,
+	}, nil
+}
+
+func parseRights(oob []byte) (*os.File, error) {
+	msgs, err := syscall.ParseSocketControlMessage(oob)
+	if err != nil {
+		return nil, fmt.Errorf("handoff: %v", err)
+	}
+
+	for _, m := range msgs {
+		fds, err := syscall.ParseUnixRights(&m)
+		if err != nil || len(fds) == 0 {
+			continue
+		}
+
+		// only one is ever sent
+		for _, fd := range fds[1:] {
+			syscall.Close(fd)
+		}
+		return os.NewFile(uintptr(fds[0]), "handoff"), nil
+	}
+
+	return nil, errors.New("handoff: message carried no connection")
+}
+
+// serves bytes the sender had buffered before reading the socket
+type bufferedConn struct {
+	net.Conn
+	buf []byte
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	if len(c.buf) > 0 {
+		n := copy(p, c.buf)
+		c.buf = c.buf[n:]
+		return n, nil
+	}
+	return c.Conn.Read(p)
+}
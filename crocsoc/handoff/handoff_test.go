@@ -0,0 +1,112 @@
+//go:build unix
+
+package handoff
+
+import (
+	"net"
+	"path/filepath"
+	"testing"
+
+	"github.com/pgxtips/crocsoc/crocsoc"
+)
+
+func unixPair(t *testing.T) (*net.UnixConn, *net.UnixConn) {
+	t.Helper()
+
+	addr := &net.UnixAddr{Name: filepath.Join(t.TempDir(), "handoff.sock"), Net: "unix"}
+	ln, err := net.ListenUnix("unix", addr)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer ln.Close()
+
+	sender, err := net.DialUnix("unix", nil, addr)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	receiver, err := ln.AcceptUnix()
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	t.Cleanup(func() {
+		sender.Close()
+		receiver.Close()
+	})
+	return sender, receiver
+}
+
+func TestHandoff(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer ln.Close()
+
+	clientConn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer clientConn.Close()
+
+	serverConn, err := ln.Accept()
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	sender, receiver := unixPair(t)
+
+	old := &crocsoc.WSConn{ID: 7, Conn: serverConn, RequestID: "req", Subprotocol: "chat", Secure: true}
+	if err := Send(sender, old); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	conn, err := Receive(receiver)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer conn.Conn.Close()
+
+	if conn.ID != 7 || conn.RequestID != "req" || conn.Subprotocol != "chat" || !conn.Secure || conn.Role != crocsoc.RoleServer {
+		t.Errorf("state lost: %+v", conn)
+	}
+
+	// the client never noticed
+	client := &crocsoc.WSConn{Conn: clientConn, Role: crocsoc.RoleClient}
+	go client.SendTextFrame([]byte("still here"))
+
+	msg, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if string(msg) != "still here" {
+		t.Errorf("want: still here, got: %s", msg)
+	}
+}
+
+func TestHandoffRejectsNonTCP(t *testing.T) {
+	a, b := net.Pipe()
+	defer a.Close()
+	defer b.Close()
+
+	sender, _ := unixPair(t)
+	if err := Send(sender, &crocsoc.WSConn{Conn: a}); err == nil {
+		t.Errorf("pipe handed off")
+	}
+}
+
+func TestBufferedConn(t *testing.T) {
+	a, b := net.Pipe()
+	defer a.Close()
+	defer b.Close()
+
+	c := &bufferedConn{Conn: a, buf: []byte("ab")}
+	go b.Write([]byte("cd"))
+
+	got := make([]byte, 4)
+	n, _ := c.Read(got)
+	m, _ := c.Read(got[n:])
+	if string(got[:n+m]) != "abcd" {
+		t.Errorf("want: abcd, got: %s", got[:n+m])
+	}
+}
@@ -2,6 +2,7 @@ package crocsoc
 
 import (
 	"crypto/sha1"
+	"crypto/subtle"
 	"encoding/base64"
 	"fmt"
 	"io"
@@ -71,12 +72,12 @@ appropriate error code (such as 400 Bad Request).
 
 [x] - A |Sec-WebSocket-Version| header field, with a value of 13.
 
-[ ] - Optionally, an |Origin| header field.  This header field is sent
+[x] - Optionally, an |Origin| header field.  This header field is sent
 	by all browser clients.  A connection attempt lacking this
 	header field SHOULD NOT be interpreted as coming from a browser
 	client.
 
-[ ] - Optionally, a |Sec-WebSocket-Protocol| header field, with a list
+[x] - Optionally, a |Sec-WebSocket-Protocol| header field, with a list
 	of values indicating which protocols the client would like to
 	speak, ordered by preference.
 
@@ -165,10 +166,10 @@ the |Sec-WebSocket-Accept| header field.
 
 
 // SHA-1 hashes Sec-WebSocket-Key as part of the "1.3 Opening Handshake". 
-// This hash value must be base64 encoded before writing the 
-// Sec-WebSocket-Accept header!
+// Returns the hash base64 encoded, ready to write as the 
+// Sec-WebSocket-Accept header.
 
-func SecAcceptSha(wk string) []byte {
+func SecAcceptSha(wk string) string {
 	const guid = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
 	wk = strings.TrimSpace(wk)
 
@@ -176,7 +177,15 @@ func SecAcceptSha(wk string) []byte {
 	io.WriteString(hash, wk)
 	io.WriteString(hash, guid)
 
-	return hash.Sum(nil)
+	return base64.StdEncoding.EncodeToString(hash.Sum(nil))
+}
+
+// Reports whether accept is the Sec-WebSocket-Accept value for the client
+// key wk. The comparison is constant-time.
+func VerifyAccept(wk, accept string) bool {
+	want := SecAcceptSha(wk)
+	got := strings.TrimSpace(accept)
+	return subtle.ConstantTimeCompare([]byte(got), []byte(want)) == 1
 }
 
 /*
@@ -366,7 +375,23 @@ sending (and receiving) data.
 */
 
 func OpeningHandshake(w http.ResponseWriter, r *http.Request) error {
+	if err := validateUpgrade(r); err != nil {
+		return err
+	}
+
+	// create the server response hash
+	b64 := SecAcceptSha(r.Header.Get("Sec-WebSocket-Key"))
+
+	w.Header().Add("Upgrade", "websocket")
+	w.Header().Add("Connection", "Upgrade")
+	w.Header().Add("Sec-WebSocket-Accept", b64)
+	w.WriteHeader(http.StatusSwitchingProtocols)
 
+	return nil
+}
+
+// checks everything OpeningHandshake does before it responds
+func validateUpgrade(r *http.Request) error {
 	// only allow GET methods
 	if r.Method != http.MethodGet {
 		return fmt.Errorf("Method Not Allowed")
@@ -377,14 +402,5 @@ func OpeningHandshake(w http.ResponseWriter, r *http.Request) error {
 		return fmt.Errorf("%v", err.Error())
 	}
 
-	// create the server response hash
-	h := SecAcceptSha(r.Header.Get("Sec-WebSocket-Key"))
-	b64 := base64.StdEncoding.EncodeToString(h)
-
-	w.Header().Add("Upgrade", "websocket")
-	w.Header().Add("Connection", "Upgrade")
-	w.Header().Add("Sec-WebSocket-Accept", b64)
-	w.WriteHeader(http.StatusSwitchingProtocols)
-
 	return nil
 }
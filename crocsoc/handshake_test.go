@@ -119,7 +119,10 @@ func TestHeadersUnhappy(t *testing.T){
 
 func TestSha1(t *testing.T){
 	wk := "dGhlIHNhbXBsZSBub25jZQ=="
-	val := SecAcceptSha(wk)
+	val, err := base64.StdEncoding.DecodeString(SecAcceptSha(wk))
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
 	expected := []byte{0xb3, 0x7a, 0x4f, 0x2c, 0xc0, 0x62, 0x4f, 0x16, 0x90, 0xf6, 0x46, 0x06, 0xcf, 0x38, 0x59, 0x45, 0xb2, 0xbe, 0xc4, 0xea}
 
 	if !bytes.Equal(val, expected){
@@ -137,6 +140,20 @@ func TestB64(t *testing.T){
 	}
 }
 
+func TestVerifyAccept(t *testing.T){
+	wk := "dGhlIHNhbXBsZSBub25jZQ=="
+
+	if !VerifyAccept(wk, "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=") {
+		t.Errorf("valid accept rejected")
+	}
+
+	for _, bad := range []string{"", "s3pPLMBiTxaQ9kYGzzhZRbK+xOp=", "s3pPLMBiTxaQ9kYGzzhZRbK+xOo"} {
+		if VerifyAccept(wk, bad) {
+			t.Errorf("invalid accept %q verified", bad)
+		}
+	}
+}
+
 func TestOpeningHandshake(t * testing.T){
 	r := buildRequest(map[string]string{
 		"Upgrade": "websocket",
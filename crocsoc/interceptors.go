@@ -0,0 +1,36 @@
+package crocsoc
+
+// Inspects or rewrites a single frame on its way in or out of a connection.
+// Returning a nil frame drops it; returning an error fails the read or write
+// that carried it.
+//
+// Inbound interceptors see frames after unmasking, before control frames are
+// answered or fragments reassembled. Outbound interceptors see frames before
+// masking, so one interceptor can serve both roles.
+type FrameInterceptor func(*Frame) (*Frame, error)
+
+// Adds fn to the end of the inbound chain. Interceptors must be registered
+// before the connection is read from.
+func (c *WSConn) InterceptInbound(fn FrameInterceptor) {
+	c.inbound = append(c.inbound, fn)
+}
+
+// Adds fn to the end of the outbound chain. Interceptors must be registered
+// before the connection is written to.
+func (c *WSConn) InterceptOutbound(fn FrameInterceptor) {
+	c.outbound = append(c.outbound, fn)
+}
+
+// runs f through chain in registration order, stopping at a drop or error.
+// A panicking interceptor is recovered and reported as a *PanicError.
+func (c *WSConn) intercept(chain []FrameInterceptor, f *Frame) (_ *Frame, err error) {
+	defer c.recoverPanic(&err)
+
+	for _, fn := range chain {
+		f, err = fn(f)
+		if err != nil || f == nil {
+			return nil, err
+		}
+	}
+	return f, nil
+}
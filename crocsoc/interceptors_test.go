@@ -0,0 +1,68 @@
+package crocsoc
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"testing"
+)
+
+func TestInboundInterceptors(t *testing.T) {
+	serverConn, clientConn := net.Pipe()
+	defer serverConn.Close()
+	defer clientConn.Close()
+	client := &WSConn{Conn: clientConn, Role: RoleClient}
+
+	server := &WSConn{Conn: serverConn}
+
+	// drop binary frames, upper case text
+	server.InterceptInbound(func(f *Frame) (*Frame, error) {
+		if f.Opcode == 0x2 {
+			return nil, nil
+		}
+		return f, nil
+	})
+	server.InterceptInbound(func(f *Frame) (*Frame, error) {
+		f.Payload = bytes.ToUpper(f.Payload)
+		return f, nil
+	})
+
+	go func() {
+		client.SendBinaryFrame([]byte("dropped"))
+		client.SendTextFrame([]byte("hello"))
+	}()
+
+	msg, err := server.ReadMessage()
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	if string(msg) != "HELLO" {
+		t.Errorf("want: HELLO, got: %s", msg)
+	}
+}
+
+func TestOutboundInterceptorError(t *testing.T) {
+	serverConn, clientConn := net.Pipe()
+	defer serverConn.Close()
+	defer clientConn.Close()
+
+	server := &WSConn{Conn: serverConn}
+
+	var seen []byte
+	server.InterceptOutbound(func(f *Frame) (*Frame, error) {
+		seen = append(seen, f.Opcode)
+		if len(f.Payload) > 4 {
+			return nil, fmt.Errorf("payload too large")
+		}
+		return f, nil
+	})
+
+	if err := server.SendTextFrame([]byte("too long")); err == nil {
+		t.Errorf("interceptor error not returned")
+	}
+
+	if len(seen) != 1 || seen[0] != 0x1 {
+		t.Errorf("unexpected frames intercepted: %v", seen)
+	}
+}
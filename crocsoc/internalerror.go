@@ -0,0 +1,34 @@
+package crocsoc
+
+// all the peer learns about an internal error, details stay in the logs
+const internalErrorReason = "internal error"
+
+/*
+1011 indicates that a server is terminating the connection because
+it encountered an unexpected condition that prevented it from
+fulfilling the request.
+*/
+
+// Starts closing the connection because of a server-side failure, such as a
+// codec or handler error. err is logged and reported with the EventClose
+// that ends the connection's Events, while the peer only gets a 1011 close
+// frame with a generic reason, so internals don't leak to clients. Keep
+// reading to complete the close handshake.
+func (c *WSConn) CloseInternal(err error) error {
+	c.logger().Error("closing on internal error", "error", err)
+
+	c.writeMu.Lock()
+	if c.internalErr == nil {
+		c.internalErr = err
+	}
+	c.writeMu.Unlock()
+
+	return c.SendCloseFrame(1011, internalErrorReason)
+}
+
+// the error CloseInternal was called with, if any
+func (c *WSConn) closeCause() error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	return c.internalErr
+}
@@ -0,0 +1,50 @@
+package crocsoc
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"testing"
+)
+
+func TestCloseInternal(t *testing.T) {
+	serverConn, clientConn := net.Pipe()
+	defer serverConn.Close()
+	defer clientConn.Close()
+	client := &WSConn{Conn: clientConn, Role: RoleClient}
+
+	server := &WSConn{Conn: serverConn}
+	events := server.Events()
+	<-events // open
+
+	cause := errors.New("db: connection refused on 10.0.0.5")
+
+	go func() {
+		frame, err := readFrame(clientConn)
+		if err != nil || frame.Opcode != 0x8 {
+			t.Errorf("want close frame, got: %v %v", frame, err)
+			return
+		}
+
+		code := binary.BigEndian.Uint16(frame.Payload)
+		reason := string(frame.Payload[2:])
+		if code != 1011 || reason != internalErrorReason {
+			t.Errorf("want: 1011 %s, got: %d %s", internalErrorReason, code, reason)
+		}
+
+		client.SendCloseFrame(1011, "")
+	}()
+
+	if err := server.CloseInternal(cause); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	var last Event
+	for ev := range events {
+		last = ev
+	}
+
+	if last.Type != EventClose || !errors.Is(last.Err, cause) {
+		t.Errorf("want close event carrying the cause, got: %+v", last)
+	}
+}
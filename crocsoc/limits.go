@@ -0,0 +1,168 @@
+package crocsoc
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/pgxtips/crocsoc/crocsoc/ratelimit"
+)
+
+// Returned by ReadMessage when a message is longer than the read limit. The
+// connection has sent a 1009 close frame and closed by then, as the rest of
+// the message is left unread.
+var ErrMessageTooBig = errors.New("message too big")
+
+// Returned by ReadMessage when messages arrive faster than the rate limit.
+// The connection has sent a 1008 close frame by then.
+var ErrRateLimited = errors.New("rate limit exceeded")
+
+// payload of the next frame would go over the read limit
+var errFrameTooBig = errors.New("frame too big")
+
+// read limit of connections from an Upgrader without a ReadLimit
+const defaultReadLimit = 32 << 20
+
+// Sets the maximum length of a message ReadMessage accepts, 0 for no limit
+// beyond 32MB for compressed messages once inflated.
+// Safe to call while another goroutine is reading, e.g. to raise the limit
+// once a client has authenticated; the new limit applies from the next frame
+// read.
+func (c *WSConn) SetReadLimit(n int64) {
+	c.readLimit.Store(n)
+}
+
+// Returns the limit set with SetReadLimit.
+func (c *WSConn) ReadLimit() int64 {
+	return c.readLimit.Load()
+}
+
+// how much more payload the message being reassembled may take, -1 for no
+// limit
+func (c *WSConn) remainingLimit(frags []*Frame) int64 {
+	var read int64
+	for _, f := range frags {
+		read += int64(len(f.Payload))
+	}
+	return c.remainingAfter(read)
+}
+
+// how much more payload a message may take after read bytes, -1 for no
+// limit
+func (c *WSConn) remainingAfter(read int64) int64 {
+	limit := c.readLimit.Load()
+	if limit <= 0 {
+		return -1
+	}
+	return max(limit-read, 0)
+}
+
+/*
+1009 indicates that an endpoint is terminating the connection
+because it has received a message that is too big for it to
+process.
+*/
+func (c *WSConn) rejectTooBig() error {
+	limit := c.readLimit.Load()
+	// only inflating goes over without a read limit
+	if limit <= 0 {
+		limit = maxInflateSize
+	}
+	c.logger().Warn("rejecting message", "reason", "too big", "limit", limit)
+
+	err := c.SendCloseFrame(1009, "message too big")
+
+	// the unread payload leaves the stream mid frame, so the close
+	// handshake can't be completed
+	c.Conn.Close()
+	c.IsClosed = true
+
+	if err != nil {
+		return err
+	}
+	return fmt.Errorf("%w: limit %d bytes", ErrMessageTooBig, limit)
+}
+
+// Limits inbound text and binary messages to rate per second, in bursts of
+// up to burst, closing with 1008 past it. A rate of 0 removes the limit.
+// Safe to call while another goroutine is reading; the new limit starts
+// with a full burst and applies from the next message.
+func (c *WSConn) SetRateLimit(rate float64, burst int) {
+	if rate <= 0 {
+		c.rateLimit.Store(nil)
+		return
+	}
+	c.rateLimit.Store(ratelimit.NewTokenBucket(rate, max(burst, 1)))
+}
+
+// takes a message off the rate limit, failing the connection with 1008
+// when it's used up
+func (c *WSConn) checkRate() error {
+	b := c.rateLimit.Load()
+	if b == nil || b.Allow() {
+		return nil
+	}
+
+	c.logger().Warn("rejecting message", "reason", "rate limit")
+	c.SendCloseFrame(1008, "rate limit exceeded")
+	return ErrRateLimited
+}
+
+// sends pings on a ticker, see SetPingInterval
+type pinger struct {
+	mu     sync.Mutex
+	ticker *time.Ticker
+	stop   chan struct{}
+}
+
+// Sends a ping every d, e.g. to keep idle connections open through proxies
+// or, read alongside Events, to notice dead peers. 0 stops pinging. Safe to
+// call at any time; a new interval applies from the next ping. Pinging
+// stops by itself once a ping fails to send.
+func (c *WSConn) SetPingInterval(d time.Duration) {
+	p := &c.pinger
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if d <= 0 {
+		if p.ticker != nil {
+			p.ticker.Stop()
+			close(p.stop)
+			p.ticker, p.stop = nil, nil
+		}
+		return
+	}
+
+	if p.ticker != nil {
+		p.ticker.Reset(d)
+		return
+	}
+
+	p.ticker = time.NewTicker(d)
+	p.stop = make(chan struct{})
+	go c.ping(p.ticker, p.stop)
+}
+
+func (c *WSConn) ping(ticker *time.Ticker, stop chan struct{}) {
+	for {
+		select {
+		case <-ticker.C:
+			if err := c.SendPingFrame(nil); err != nil {
+				c.logger().Debug("stopped pinging", "err", err)
+
+				p := &c.pinger
+				p.mu.Lock()
+				// unless it was stopped or restarted meanwhile
+				if p.stop == stop {
+					p.ticker.Stop()
+					p.ticker, p.stop = nil, nil
+				}
+				p.mu.Unlock()
+				return
+			}
+		case <-stop:
+			return
+		}
+	}
+}
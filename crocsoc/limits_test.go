@@ -0,0 +1,215 @@
+package crocsoc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestReadLimit(t *testing.T) {
+	// the rejected payload is never read, which would block a net.Pipe writer
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer ln.Close()
+
+	clientConn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer clientConn.Close()
+
+	serverConn, err := ln.Accept()
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	server := &WSConn{Conn: serverConn}
+	server.SetReadLimit(8)
+
+	client := &WSConn{Conn: clientConn, Role: RoleClient}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		client.SendTextFrame([]byte("12345678"))
+
+		// fits once the limit is raised
+		client.SendTextFrame(bytes.Repeat([]byte("a"), 16))
+
+		// fragments add up past the limit, with a ping in between
+		client.WriteFrame(&Frame{Fin: false, Opcode: 0x1, Payload: bytes.Repeat([]byte("b"), 10)})
+		client.WriteFrame(&Frame{Fin: true, Opcode: 0x9, Payload: []byte("ping")})
+		client.WriteFrame(&Frame{Fin: true, Opcode: 0x0, Payload: bytes.Repeat([]byte("b"), 10)})
+
+		if pong, err := readFrame(clientConn); err != nil || pong.Opcode != 0xA {
+			t.Errorf("want pong, got: %v %v", pong, err)
+			return
+		}
+
+		frame, err := readFrame(clientConn)
+		if err != nil || frame.Opcode != 0x8 {
+			t.Errorf("want close frame, got: %v %v", frame, err)
+			return
+		}
+		if code := binary.BigEndian.Uint16(frame.Payload); code != 1009 {
+			t.Errorf("want: 1009, got: %d", code)
+		}
+	}()
+
+	if msg, err := server.ReadMessage(); err != nil || string(msg) != "12345678" {
+		t.Errorf("message at limit: %q %v", msg, err)
+	}
+
+	server.SetReadLimit(16)
+	if server.ReadLimit() != 16 {
+		t.Errorf("want: 16, got: %d", server.ReadLimit())
+	}
+	if _, err := server.ReadMessage(); err != nil {
+		t.Errorf("%v", err)
+	}
+
+	if _, err := server.ReadMessage(); !errors.Is(err, ErrMessageTooBig) {
+		t.Errorf("want: ErrMessageTooBig, got: %v", err)
+	}
+	if !server.IsClosed {
+		t.Errorf("connection not closed")
+	}
+
+	<-done
+}
+
+func TestOversizedControlFrame(t *testing.T) {
+	serverConn, clientConn := net.Pipe()
+	defer clientConn.Close()
+
+	server := &WSConn{Conn: serverConn}
+	server.SetReadLimit(1024)
+
+	// a masked ping header declaring about 1TB, with no payload behind it
+	go clientConn.Write([]byte{0x89, 0xFF, 0, 0, 1, 0, 0, 0, 0, 0, 0, 0, 0, 0})
+
+	closed := make(chan *Frame, 1)
+	go func() {
+		f, _ := readFrame(clientConn)
+		closed <- f
+	}()
+
+	if _, err := server.ReadMessage(); !errors.Is(err, ErrInvalidControlFrame) {
+		t.Errorf("want: %v, got: %v", ErrInvalidControlFrame, err)
+	}
+
+	f := <-closed
+	if f == nil || f.Opcode != 0x8 || binary.BigEndian.Uint16(f.Payload) != 1002 {
+		t.Errorf("want: 1002 close, got: %+v", f)
+	}
+}
+
+func TestHugeFrameLength(t *testing.T) {
+	serverConn, clientConn := net.Pipe()
+	defer clientConn.Close()
+
+	// no read limit, a binary frame declaring 2^63-1 bytes then hanging up
+	go func() {
+		clientConn.Write([]byte{0x82, 0xFF, 0x7F, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0, 0, 0, 0})
+		clientConn.Write([]byte("abc"))
+		clientConn.Close()
+	}()
+
+	server := &WSConn{Conn: serverConn}
+	if _, err := server.ReadMessage(); err == nil {
+		t.Errorf("want: error, got: nil")
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	serverConn, clientConn := net.Pipe()
+	defer serverConn.Close()
+	defer clientConn.Close()
+
+	server := &WSConn{Conn: serverConn}
+	server.SetRateLimit(1, 2)
+
+	client := &WSConn{Conn: clientConn, Role: RoleClient}
+
+	closed := make(chan *Frame, 1)
+	go func() {
+		for range 3 {
+			client.SendTextFrame([]byte("hi"))
+		}
+		f, _ := readFrame(clientConn)
+		closed <- f
+	}()
+
+	// the burst goes through
+	for range 2 {
+		if _, err := server.ReadMessage(); err != nil {
+			t.Fatalf("%v", err)
+		}
+	}
+
+	if _, err := server.ReadMessage(); !errors.Is(err, ErrRateLimited) {
+		t.Errorf("want: %v, got: %v", ErrRateLimited, err)
+	}
+	if f := <-closed; f == nil || f.Opcode != 0x8 || binary.BigEndian.Uint16(f.Payload) != 1008 {
+		t.Errorf("want: 1008 close, got: %+v", f)
+	}
+}
+
+func TestRateLimitRemoved(t *testing.T) {
+	serverConn, clientConn := net.Pipe()
+	defer serverConn.Close()
+	defer clientConn.Close()
+
+	server := &WSConn{Conn: serverConn}
+	server.SetRateLimit(1, 1)
+	// e.g. once the client has authenticated
+	server.SetRateLimit(0, 0)
+
+	client := &WSConn{Conn: clientConn, Role: RoleClient}
+	go func() {
+		for range 5 {
+			client.SendTextFrame([]byte("hi"))
+		}
+	}()
+
+	for range 5 {
+		if _, err := server.ReadMessage(); err != nil {
+			t.Fatalf("%v", err)
+		}
+	}
+}
+
+func TestPingInterval(t *testing.T) {
+	serverConn, clientConn := net.Pipe()
+	defer serverConn.Close()
+	defer clientConn.Close()
+
+	server := &WSConn{Conn: serverConn}
+	server.SetPingInterval(time.Hour)
+	// adjusted while running
+	server.SetPingInterval(10 * time.Millisecond)
+
+	for range 2 {
+		clientConn.SetReadDeadline(time.Now().Add(time.Second))
+		f, err := readFrame(clientConn)
+		if err != nil || f.Opcode != 0x9 {
+			t.Fatalf("want ping, got: %v %v", f, err)
+		}
+	}
+
+	server.SetPingInterval(0)
+
+	// at most one ping already on its way
+	clientConn.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	readFrame(clientConn)
+	clientConn.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	if f, err := readFrame(clientConn); err == nil {
+		t.Errorf("want: no more pings, got: %+v", f)
+	}
+}
@@ -0,0 +1,48 @@
+package crocsoc
+
+import (
+	"errors"
+	"io"
+	"iter"
+)
+
+// A complete text or binary message.
+type Message struct {
+	// 0x1 text or 0x2 binary
+	Opcode byte
+	Data   []byte
+}
+
+// Iterates over incoming messages until the connection closes:
+//
+//	for msg, err := range conn.Messages() {
+//		if err != nil {
+//			return err
+//		}
+//		...
+//	}
+//
+// A read error is yielded once and ends the iteration. Breaking out of the
+// loop early closes the connection with 1000 Normal Closure.
+func (c *WSConn) Messages() iter.Seq2[Message, error] {
+	return func(yield func(Message, error) bool) {
+		for {
+			opcode, msg, err := c.readMessage()
+
+			if errors.Is(err, io.EOF) {
+				return
+			}
+
+			if err != nil {
+				yield(Message{}, err)
+				return
+			}
+
+			if !yield(Message{Opcode: opcode, Data: msg}, nil) {
+				c.SendCloseFrame(1000, "")
+				c.closeTCP()
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package crocsoc
+
+import (
+	"net"
+	"testing"
+)
+
+func TestMessagesRange(t *testing.T) {
+	serverConn, clientConn := net.Pipe()
+	defer clientConn.Close()
+	client := &WSConn{Conn: clientConn, Role: RoleClient}
+
+	server := &WSConn{Conn: serverConn}
+
+	go func() {
+		client.SendTextFrame([]byte("one"))
+		client.SendBinaryFrame([]byte("two"))
+		client.SendCloseFrame(1000, "")
+		readFrame(clientConn)
+	}()
+
+	var got []Message
+	for msg, err := range server.Messages() {
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		got = append(got, msg)
+	}
+
+	if len(got) != 2 || string(got[0].Data) != "one" || got[1].Opcode != 0x2 {
+		t.Errorf("unexpected messages: %+v", got)
+	}
+}
+
+func TestMessagesBreakCloses(t *testing.T) {
+	serverConn, clientConn := net.Pipe()
+	defer clientConn.Close()
+	client := &WSConn{Conn: clientConn, Role: RoleClient}
+
+	server := &WSConn{Conn: serverConn}
+
+	closed := make(chan *Frame)
+	go func() {
+		client.SendTextFrame([]byte("one"))
+
+		frame, _ := readFrame(clientConn)
+		closed <- frame
+	}()
+
+	for range server.Messages() {
+		break
+	}
+
+	frame := <-closed
+	if frame == nil || frame.Opcode != 0x8 {
+		t.Fatalf("want: close frame, got: %+v", frame)
+	}
+
+	if !server.IsClosed {
+		t.Errorf("connection not closed after break")
+	}
+}
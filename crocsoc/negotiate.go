@@ -0,0 +1,106 @@
+package crocsoc
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+)
+
+/*
+Application protocol negotiation, run once right after the WebSocket
+opens. Each side sends a Hello as its first message, the server first, and
+both then settle on the same Agreement independently:
+
+	server -> {"versions":[1,2,3],"capabilities":["snapshots","gzip"]}
+	client -> {"versions":[2],"capabilities":["gzip"]}
+
+	agreement: version 2, capabilities [gzip]
+
+The server speaking first means neither side writes while the other is
+still writing, and lets old clients see what the server supports before
+they give up.
+*/
+
+// Returned by Negotiate when the two sides share no version.
+var ErrVersionMismatch = errors.New("no common protocol version")
+
+// One side's opening message.
+type Hello struct {
+	// application protocol versions spoken, in any order
+	Versions     []int    `json:"versions"`
+	Capabilities []string `json:"capabilities,omitempty"`
+}
+
+// What both sides agreed to speak.
+type Agreement struct {
+	// highest version both sides speak
+	Version int
+	// capabilities both sides announced, in local order
+	Capabilities []string
+	// the peer's Hello as received
+	Peer Hello
+}
+
+// Whether both sides announced the capability.
+func (a Agreement) Has(capability string) bool {
+	return slices.Contains(a.Capabilities, capability)
+}
+
+// What Negotiate does when the sides share no version.
+type MismatchPolicy int
+
+const (
+	// send a 1008 close frame, reads then complete the close handshake
+	MismatchClose MismatchPolicy = iota
+	// leave the connection open, e.g. to send the client an upgrade notice
+	MismatchKeepOpen
+)
+
+// Exchanges Hellos with the peer and returns the agreed version and
+// capabilities. On a version mismatch the error wraps ErrVersionMismatch
+// and the Agreement still holds the peer's Hello.
+func Negotiate(conn *WSConn, local Hello, policy MismatchPolicy) (Agreement, error) {
+	hellos := Typed[Hello](conn, JSONCodec)
+
+	var peer Hello
+	var err error
+
+	if conn.Role == RoleServer {
+		if err = hellos.Send(local); err == nil {
+			peer, err = hellos.Receive()
+		}
+	} else {
+		if peer, err = hellos.Receive(); err == nil {
+			err = hellos.Send(local)
+		}
+	}
+
+	if err != nil {
+		return Agreement{}, fmt.Errorf("negotiation failed: %v", err)
+	}
+
+	agreed := Agreement{Peer: peer, Version: -1}
+
+	for _, v := range local.Versions {
+		if v > agreed.Version && slices.Contains(peer.Versions, v) {
+			agreed.Version = v
+		}
+	}
+
+	if agreed.Version < 0 {
+		conn.logger().Warn("protocol version mismatch", "local", local.Versions, "peer", peer.Versions)
+
+		if policy == MismatchClose {
+			conn.SendCloseFrame(1008, "unsupported protocol version")
+		}
+		return Agreement{Peer: peer}, fmt.Errorf("%w: local %v, peer %v", ErrVersionMismatch, local.Versions, peer.Versions)
+	}
+
+	for _, c := range local.Capabilities {
+		if slices.Contains(peer.Capabilities, c) {
+			agreed.Capabilities = append(agreed.Capabilities, c)
+		}
+	}
+
+	return agreed, nil
+}
@@ -0,0 +1,100 @@
+package crocsoc
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"slices"
+	"testing"
+)
+
+func negotiatePair(t *testing.T, server, client Hello, policy MismatchPolicy) (Agreement, error, Agreement, error) {
+	t.Helper()
+
+	serverConn, clientConn := net.Pipe()
+	t.Cleanup(func() {
+		serverConn.Close()
+		clientConn.Close()
+	})
+
+	type result struct {
+		a   Agreement
+		err error
+	}
+	got := make(chan result)
+
+	go func() {
+		a, err := Negotiate(&WSConn{Conn: clientConn, Role: RoleClient}, client, policy)
+		got <- result{a, err}
+	}()
+
+	sa, serr := Negotiate(&WSConn{Conn: serverConn}, server, policy)
+	c := <-got
+
+	return sa, serr, c.a, c.err
+}
+
+func TestNegotiate(t *testing.T) {
+	server := Hello{Versions: []int{1, 2, 3}, Capabilities: []string{"snapshots", "gzip"}}
+	client := Hello{Versions: []int{2, 1}, Capabilities: []string{"gzip", "msgpack"}}
+
+	sa, serr, ca, cerr := negotiatePair(t, server, client, MismatchClose)
+	if serr != nil || cerr != nil {
+		t.Fatalf("%v %v", serr, cerr)
+	}
+
+	for _, a := range []Agreement{sa, ca} {
+		if a.Version != 2 {
+			t.Errorf("want: 2, got: %d", a.Version)
+		}
+		if !slices.Equal(a.Capabilities, []string{"gzip"}) {
+			t.Errorf("want: [gzip], got: %v", a.Capabilities)
+		}
+	}
+
+	if !sa.Has("gzip") || sa.Has("snapshots") {
+		t.Errorf("unexpected capabilities: %v", sa.Capabilities)
+	}
+	if !slices.Equal(sa.Peer.Versions, client.Versions) {
+		t.Errorf("want: %v, got: %v", client.Versions, sa.Peer.Versions)
+	}
+}
+
+func TestNegotiateMismatch(t *testing.T) {
+	serverConn, clientConn := net.Pipe()
+	defer serverConn.Close()
+	defer clientConn.Close()
+
+	client := &WSConn{Conn: clientConn, Role: RoleClient}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		hellos := Typed[Hello](client, JSONCodec)
+		if _, err := hellos.Receive(); err != nil {
+			t.Errorf("%v", err)
+			return
+		}
+		hellos.Send(Hello{Versions: []int{4}})
+
+		frame, err := readFrame(clientConn)
+		if err != nil || frame.Opcode != 0x8 {
+			t.Errorf("want close frame, got: %v %v", frame, err)
+			return
+		}
+		if code := binary.BigEndian.Uint16(frame.Payload); code != 1008 {
+			t.Errorf("want: 1008, got: %d", code)
+		}
+	}()
+
+	a, err := Negotiate(&WSConn{Conn: serverConn}, Hello{Versions: []int{1, 2}}, MismatchClose)
+	if !errors.Is(err, ErrVersionMismatch) {
+		t.Errorf("want: ErrVersionMismatch, got: %v", err)
+	}
+	if !slices.Equal(a.Peer.Versions, []int{4}) {
+		t.Errorf("peer hello not kept: %+v", a.Peer)
+	}
+
+	<-done
+}
@@ -0,0 +1,108 @@
+package crocsoc
+
+import (
+	"errors"
+	"sync"
+)
+
+// Returned by OrderedExecutor.Submit after Close.
+var ErrExecutorClosed = errors.New("executor closed")
+
+// Runs tasks one at a time and in submission order per key, e.g. a
+// connection or user ID, while tasks of different keys run in parallel on a
+// bounded number of goroutines. Keys take turns, so one busy key can't starve
+// the others.
+//
+//	exec := crocsoc.NewOrderedExecutor[uint64](8)
+//	for msg, err := range conn.Messages() {
+//		...
+//		exec.Submit(conn.ID, func() { process(msg) })
+//	}
+type OrderedExecutor[K comparable] struct {
+	mu   sync.Mutex
+	cond *sync.Cond
+
+	// pending tasks per key, the first one running or about to. A key is
+	// present while it has a task queued or running.
+	queues map[K][]func()
+	// keys with a task waiting for a worker
+	ready  []K
+	closed bool
+
+	wg sync.WaitGroup
+}
+
+// Starts an executor running tasks on up to workers goroutines.
+func NewOrderedExecutor[K comparable](workers int) *OrderedExecutor[K] {
+	e := &OrderedExecutor[K]{queues: map[K][]func(){}}
+	e.cond = sync.NewCond(&e.mu)
+
+	for range max(workers, 1) {
+		e.wg.Add(1)
+		go e.work()
+	}
+
+	return e
+}
+
+// Queues task to run after every task submitted earlier with the same key.
+func (e *OrderedExecutor[K]) Submit(key K, task func()) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.closed {
+		return ErrExecutorClosed
+	}
+
+	q, scheduled := e.queues[key]
+	e.queues[key] = append(q, task)
+
+	if !scheduled {
+		e.ready = append(e.ready, key)
+		e.cond.Signal()
+	}
+	return nil
+}
+
+// Stops accepting tasks and waits for the queued ones to finish.
+func (e *OrderedExecutor[K]) Close() {
+	e.mu.Lock()
+	e.closed = true
+	e.cond.Broadcast()
+	e.mu.Unlock()
+
+	e.wg.Wait()
+}
+
+func (e *OrderedExecutor[K]) work() {
+	defer e.wg.Done()
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for {
+		for len(e.ready) == 0 && !e.closed {
+			e.cond.Wait()
+		}
+		if len(e.ready) == 0 {
+			return
+		}
+
+		key := e.ready[0]
+		e.ready = e.ready[1:]
+		task := e.queues[key][0]
+
+		e.mu.Unlock()
+		task()
+		e.mu.Lock()
+
+		// one task per turn, then to the back of the line
+		if q := e.queues[key][1:]; len(q) > 0 {
+			e.queues[key] = q
+			e.ready = append(e.ready, key)
+			e.cond.Signal()
+		} else {
+			delete(e.queues, key)
+		}
+	}
+}
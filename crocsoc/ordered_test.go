@@ -0,0 +1,68 @@
+package crocsoc
+
+import (
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestOrderedExecutor(t *testing.T) {
+	exec := NewOrderedExecutor[string](4)
+
+	var mu sync.Mutex
+	got := map[string][]int{}
+
+	for i := range 100 {
+		for _, key := range []string{"a", "b", "c"} {
+			exec.Submit(key, func() {
+				mu.Lock()
+				got[key] = append(got[key], i)
+				mu.Unlock()
+			})
+		}
+	}
+	exec.Close()
+
+	for _, key := range []string{"a", "b", "c"} {
+		if len(got[key]) != 100 || !slices.IsSorted(got[key]) {
+			t.Errorf("%s: tasks out of order or lost: %v", key, got[key])
+		}
+	}
+
+	if err := exec.Submit("a", func() {}); err != ErrExecutorClosed {
+		t.Errorf("want: ErrExecutorClosed, got: %v", err)
+	}
+}
+
+func TestOrderedExecutorParallel(t *testing.T) {
+	exec := NewOrderedExecutor[int](2)
+
+	block := make(chan struct{})
+	var second atomic.Bool
+
+	// key 1 is stuck, key 2 must still make progress
+	exec.Submit(1, func() { <-block })
+	exec.Submit(1, func() { second.Store(true) })
+
+	done := make(chan struct{})
+	exec.Submit(2, func() { close(done) })
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("key blocked by another key")
+	}
+
+	if second.Load() {
+		t.Errorf("task ran before the one ahead of it")
+	}
+
+	close(block)
+	exec.Close()
+
+	if !second.Load() {
+		t.Errorf("queued task not run by Close")
+	}
+}
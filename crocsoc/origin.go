@@ -0,0 +1,73 @@
+package crocsoc
+
+import (
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+)
+
+/*
+Browsers attach cookies to WebSocket upgrades from any page, so without an
+Origin check a malicious site can open a socket with the victim's session
+(cross-site WebSocket hijacking). The Origin header can't be set by page
+scripts, which makes it the server's only hint of which site a browser
+connection comes from. Clients that aren't browsers usually send no
+Origin and aren't affected.
+
+By default only origins on the request's own host are allowed, as a page
+served by the same host would have.
+*/
+
+// Which origins may open connections. Origins are allowed when any of
+// the rules matches.
+type OriginPolicy struct {
+	// origins allowed as given, e.g. "https://example.com", or as path.Match
+	// patterns, e.g. "https://*.example.com". "*" allows every origin.
+	Allow []string
+	// allow origins whose host, port included, is the request's Host
+	SameHost bool
+	// called when no other rule matched
+	Check func(r *http.Request) bool
+
+	// reject requests without an Origin header, which are otherwise
+	// allowed
+	RequireOrigin bool
+}
+
+// set by SetOriginPolicy
+var originPolicy = OriginPolicy{SameHost: true}
+
+// Replaces the policy used by WsHandler and any Upgrader without one of its
+// own, same host only by default. Must be called before any connections are
+// served.
+func SetOriginPolicy(p OriginPolicy) {
+	originPolicy = p
+}
+
+// Whether the policy allows r's Origin.
+func (p *OriginPolicy) Allowed(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return !p.RequireOrigin
+	}
+
+	// schemes and hosts are case insensitive
+	lower := strings.ToLower(origin)
+	for _, pattern := range p.Allow {
+		if pattern == "*" {
+			return true
+		}
+		if ok, _ := path.Match(strings.ToLower(pattern), lower); ok {
+			return true
+		}
+	}
+
+	if p.SameHost {
+		if u, err := url.Parse(origin); err == nil && strings.EqualFold(u.Host, r.Host) {
+			return true
+		}
+	}
+
+	return p.Check != nil && p.Check(r)
+}
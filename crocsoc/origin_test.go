@@ -0,0 +1,66 @@
+package crocsoc
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestOriginPolicy(t *testing.T) {
+	tests := []struct {
+		policy OriginPolicy
+		origin string
+		want   bool
+	}{
+		{OriginPolicy{SameHost: true}, "https://example.com", true},
+		{OriginPolicy{SameHost: true}, "https://EXAMPLE.com", true},
+		{OriginPolicy{SameHost: true}, "https://evil.com", false},
+		{OriginPolicy{SameHost: true}, "https://example.com:8443", false},
+		{OriginPolicy{SameHost: true}, "", true},
+		{OriginPolicy{SameHost: true, RequireOrigin: true}, "", false},
+		{OriginPolicy{Allow: []string{"https://app.test"}}, "https://app.test", true},
+		{OriginPolicy{Allow: []string{"https://app.test"}}, "http://app.test", false},
+		{OriginPolicy{Allow: []string{"https://*.app.test"}}, "https://eu.app.test", true},
+		{OriginPolicy{Allow: []string{"https://*.app.test"}}, "https://app.test", false},
+		{OriginPolicy{Allow: []string{"https://*.app.test"}}, "https://evil.test/.app.test", false},
+		{OriginPolicy{Allow: []string{"*"}}, "null", true},
+		{OriginPolicy{Check: func(r *http.Request) bool { return r.Header.Get("Origin") == "https://partner.test" }}, "https://partner.test", true},
+		{OriginPolicy{}, "https://evil.com", false},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "http://example.com/", nil)
+		if tt.origin != "" {
+			r.Header.Set("Origin", tt.origin)
+		}
+
+		if got := tt.policy.Allowed(r); got != tt.want {
+			t.Errorf("%+v %q: want: %v, got: %v", tt.policy, tt.origin, tt.want, got)
+		}
+	}
+}
+
+func TestUpgraderOrigin(t *testing.T) {
+	var upgrader Upgrader
+
+	// a cross-site page under the default policy
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "http://example.com/", nil)
+	r.Header.Set("Origin", "https://evil.com")
+
+	if _, err := upgrader.Upgrade(w, r, nil); err == nil {
+		t.Errorf("cross-site upgrade accepted")
+	}
+	if w.Code != http.StatusForbidden {
+		t.Errorf("want: %d, got: %d", http.StatusForbidden, w.Code)
+	}
+
+	// allowed by the upgrader's own policy, then failing on the missing
+	// upgrade headers instead
+	upgrader.OriginPolicy = &OriginPolicy{Allow: []string{"https://evil.com"}}
+	w = httptest.NewRecorder()
+	upgrader.Upgrade(w, r, nil)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("want: %d, got: %d", http.StatusBadRequest, w.Code)
+	}
+}
@@ -0,0 +1,41 @@
+package crocsoc
+
+import "sync/atomic"
+
+/*
+A handshake storm (every client of a restarted server reconnecting at once)
+would otherwise pile up upgrade goroutines, each holding request buffers,
+until memory runs out. Past the limit WsHandler answers 503 straight away,
+which is cheap and tells well behaved clients to back off.
+*/
+
+// set by SetMaxPendingHandshakes, 0 for no limit
+var maxPendingHandshakes atomic.Int64
+
+// upgrades between entering WsHandler and handing the connection off
+var pendingHandshakes atomic.Int64
+
+// Sets how many upgrades WsHandler processes at once, answering any beyond
+// that with 503 Service Unavailable. No limit when n is 0. Safe to change
+// while serving.
+func SetMaxPendingHandshakes(n int) {
+	maxPendingHandshakes.Store(int64(n))
+}
+
+// Returns the number of upgrades WsHandler is processing, for metrics.
+func PendingHandshakes() int {
+	return int(pendingHandshakes.Load())
+}
+
+// reserves a handshake slot, reporting false when none is free; must be
+// paired with endHandshake either way
+func beginHandshake() bool {
+	n := pendingHandshakes.Add(1)
+
+	limit := maxPendingHandshakes.Load()
+	return limit <= 0 || n <= limit
+}
+
+func endHandshake() {
+	pendingHandshakes.Add(-1)
+}
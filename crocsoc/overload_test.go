@@ -0,0 +1,42 @@
+package crocsoc
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMaxPendingHandshakes(t *testing.T) {
+	SetMaxPendingHandshakes(1)
+	defer SetMaxPendingHandshakes(0)
+
+	// an upgrade already in flight
+	beginHandshake()
+
+	upgrade := func() int {
+		r := buildRequest(map[string]string{
+			"Upgrade":               "websocket",
+			"Connection":            "Upgrade",
+			"Sec-WebSocket-Key":     GenerateKey(),
+			"Sec-WebSocket-Version": "13",
+		})
+		w := httptest.NewRecorder()
+		WsHandler(w, r)
+		return w.Code
+	}
+
+	if code := upgrade(); code != http.StatusServiceUnavailable {
+		t.Errorf("want: %d, got: %d", http.StatusServiceUnavailable, code)
+	}
+
+	endHandshake()
+
+	// gets past the limit, then fails to hijack the recorder
+	if code := upgrade(); code == http.StatusServiceUnavailable {
+		t.Errorf("upgrade rejected with a free slot")
+	}
+
+	if n := PendingHandshakes(); n != 0 {
+		t.Errorf("want: 0, got: %d", n)
+	}
+}
@@ -0,0 +1,52 @@
+package crocsoc
+
+import (
+	"fmt"
+	"runtime/debug"
+)
+
+// Reports a panic recovered from user code crocsoc runs, such as a frame
+// interceptor, with the connection it ran for.
+type PanicHandler func(conn *WSConn, v any, stack []byte)
+
+// logs unless SetPanicHandler is called
+var panicHandler PanicHandler = logPanic
+
+// Installs the handler for panics recovered from user code, e.g. to report
+// them to an error tracker. nil restores the default of logging them. Must be
+// called before any connections are served.
+func SetPanicHandler(h PanicHandler) {
+	if h == nil {
+		h = logPanic
+	}
+	panicHandler = h
+}
+
+func logPanic(conn *WSConn, v any, stack []byte) {
+	conn.logger().Error("recovered panic", "panic", v, "stack", string(stack))
+}
+
+// Returned by the read or write during which user code panicked. The
+// connection has been failed with a 1011 close frame by then.
+type PanicError struct {
+	Value any
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("panic: %v", e.Value)
+}
+
+// recovers a panic in the calling function, turning it into a PanicError in
+// *err after reporting it to the panic handler
+func (c *WSConn) recoverPanic(err *error) {
+	v := recover()
+	if v == nil {
+		return
+	}
+
+	stack := debug.Stack()
+	panicHandler(c, v, stack)
+
+	*err = &PanicError{Value: v, Stack: stack}
+}
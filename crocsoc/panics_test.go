@@ -0,0 +1,61 @@
+package crocsoc
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"testing"
+)
+
+func TestInterceptorPanic(t *testing.T) {
+	var recovered []any
+	SetPanicHandler(func(conn *WSConn, v any, stack []byte) {
+		recovered = append(recovered, v)
+	})
+	defer SetPanicHandler(nil)
+
+	for _, inbound := range []bool{true, false} {
+		serverConn, clientConn := net.Pipe()
+		client := &WSConn{Conn: clientConn, Role: RoleClient}
+
+		server := &WSConn{Conn: serverConn}
+		boom := func(f *Frame) (*Frame, error) { panic("boom") }
+
+		peer := make(chan uint16)
+		go func() {
+			if inbound {
+				client.SendTextFrame([]byte("hello"))
+			}
+
+			var code uint16
+			if frame, err := readFrame(clientConn); err == nil && frame.Opcode == 0x8 {
+				code = binary.BigEndian.Uint16(frame.Payload)
+			}
+			peer <- code
+		}()
+
+		var err error
+		if inbound {
+			server.InterceptInbound(boom)
+			_, err = server.ReadMessage()
+		} else {
+			server.InterceptOutbound(boom)
+			err = server.SendTextFrame([]byte("hello"))
+		}
+
+		var perr *PanicError
+		if !errors.As(err, &perr) || perr.Value != "boom" || len(perr.Stack) == 0 {
+			t.Errorf("inbound=%v: want: PanicError, got: %v", inbound, err)
+		}
+
+		if code := <-peer; code != 1011 {
+			t.Errorf("inbound=%v: want: 1011, got: %d", inbound, code)
+		}
+
+		clientConn.Close()
+	}
+
+	if len(recovered) != 2 {
+		t.Errorf("want: 2 panics reported, got: %v", recovered)
+	}
+}
@@ -0,0 +1,217 @@
+// Package phoenix speaks the Phoenix Channels wire protocol over a crocsoc
+// connection, so phoenix.js clients can join topics on a Go server
+// unmodified. Only the default JSON serializer (vsn=2.0.0) is supported:
+//
+//	new Socket("/socket", { params: { token } })
+//
+// Joins, leaves and heartbeats are answered by Next, which returns the
+// pushes clients make on joined topics.
+//
+//	sock := phoenix.Accept(conn, phoenix.Options{})
+//	for {
+//		msg, err := sock.Next()
+//		...
+//		sock.Reply(msg, "ok", map[string]string{"body": "hello"})
+//	}
+package phoenix
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/pgxtips/crocsoc/crocsoc"
+)
+
+/*
+Every message is a JSON array:
+
+	[join_ref, ref, topic, event, payload]
+
+refs are strings chosen by the client, or null on server pushes not
+answering anything. e.g.
+
+	client -> ["1","1","room:lobby","phx_join",{}]
+	server -> ["1","1","room:lobby","phx_reply",{"status":"ok","response":{}}]
+	client -> ["1","2","room:lobby","new_msg",{"body":"hi"}]
+	server -> ["1",null,"room:lobby","new_msg",{"body":"hi"}]
+	client -> [null,"3","phoenix","heartbeat",{}]
+	server -> [null,"3","phoenix","phx_reply",{"status":"ok","response":{}}]
+*/
+
+// Returned by Next for binary pushes, which use a separate serializer.
+var ErrBinaryUnsupported = errors.New("phoenix: binary messages are not supported")
+
+type Options struct {
+	// called for each phx_join with the join payload, an error is sent
+	// back as an error reply with the error as reason. All joins are
+	// accepted when nil.
+	Authorize func(topic string, payload json.RawMessage) error
+}
+
+// A push from the client on a joined topic.
+type Message struct {
+	Topic   string
+	Event   string
+	Payload json.RawMessage
+
+	// empty when the client pushed without a ref
+	Ref     string
+	JoinRef string
+}
+
+// A Phoenix client connection.
+type Socket struct {
+	Conn *crocsoc.WSConn
+
+	opts Options
+
+	mu sync.Mutex
+	// join ref per joined topic
+	joined map[string]string
+}
+
+func Accept(conn *crocsoc.WSConn, opts Options) *Socket {
+	return &Socket{Conn: conn, opts: opts, joined: map[string]string{}}
+}
+
+// Reads until the client pushes on a joined topic, answering joins, leaves
+// and heartbeats on the way. Returns io.EOF once the connection has closed.
+func (s *Socket) Next() (Message, error) {
+	for {
+		msg, err := s.Conn.ReadMessage()
+		if err != nil {
+			return Message{}, err
+		}
+
+		// text frames hold JSON, binary ones the binary serializer
+		if len(msg) == 0 || msg[0] != '[' {
+			return Message{}, ErrBinaryUnsupported
+		}
+
+		m, err := parseMessage(msg)
+		if err != nil {
+			return Message{}, err
+		}
+
+		switch {
+		case m.Topic == "phoenix" && m.Event == "heartbeat":
+			err = s.Reply(m, "ok", struct{}{})
+		case m.Event == "phx_join":
+			err = s.join(m)
+		case m.Event == "phx_leave":
+			s.mu.Lock()
+			delete(s.joined, m.Topic)
+			s.mu.Unlock()
+			err = s.Reply(m, "ok", struct{}{})
+		case !s.Joined(m.Topic):
+			err = s.Reply(m, "error", map[string]string{"reason": "unmatched topic"})
+		default:
+			return m, nil
+		}
+
+		if err != nil {
+			return Message{}, err
+		}
+	}
+}
+
+// Reports whether the client has joined topic.
+func (s *Socket) Joined(topic string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, ok := s.joined[topic]
+	return ok
+}
+
+// Answers msg with status ("ok" or "error") and response, resolving the
+// client's push.receive(status, ...) callback.
+func (s *Socket) Reply(msg Message, status string, response any) error {
+	return s.send(msg.JoinRef, msg.Ref, msg.Topic, "phx_reply", map[string]any{
+		"status":   status,
+		"response": response,
+	})
+}
+
+// Pushes event with payload to the client on a joined topic, received by
+// channel.on(event, ...).
+func (s *Socket) Push(topic, event string, payload any) error {
+	s.mu.Lock()
+	joinRef, ok := s.joined[topic]
+	s.mu.Unlock()
+
+	if !ok {
+		return fmt.Errorf("phoenix: topic %q not joined", topic)
+	}
+	return s.send(joinRef, "", topic, event, payload)
+}
+
+// Tells the client every joined channel closed and starts the close
+// handshake. Keep calling Next to complete it.
+func (s *Socket) Close() error {
+	s.mu.Lock()
+	joined := s.joined
+	s.joined = map[string]string{}
+	s.mu.Unlock()
+
+	for topic, joinRef := range joined {
+		s.send(joinRef, joinRef, topic, "phx_close", struct{}{})
+	}
+	return s.Conn.SendCloseFrame(1000, "")
+}
+
+func (s *Socket) join(m Message) error {
+	if s.opts.Authorize != nil {
+		if err := s.opts.Authorize(m.Topic, m.Payload); err != nil {
+			return s.Reply(m, "error", map[string]string{"reason": err.Error()})
+		}
+	}
+
+	s.mu.Lock()
+	s.joined[m.Topic] = m.JoinRef
+	s.mu.Unlock()
+
+	return s.Reply(m, "ok", struct{}{})
+}
+
+func (s *Socket) send(joinRef, ref, topic, event string, payload any) error {
+	b, err := json.Marshal([]any{nullable(joinRef), nullable(ref), topic, event, payload})
+	if err != nil {
+		return fmt.Errorf("failed to encode message: %v", err)
+	}
+	return s.Conn.SendTextFrame(b)
+}
+
+func parseMessage(raw []byte) (Message, error) {
+	var arr []json.RawMessage
+	if err := json.Unmarshal(raw, &arr); err != nil || len(arr) != 5 {
+		return Message{}, fmt.Errorf("phoenix: malformed message %q", raw)
+	}
+
+	var joinRef, ref *string
+	m := Message{Payload: arr[4]}
+	for i, dst := range []any{&joinRef, &ref, &m.Topic, &m.Event} {
+		if err := json.Unmarshal(arr[i], dst); err != nil {
+			return Message{}, fmt.Errorf("phoenix: malformed message %q", raw)
+		}
+	}
+
+	if joinRef != nil {
+		m.JoinRef = *joinRef
+	}
+	if ref != nil {
+		m.Ref = *ref
+	}
+
+	return m, nil
+}
+
+// empty refs go out as null
+func nullable(ref string) any {
+	if ref == "" {
+		return nil
+	}
+	return ref
+}
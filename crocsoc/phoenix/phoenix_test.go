@@ -0,0 +1,98 @@
+package phoenix
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/pgxtips/crocsoc/crocsoc"
+)
+
+func readText(t *testing.T, c *crocsoc.WSConn) string {
+	t.Helper()
+
+	msg, err := c.ReadMessage()
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	return string(msg)
+}
+
+func TestSocket(t *testing.T) {
+	serverConn, clientConn := net.Pipe()
+	defer serverConn.Close()
+	defer clientConn.Close()
+
+	sock := Accept(&crocsoc.WSConn{Conn: serverConn}, Options{
+		Authorize: func(topic string, payload json.RawMessage) error {
+			if topic == "room:secret" {
+				return errors.New("unauthorized")
+			}
+			return nil
+		},
+	})
+
+	msgs := make(chan Message)
+	go func() {
+		for {
+			m, err := sock.Next()
+			if err != nil {
+				close(msgs)
+				return
+			}
+			msgs <- m
+		}
+	}()
+
+	client := &crocsoc.WSConn{Conn: clientConn, Role: crocsoc.RoleClient}
+
+	exchanges := []struct{ send, want string }{
+		{`[null,"1","phoenix","heartbeat",{}]`, `[null,"1","phoenix","phx_reply",{"response":{},"status":"ok"}]`},
+		{`["2","2","room:lobby","phx_join",{}]`, `["2","2","room:lobby","phx_reply",{"response":{},"status":"ok"}]`},
+		{`["3","3","room:secret","phx_join",{}]`, `["3","3","room:secret","phx_reply",{"response":{"reason":"unauthorized"},"status":"error"}]`},
+		{`["3","4","room:secret","new_msg",{}]`, `["3","4","room:secret","phx_reply",{"response":{"reason":"unmatched topic"},"status":"error"}]`},
+	}
+	for _, ex := range exchanges {
+		client.SendTextFrame([]byte(ex.send))
+		if got := readText(t, client); got != ex.want {
+			t.Errorf("want: %s, got: %s", ex.want, got)
+		}
+	}
+
+	client.SendTextFrame([]byte(`["2","5","room:lobby","new_msg",{"body":"hi"}]`))
+	m := <-msgs
+	if m.Topic != "room:lobby" || m.Event != "new_msg" || m.Ref != "5" || m.JoinRef != "2" || string(m.Payload) != `{"body":"hi"}` {
+		t.Errorf("unexpected message: %+v", m)
+	}
+
+	go sock.Reply(m, "ok", nil)
+	if got, want := readText(t, client), `["2","5","room:lobby","phx_reply",{"response":null,"status":"ok"}]`; got != want {
+		t.Errorf("want: %s, got: %s", want, got)
+	}
+
+	go sock.Push("room:lobby", "new_msg", map[string]string{"body": "hello"})
+	if got, want := readText(t, client), `["2",null,"room:lobby","new_msg",{"body":"hello"}]`; got != want {
+		t.Errorf("want: %s, got: %s", want, got)
+	}
+
+	if err := sock.Push("room:secret", "new_msg", nil); err == nil {
+		t.Errorf("push to unjoined topic accepted")
+	}
+
+	client.SendTextFrame([]byte(`["2","6","room:lobby","phx_leave",{}]`))
+	if got, want := readText(t, client), `["2","6","room:lobby","phx_reply",{"response":{},"status":"ok"}]`; got != want {
+		t.Errorf("want: %s, got: %s", want, got)
+	}
+	if sock.Joined("room:lobby") {
+		t.Errorf("topic still joined after leave")
+	}
+}
+
+func TestParseMessage(t *testing.T) {
+	for _, bad := range []string{`[]`, `{"topic":"a"}`, `[null,null,1,"e",{}]`, `[null,null,"t","e"]`} {
+		if _, err := parseMessage([]byte(bad)); err == nil {
+			t.Errorf("%s accepted", bad)
+		}
+	}
+}
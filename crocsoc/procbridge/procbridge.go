@@ -0,0 +1,359 @@
+// Package procbridge connects WebSocket clients to the stdin and stdout of
+// a subprocess, for web terminals and REPL style services. Messages from
+// the client are written to stdin, and stdout and stderr are streamed back
+// as messages.
+//
+//	procbridge.Run(conn, exec.Command("python3", "-i"), procbridge.Options{
+//		Timeout: 10 * time.Minute,
+//	})
+//
+// A single process can also be shared by many connections, see NewShared,
+// and on Linux RunPTY runs the process on a pseudo-terminal.
+package procbridge
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/pgxtips/crocsoc/crocsoc"
+)
+
+// Returned when the process wrote more than Options.MaxOutput bytes.
+var ErrOutputLimit = errors.New("procbridge: output limit exceeded")
+
+type Options struct {
+	// kill the process after this long, no limit when 0
+	Timeout time.Duration
+	// stop streaming and kill the process past this many output bytes, no
+	// limit when 0
+	MaxOutput int64
+	// largest client message accepted, see crocsoc.WSConn.SetReadLimit;
+	// 64KB by default
+	MaxMessageSize int64
+
+	// send output as text messages rather than binary, replacing invalid
+	// UTF-8 and never splitting a rune across messages
+	TextOutput bool
+
+	// how long the process may keep running after its client leaves and
+	// stdin is closed, before it is killed; 5s by default
+	GracePeriod time.Duration
+}
+
+func (o *Options) defaults() {
+	if o.MaxMessageSize <= 0 {
+		o.MaxMessageSize = 64 << 10
+	}
+	if o.GracePeriod <= 0 {
+		o.GracePeriod = 5 * time.Second
+	}
+}
+
+// Starts cmd and bridges it to conn until the process exits, then closes
+// conn with the exit status as the reason. cmd's Stdin, Stdout and Stderr
+// must be unset. Returns cmd.Wait's error.
+func Run(conn *crocsoc.WSConn, cmd *exec.Cmd, opts Options) error {
+	opts.defaults()
+
+	stdin, output, err := start(cmd)
+	if err != nil {
+		conn.SendCloseFrame(1011, "failed to start process")
+		return err
+	}
+
+	return relay(conn, cmd, opts, output, func() {
+		copyInput(conn, stdin)
+		stdin.Close()
+	})
+}
+
+// streams output to conn while input feeds the process, until the process
+// exits and output is drained
+func relay(conn *crocsoc.WSConn, cmd *exec.Cmd, opts Options, output io.ReadCloser, input func()) error {
+	conn.SetReadLimit(opts.MaxMessageSize)
+
+	if opts.Timeout > 0 {
+		t := time.AfterFunc(opts.Timeout, func() { cmd.Process.Kill() })
+		defer t.Stop()
+	}
+
+	inputDone := make(chan struct{})
+	go func() {
+		defer close(inputDone)
+
+		input()
+
+		// the client is gone, give the process a moment to notice
+		time.AfterFunc(opts.GracePeriod, func() { cmd.Process.Kill() })
+	}()
+
+	perr := pump(output, opts, func(b []byte) error {
+		if opts.TextOutput {
+			return conn.SendTextFrame(b)
+		}
+		return conn.SendBinaryFrame(b)
+	})
+	if perr != nil {
+		cmd.Process.Kill()
+	}
+	output.Close()
+
+	werr := cmd.Wait()
+
+	if errors.Is(perr, ErrOutputLimit) {
+		conn.SendCloseFrame(1008, "output limit exceeded")
+	} else {
+		conn.SendCloseFrame(1000, exitReason(cmd))
+	}
+	<-inputDone
+
+	if errors.Is(perr, ErrOutputLimit) {
+		return perr
+	}
+	return werr
+}
+
+// merges stdout and stderr into one pipe so their writes interleave as the
+// process made them
+func start(cmd *exec.Cmd) (io.WriteCloser, *os.File, error) {
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, nil, err
+	}
+	cmd.Stdout = w
+	cmd.Stderr = w
+
+	if err := cmd.Start(); err != nil {
+		r.Close()
+		w.Close()
+		return nil, nil, err
+	}
+
+	// only the child holds the write end now, so reads see EOF when it exits
+	w.Close()
+
+	return stdin, r, nil
+}
+
+// writes client messages to stdin until the connection ends
+func copyInput(conn *crocsoc.WSConn, stdin io.Writer) {
+	stdinGone := false
+	for {
+		msg, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+
+		// keep reading so the close handshake completes
+		if !stdinGone {
+			if _, err := stdin.Write(msg); err != nil {
+				stdinGone = true
+			}
+		}
+	}
+}
+
+// sends everything read from r through send, in chunks of up to 32KB
+func pump(r io.Reader, opts Options, send func([]byte) error) error {
+	buf := make([]byte, 32<<10)
+
+	// start of a rune split across reads, for text output
+	var partial []byte
+	var total int64
+
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			total += int64(n)
+			if opts.MaxOutput > 0 && total > opts.MaxOutput {
+				return ErrOutputLimit
+			}
+
+			chunk := buf[:n]
+			if opts.TextOutput {
+				chunk, partial = splitRune(append(partial, chunk...))
+				chunk = bytes.ToValidUTF8(chunk, []byte("�"))
+			}
+
+			if len(chunk) > 0 {
+				if serr := send(chunk); serr != nil {
+					return serr
+				}
+			}
+		}
+
+		if err != nil {
+			if len(partial) > 0 {
+				send(bytes.ToValidUTF8(partial, []byte("�")))
+			}
+			return nil
+		}
+	}
+}
+
+// splits an incomplete rune off the end of b
+func splitRune(b []byte) ([]byte, []byte) {
+	for i := len(b) - 1; i >= 0 && i >= len(b)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(b[i]) {
+			if !utf8.FullRune(b[i:]) {
+				// copy, the caller's buffer is reused
+				return b[:i], append([]byte(nil), b[i:]...)
+			}
+			break
+		}
+	}
+	return b, nil
+}
+
+func exitReason(cmd *exec.Cmd) string {
+	if cmd.ProcessState == nil || cmd.ProcessState.Success() {
+		return ""
+	}
+	return cmd.ProcessState.String()
+}
+
+// A process shared by every attached connection: each connection's
+// messages go to its stdin and its output is sent to all of them.
+type Shared struct {
+	cmd  *exec.Cmd
+	opts Options
+
+	// separate from mu so a process not reading stdin cannot hold up its
+	// own output
+	stdinMu sync.Mutex
+	stdin   io.WriteCloser
+
+	mu    sync.Mutex
+	conns map[*crocsoc.WSConn]struct{}
+	ended bool
+
+	done chan struct{}
+	err  error
+}
+
+// Starts cmd for connections to Attach to. GracePeriod does not apply, the
+// process keeps running with no connections attached.
+func NewShared(cmd *exec.Cmd, opts Options) (*Shared, error) {
+	opts.defaults()
+
+	stdin, output, err := start(cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Shared{
+		cmd:   cmd,
+		stdin: stdin,
+		opts:  opts,
+		conns: map[*crocsoc.WSConn]struct{}{},
+		done:  make(chan struct{}),
+	}
+
+	go s.run(output)
+
+	return s, nil
+}
+
+// Streams the process's output to conn and writes conn's messages to its
+// stdin until either ends. A slow connection holds up output to the
+// others, so set a WriteTimeout on conn.
+func (s *Shared) Attach(conn *crocsoc.WSConn) {
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		conn.SendCloseFrame(1000, exitReason(s.cmd))
+		return
+	}
+	s.conns[conn] = struct{}{}
+	s.mu.Unlock()
+
+	conn.SetReadLimit(s.opts.MaxMessageSize)
+
+	// the process takes one write at a time, so input from different
+	// connections is never interleaved mid message
+	copyInput(conn, lockedWriter{&s.stdinMu, s.stdin})
+
+	s.mu.Lock()
+	delete(s.conns, conn)
+	s.mu.Unlock()
+}
+
+// Kills the process.
+func (s *Shared) Close() error {
+	return s.cmd.Process.Kill()
+}
+
+// Blocks until the process has exited, returning cmd.Wait's error.
+func (s *Shared) Wait() error {
+	<-s.done
+	return s.err
+}
+
+func (s *Shared) run(output *os.File) {
+	defer close(s.done)
+
+	if s.opts.Timeout > 0 {
+		t := time.AfterFunc(s.opts.Timeout, func() { s.cmd.Process.Kill() })
+		defer t.Stop()
+	}
+
+	perr := pump(output, s.opts, func(b []byte) error {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		for conn := range s.conns {
+			if s.opts.TextOutput {
+				conn.SendTextFrame(b)
+			} else {
+				conn.SendBinaryFrame(b)
+			}
+		}
+		return nil
+	})
+	if perr != nil {
+		s.cmd.Process.Kill()
+	}
+	output.Close()
+
+	s.err = s.cmd.Wait()
+	if perr != nil {
+		s.err = perr
+	}
+
+	s.stdinMu.Lock()
+	s.stdin.Close()
+	s.stdinMu.Unlock()
+
+	s.mu.Lock()
+	s.ended = true
+	for conn := range s.conns {
+		if errors.Is(perr, ErrOutputLimit) {
+			conn.SendCloseFrame(1008, "output limit exceeded")
+		} else {
+			conn.SendCloseFrame(1000, exitReason(s.cmd))
+		}
+	}
+	s.mu.Unlock()
+}
+
+type lockedWriter struct {
+	mu *sync.Mutex
+	w  io.Writer
+}
+
+func (l lockedWriter) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.w.Write(p)
+}
@@ -0,0 +1,178 @@
+package procbridge
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"os/exec"
+	"testing"
+	"time"
+
+	"github.com/pgxtips/crocsoc/crocsoc"
+)
+
+// runs cmd bridged to the server end of a loopback connection, returning
+// the client end and Run's result
+func bridge(t *testing.T, cmd *exec.Cmd, opts Options) (*crocsoc.WSConn, chan error) {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer ln.Close()
+
+	clientConn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	serverConn, err := ln.Accept()
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	t.Cleanup(func() { clientConn.Close() })
+
+	result := make(chan error, 1)
+	go func() {
+		result <- Run(&crocsoc.WSConn{Conn: serverConn}, cmd, opts)
+	}()
+
+	return &crocsoc.WSConn{Conn: clientConn, Role: crocsoc.RoleClient}, result
+}
+
+// reads messages until the close frame, returning their concatenation
+func readAll(t *testing.T, c *crocsoc.WSConn) string {
+	t.Helper()
+
+	var out bytes.Buffer
+	for {
+		msg, err := c.ReadMessage()
+		if err != nil {
+			if !errors.Is(err, io.EOF) {
+				t.Errorf("%v", err)
+			}
+			return out.String()
+		}
+		out.Write(msg)
+	}
+}
+
+func TestRun(t *testing.T) {
+	client, result := bridge(t, exec.Command("sh", "-c", "read line; echo got $line; echo oops >&2; exit 3"), Options{})
+
+	client.SendTextFrame([]byte("hello\n"))
+
+	if got := readAll(t, client); got != "got hello\noops\n" {
+		t.Errorf("unexpected output: %q", got)
+	}
+
+	var exitErr *exec.ExitError
+	if err := <-result; !errors.As(err, &exitErr) || exitErr.ExitCode() != 3 {
+		t.Errorf("want: exit status 3, got: %v", err)
+	}
+}
+
+func TestRunOutputLimit(t *testing.T) {
+	client, result := bridge(t, exec.Command("yes"), Options{MaxOutput: 1 << 20})
+
+	go readAll(t, client)
+
+	select {
+	case err := <-result:
+		if !errors.Is(err, ErrOutputLimit) {
+			t.Errorf("want: ErrOutputLimit, got: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("process not stopped at the output limit")
+	}
+}
+
+func TestRunTimeout(t *testing.T) {
+	client, result := bridge(t, exec.Command("sleep", "10"), Options{Timeout: 100 * time.Millisecond})
+
+	go readAll(t, client)
+
+	select {
+	case err := <-result:
+		if err == nil {
+			t.Errorf("killed process reported success")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("process not killed at the timeout")
+	}
+}
+
+func TestSplitRune(t *testing.T) {
+	euro := []byte("€") // 3 bytes
+
+	tests := []struct {
+		in, complete, rest []byte
+	}{
+		{[]byte("abc"), []byte("abc"), nil},
+		{append([]byte("a"), euro[:2]...), []byte("a"), euro[:2]},
+		{append([]byte("a"), euro...), append([]byte("a"), euro...), nil},
+	}
+
+	for _, tt := range tests {
+		complete, rest := splitRune(tt.in)
+		if !bytes.Equal(complete, tt.complete) || !bytes.Equal(rest, tt.rest) {
+			t.Errorf("want: %q %q, got: %q %q", tt.complete, tt.rest, complete, rest)
+		}
+	}
+}
+
+func TestShared(t *testing.T) {
+	shared, err := NewShared(exec.Command("cat"), Options{})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	var clients []*crocsoc.WSConn
+	for range 2 {
+		serverConn, clientConn := net.Pipe()
+		defer clientConn.Close()
+
+		go func() {
+			shared.Attach(&crocsoc.WSConn{Conn: serverConn})
+		}()
+		clients = append(clients, &crocsoc.WSConn{Conn: clientConn, Role: crocsoc.RoleClient})
+	}
+
+	// wait for both to be registered before producing output
+	for shared.attached() < 2 {
+		time.Sleep(time.Millisecond)
+	}
+
+	go clients[0].SendTextFrame([]byte("ping\n"))
+
+	// output goes to the connections in any order, read them together
+	got := make(chan string, 2)
+	for _, c := range clients {
+		go func() {
+			msg, _ := c.ReadMessage()
+			got <- string(msg)
+		}()
+	}
+	for range clients {
+		if msg := <-got; msg != "ping\n" {
+			t.Errorf("want: ping, got: %q", msg)
+		}
+	}
+
+	// take the close frames
+	for _, c := range clients {
+		go io.Copy(io.Discard, c.Conn)
+	}
+
+	shared.Close()
+	if err := shared.Wait(); err == nil {
+		t.Errorf("killed process reported success")
+	}
+}
+
+func (s *Shared) attached() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.conns)
+}
@@ -0,0 +1,139 @@
+//go:build linux
+
+package procbridge
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"syscall"
+	"unsafe"
+
+	"github.com/pgxtips/crocsoc/crocsoc"
+)
+
+// A JSON text message controlling the terminal in RunPTY, e.g.
+//
+//	{"type":"resize","cols":120,"rows":40}
+type Control struct {
+	Type string `json:"type"`
+	Cols uint16 `json:"cols"`
+	Rows uint16 `json:"rows"`
+}
+
+// Like Run but starts cmd on a new pseudo-terminal, as its session leader
+// with the terminal as controlling tty, for web terminals. Binary messages
+// are typed into the terminal and text messages are Control messages;
+// terminal output is sent as binary messages. The process gets SIGHUP when
+// the client leaves, as it would from a closed terminal.
+func RunPTY(conn *crocsoc.WSConn, cmd *exec.Cmd, opts Options) error {
+	opts.defaults()
+	// terminal output is arbitrary bytes
+	opts.TextOutput = false
+
+	master, tty, err := openPTY()
+	if err != nil {
+		conn.SendCloseFrame(1011, "failed to allocate terminal")
+		return err
+	}
+
+	cmd.Stdin = tty
+	cmd.Stdout = tty
+	cmd.Stderr = tty
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Setsid = true
+	cmd.SysProcAttr.Setctty = true
+	// the child's stdin
+	cmd.SysProcAttr.Ctty = 0
+
+	if err := cmd.Start(); err != nil {
+		master.Close()
+		tty.Close()
+		conn.SendCloseFrame(1011, "failed to start process")
+		return err
+	}
+	tty.Close()
+
+	return relay(conn, cmd, opts, master, func() {
+		for msg, err := range conn.Messages() {
+			if err != nil {
+				break
+			}
+
+			if msg.Opcode == 0x2 {
+				master.Write(msg.Data)
+				continue
+			}
+
+			var ctl Control
+			if err := json.Unmarshal(msg.Data, &ctl); err != nil {
+				continue
+			}
+			if ctl.Type == "resize" {
+				setSize(master, ctl.Rows, ctl.Cols)
+			}
+		}
+
+		cmd.Process.Signal(syscall.SIGHUP)
+	})
+}
+
+// opens a new pseudo-terminal pair through /dev/ptmx
+func openPTY() (*os.File, *os.File, error) {
+	master, err := os.OpenFile("/dev/ptmx", os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open /dev/ptmx: %v", err)
+	}
+
+	var unlock int32
+	if err := ioctl(master, syscall.TIOCSPTLCK, unsafe.Pointer(&unlock)); err != nil {
+		master.Close()
+		return nil, nil, fmt.Errorf("failed to unlock pty: %v", err)
+	}
+
+	var n uint32
+	if err := ioctl(master, syscall.TIOCGPTN, unsafe.Pointer(&n)); err != nil {
+		master.Close()
+		return nil, nil, fmt.Errorf("failed to get pty number: %v", err)
+	}
+
+	tty, err := os.OpenFile(fmt.Sprintf("/dev/pts/%d", n), os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		master.Close()
+		return nil, nil, fmt.Errorf("failed to open pty: %v", err)
+	}
+
+	return master, tty, nil
+}
+
+// struct winsize from <sys/ioctl.h>
+type winsize struct {
+	Rows, Cols, X, Y uint16
+}
+
+func setSize(master *os.File, rows, cols uint16) error {
+	ws := winsize{Rows: rows, Cols: cols}
+	return ioctl(master, syscall.TIOCSWINSZ, unsafe.Pointer(&ws))
+}
+
+func ioctl(f *os.File, req uintptr, arg unsafe.Pointer) error {
+	conn, err := f.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	var errno syscall.Errno
+	err = conn.Control(func(fd uintptr) {
+		_, _, errno = syscall.Syscall(syscall.SYS_IOCTL, fd, req, uintptr(arg))
+	})
+	if err != nil {
+		return err
+	}
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+//go:build linux
+
+package procbridge
+
+import (
+	"net"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+
+	"github.com/pgxtips/crocsoc/crocsoc"
+)
+
+func TestRunPTY(t *testing.T) {
+	if _, err := os.Stat("/dev/ptmx"); err != nil {
+		t.Skip("no /dev/ptmx")
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer ln.Close()
+
+	clientConn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer clientConn.Close()
+
+	serverConn, err := ln.Accept()
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	// reports whether it runs on a terminal and its size once told to go on
+	cmd := exec.Command("sh", "-c", "read go; test -t 0 && echo tty; stty size")
+
+	result := make(chan error, 1)
+	go func() {
+		result <- RunPTY(&crocsoc.WSConn{Conn: serverConn}, cmd, Options{})
+	}()
+
+	client := &crocsoc.WSConn{Conn: clientConn, Role: crocsoc.RoleClient}
+	client.SendTextFrame([]byte(`{"type":"resize","cols":100,"rows":30}`))
+	client.SendBinaryFrame([]byte("go\n"))
+
+	out := readAll(t, client)
+	if !strings.Contains(out, "tty\r\n") || !strings.Contains(out, "30 100\r\n") {
+		t.Errorf("unexpected output: %q", out)
+	}
+
+	if err := <-result; err != nil {
+		t.Errorf("%v", err)
+	}
+}
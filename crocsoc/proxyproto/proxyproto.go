@@ -0,0 +1,274 @@
+// Package proxyproto reads PROXY protocol v1 and v2 headers from accepted
+// connections, so the real client address is seen when running behind a TCP
+// mode load balancer such as HAProxy or an AWS NLB:
+//
+//	ln, _ := net.Listen("tcp", ":8080")
+//	http.Serve(&proxyproto.Listener{Listener: ln}, mux)
+//
+// RemoteAddr of accepted connections, and so http.Request.RemoteAddr and
+// everything WsHandler derives from it, is the client's address.
+//
+// Only put a Listener where every connection comes from the proxy, any
+// client reaching it directly can claim whatever address it likes.
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// v2 headers start with this signature
+var signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// longest v1 header, including the CRLF
+const maxV1Len = 107
+
+// ReadHeaderTimeout of a Listener without one
+const defaultReadHeaderTimeout = 5 * time.Second
+
+// Returned by reads on a connection that did not start with a valid header.
+var ErrNoHeader = errors.New("proxyproto: missing PROXY protocol header")
+
+// Wraps accepted connections to read their PROXY protocol header.
+type Listener struct {
+	net.Listener
+
+	// how long to wait for the header, 5s when 0. net/http asks for
+	// RemoteAddr before its own timeouts apply, so a client sending
+	// nothing would otherwise hold the connection open forever. Negative
+	// for no limit.
+	ReadHeaderTimeout time.Duration
+
+	// lets connections without a header through, with their own addresses.
+	// Only for moving a deployment onto the proxy.
+	Optional bool
+}
+
+func (l *Listener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	timeout := l.ReadHeaderTimeout
+	if timeout == 0 {
+		timeout = defaultReadHeaderTimeout
+	}
+
+	return &Conn{
+		Conn:     conn,
+		br:       bufio.NewReader(conn),
+		timeout:  timeout,
+		optional: l.Optional,
+	}, nil
+}
+
+// A net.Conn whose addresses are those from its PROXY protocol header. The
+// header is read on first use, so Accept never blocks on a slow client.
+type Conn struct {
+	net.Conn
+
+	br       *bufio.Reader
+	timeout  time.Duration
+	optional bool
+
+	once     sync.Once
+	src, dst net.Addr
+	err      error
+}
+
+func (c *Conn) Read(p []byte) (int, error) {
+	c.once.Do(c.readHeader)
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.br.Read(p)
+}
+
+// Returns the accepted connection, e.g. for WSConn.Terminate to reach the
+// TCP connection underneath.
+func (c *Conn) NetConn() net.Conn {
+	return c.Conn
+}
+
+// The client's address, or the proxy's for LOCAL and UNKNOWN headers.
+func (c *Conn) RemoteAddr() net.Addr {
+	c.once.Do(c.readHeader)
+	if c.src != nil {
+		return c.src
+	}
+	return c.Conn.RemoteAddr()
+}
+
+// The address the client connected to, or the proxy's for LOCAL and UNKNOWN
+// headers.
+func (c *Conn) LocalAddr() net.Addr {
+	c.once.Do(c.readHeader)
+	if c.dst != nil {
+		return c.dst
+	}
+	return c.Conn.LocalAddr()
+}
+
+func (c *Conn) readHeader() {
+	if c.timeout > 0 {
+		c.Conn.SetReadDeadline(time.Now().Add(c.timeout))
+		defer c.Conn.SetReadDeadline(time.Time{})
+	}
+
+	// v1 "PROXY" and the v2 signature differ in the first byte
+	b, err := c.br.Peek(1)
+	if err != nil {
+		c.err = err
+		return
+	}
+
+	switch b[0] {
+	case 'P':
+		c.src, c.dst, c.err = readV1(c.br)
+	case signature[0]:
+		c.src, c.dst, c.err = readV2(c.br)
+	default:
+		if !c.optional {
+			c.err = ErrNoHeader
+		}
+	}
+
+	if c.err != nil {
+		c.Conn.Close()
+	}
+}
+
+/*
+v1, human readable:
+
+	PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\n
+	PROXY UNKNOWN\r\n
+*/
+func readV1(br *bufio.Reader) (net.Addr, net.Addr, error) {
+	var line []byte
+	for !bytes.HasSuffix(line, []byte("\r\n")) {
+		if len(line) >= maxV1Len {
+			return nil, nil, fmt.Errorf("%w: v1 header too long", ErrNoHeader)
+		}
+
+		b, err := br.ReadByte()
+		if err != nil {
+			return nil, nil, err
+		}
+		line = append(line, b)
+	}
+
+	fields := strings.Fields(string(line))
+	if len(fields) < 2 || fields[0] != "PROXY" {
+		return nil, nil, fmt.Errorf("%w: malformed v1 header", ErrNoHeader)
+	}
+
+	switch fields[1] {
+	// proxy couldn't tell, e.g. health checks
+	case "UNKNOWN":
+		return nil, nil, nil
+	case "TCP4", "TCP6":
+	default:
+		return nil, nil, fmt.Errorf("%w: unsupported v1 protocol %q", ErrNoHeader, fields[1])
+	}
+
+	if len(fields) != 6 {
+		return nil, nil, fmt.Errorf("%w: malformed v1 header", ErrNoHeader)
+	}
+
+	src, err := tcpAddr(fields[2], fields[4])
+	if err != nil {
+		return nil, nil, err
+	}
+	dst, err := tcpAddr(fields[3], fields[5])
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return src, dst, nil
+}
+
+func tcpAddr(host, port string) (*net.TCPAddr, error) {
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return nil, fmt.Errorf("%w: invalid address %q", ErrNoHeader, host)
+	}
+
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid port %q", ErrNoHeader, port)
+	}
+
+	return &net.TCPAddr{IP: ip, Port: int(p)}, nil
+}
+
+/*
+v2, binary:
+
+	signature (12) | version 2, command (1) | family, protocol (1) | length (2)
+	addresses: src, dst, src port, dst port | TLVs
+*/
+func readV2(br *bufio.Reader) (net.Addr, net.Addr, error) {
+	var head [16]byte
+	if _, err := io.ReadFull(br, head[:]); err != nil {
+		return nil, nil, err
+	}
+
+	if !bytes.Equal(head[:12], signature) {
+		return nil, nil, fmt.Errorf("%w: bad v2 signature", ErrNoHeader)
+	}
+	if head[12]>>4 != 0x2 {
+		return nil, nil, fmt.Errorf("%w: unsupported version %d", ErrNoHeader, head[12]>>4)
+	}
+
+	body := make([]byte, binary.BigEndian.Uint16(head[14:16]))
+	if _, err := io.ReadFull(br, body); err != nil {
+		return nil, nil, err
+	}
+
+	switch head[12] & 0x0F {
+	// LOCAL, connections made by the proxy itself
+	case 0x0:
+		return nil, nil, nil
+	// PROXY
+	case 0x1:
+	default:
+		return nil, nil, fmt.Errorf("%w: unsupported command %x", ErrNoHeader, head[12]&0x0F)
+	}
+
+	var ipLen int
+	switch head[13] {
+	case 0x11: // TCP over IPv4
+		ipLen = 4
+	case 0x21: // TCP over IPv6
+		ipLen = 16
+	// UDP and unix sockets, keep the proxy's addresses
+	default:
+		return nil, nil, nil
+	}
+
+	if len(body) < 2*ipLen+4 {
+		return nil, nil, fmt.Errorf("%w: v2 addresses truncated", ErrNoHeader)
+	}
+
+	src := &net.TCPAddr{
+		IP:   net.IP(body[:ipLen]),
+		Port: int(binary.BigEndian.Uint16(body[2*ipLen:])),
+	}
+	dst := &net.TCPAddr{
+		IP:   net.IP(body[ipLen : 2*ipLen]),
+		Port: int(binary.BigEndian.Uint16(body[2*ipLen+2:])),
+	}
+
+	return src, dst, nil
+}
@@ -0,0 +1,175 @@
+package proxyproto
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// accepts one connection through a Listener after writing raw to it
+func accept(t *testing.T, l *Listener, raw []byte) net.Conn {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	l.Listener = ln
+
+	client, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+
+	if _, err := client.Write(raw); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	conn, err := l.Accept()
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	return conn
+}
+
+func readAll(t *testing.T, conn net.Conn, n int) string {
+	t.Helper()
+
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		t.Fatalf("%v", err)
+	}
+	return string(buf)
+}
+
+func TestV1(t *testing.T) {
+	conn := accept(t, &Listener{}, []byte("PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\nGET /"))
+
+	if got := conn.RemoteAddr().String(); got != "192.0.2.1:56324" {
+		t.Errorf("want: 192.0.2.1:56324, got: %s", got)
+	}
+	if got := conn.LocalAddr().String(); got != "198.51.100.1:443" {
+		t.Errorf("want: 198.51.100.1:443, got: %s", got)
+	}
+	if got := readAll(t, conn, 5); got != "GET /" {
+		t.Errorf("want: GET /, got: %q", got)
+	}
+}
+
+func TestV1Unknown(t *testing.T) {
+	conn := accept(t, &Listener{}, []byte("PROXY UNKNOWN\r\nx"))
+
+	if got := conn.RemoteAddr().String(); got != conn.(*Conn).Conn.RemoteAddr().String() {
+		t.Errorf("want proxy address, got: %s", got)
+	}
+	if got := readAll(t, conn, 1); got != "x" {
+		t.Errorf("want: x, got: %q", got)
+	}
+}
+
+func v2Header(cmd, fam byte, addrs []byte) []byte {
+	h := append([]byte(nil), signature...)
+	h = append(h, 0x20|cmd, fam)
+	h = binary.BigEndian.AppendUint16(h, uint16(len(addrs)))
+	return append(h, addrs...)
+}
+
+func TestV2(t *testing.T) {
+	addrs := net.ParseIP("2001:db8::1").To16()
+	addrs = append(addrs, net.ParseIP("2001:db8::2").To16()...)
+	addrs = binary.BigEndian.AppendUint16(addrs, 56324)
+	addrs = binary.BigEndian.AppendUint16(addrs, 443)
+	// a TLV the reader must skip
+	addrs = append(addrs, 0x04, 0x00, 0x01, 0xFF)
+
+	raw := append(v2Header(0x1, 0x21, addrs), "GET /"...)
+	conn := accept(t, &Listener{}, raw)
+
+	if got := conn.RemoteAddr().String(); got != "[2001:db8::1]:56324" {
+		t.Errorf("want: [2001:db8::1]:56324, got: %s", got)
+	}
+	if got := readAll(t, conn, 5); got != "GET /" {
+		t.Errorf("want: GET /, got: %q", got)
+	}
+}
+
+func TestV2Local(t *testing.T) {
+	conn := accept(t, &Listener{}, append(v2Header(0x0, 0x00, nil), 'x'))
+
+	if got := conn.RemoteAddr().String(); got != conn.(*Conn).Conn.RemoteAddr().String() {
+		t.Errorf("want proxy address, got: %s", got)
+	}
+	if got := readAll(t, conn, 1); got != "x" {
+		t.Errorf("want: x, got: %q", got)
+	}
+}
+
+func TestMissingHeader(t *testing.T) {
+	conn := accept(t, &Listener{}, []byte("GET / HTTP/1.1\r\n"))
+
+	if _, err := conn.Read(make([]byte, 1)); !errors.Is(err, ErrNoHeader) {
+		t.Errorf("want: ErrNoHeader, got: %v", err)
+	}
+
+	for _, raw := range []string{
+		"PROXY TCP4 192.0.2.1\r\n",
+		"PROXY TCP4 nope 198.51.100.1 1 2\r\n",
+		"PROXY TCP4 192.0.2.1 198.51.100.1 1 99999\r\n",
+		"PROXY UDP4 192.0.2.1 198.51.100.1 1 2\r\n",
+	} {
+		conn := accept(t, &Listener{}, []byte(raw))
+		if _, err := conn.Read(make([]byte, 1)); !errors.Is(err, ErrNoHeader) {
+			t.Errorf("%q: want: ErrNoHeader, got: %v", raw, err)
+		}
+	}
+}
+
+func TestOptional(t *testing.T) {
+	conn := accept(t, &Listener{Optional: true}, []byte("GET /"))
+
+	if got := conn.RemoteAddr().String(); got != conn.(*Conn).Conn.RemoteAddr().String() {
+		t.Errorf("want own address, got: %s", got)
+	}
+	if got := readAll(t, conn, 5); got != "GET /" {
+		t.Errorf("want: GET /, got: %q", got)
+	}
+}
+
+func TestReadHeaderTimeout(t *testing.T) {
+	if conn := accept(t, &Listener{}, nil).(*Conn); conn.timeout != defaultReadHeaderTimeout {
+		t.Errorf("want: %v, got: %v", defaultReadHeaderTimeout, conn.timeout)
+	}
+
+	// a client that never sends a header
+	conn := accept(t, &Listener{ReadHeaderTimeout: 50 * time.Millisecond}, nil)
+
+	done := make(chan struct{})
+	go func() {
+		conn.RemoteAddr()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("RemoteAddr still waiting on the header")
+	}
+	if _, err := conn.Read(make([]byte, 1)); err == nil {
+		t.Errorf("want: error, got: nil")
+	}
+}
+
+func TestNetConn(t *testing.T) {
+	conn := accept(t, &Listener{}, nil)
+
+	if _, ok := conn.(*Conn).NetConn().(*net.TCPConn); !ok {
+		t.Errorf("want: *net.TCPConn, got: %T", conn.(*Conn).NetConn())
+	}
+}
@@ -0,0 +1,207 @@
+// Package ratelimit provides token bucket and sliding window limiters for
+// application level limits, such as messages per room or commands per
+// connection.
+//
+//	limits := ratelimit.NewKeyed(func() ratelimit.Limiter {
+//		return ratelimit.NewTokenBucket(10, 20)
+//	})
+//	if !limits.Allow(cmd.Name) {
+//		conn.CloseWithReason(1008, crocsoc.CloseReason{Code: "rate_limited"})
+//	}
+//
+// Every limiter reports its decisions to an optional Observe hook, for
+// counting allowed and rejected events in a metrics system.
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// Decides whether an event may happen now.
+type Limiter interface {
+	Allow() bool
+}
+
+// Allows bursts of up to Burst events, refilling at Rate events per second.
+type TokenBucket struct {
+	// called with every decision, e.g. to increment a counter
+	Observe func(allowed bool)
+
+	rate  float64
+	burst float64
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+	now    func() time.Time
+}
+
+// Returns a full bucket.
+func NewTokenBucket(rate float64, burst int) *TokenBucket {
+	return &TokenBucket{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		now:    time.Now,
+	}
+}
+
+func (b *TokenBucket) Allow() bool {
+	return b.AllowN(1)
+}
+
+// Takes n tokens if the bucket holds that many.
+func (b *TokenBucket) AllowN(n int) bool {
+	b.mu.Lock()
+	b.refill()
+
+	allowed := b.tokens >= float64(n)
+	if allowed {
+		b.tokens -= float64(n)
+	}
+	b.mu.Unlock()
+
+	observe(b.Observe, allowed)
+	return allowed
+}
+
+// Returns the tokens currently in the bucket.
+func (b *TokenBucket) Tokens() float64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.refill()
+	return b.tokens
+}
+
+// must hold mu
+func (b *TokenBucket) refill() {
+	now := b.now()
+	if !b.last.IsZero() {
+		b.tokens = min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	}
+	b.last = now
+}
+
+/*
+A sliding window counter keeps counts for the current and previous fixed
+windows and weighs the previous one by how much of it still overlaps the
+sliding window:
+
+	estimate = prev * (1 - elapsed/window) + curr
+
+which is within a few percent of an exact sliding log at the cost of two
+counters instead of a timestamp per event.
+*/
+
+// Allows up to Limit events in any Window long stretch of time.
+type SlidingWindow struct {
+	// called with every decision, e.g. to increment a counter
+	Observe func(allowed bool)
+
+	limit  int
+	window time.Duration
+
+	mu    sync.Mutex
+	start time.Time
+	prev  int
+	curr  int
+	now   func() time.Time
+}
+
+func NewSlidingWindow(limit int, window time.Duration) *SlidingWindow {
+	return &SlidingWindow{limit: limit, window: window, now: time.Now}
+}
+
+func (w *SlidingWindow) Allow() bool {
+	w.mu.Lock()
+	now := w.now()
+
+	if w.start.IsZero() {
+		w.start = now
+	}
+
+	// move the fixed windows forward
+	if elapsed := now.Sub(w.start); elapsed >= w.window {
+		if elapsed < 2*w.window {
+			w.prev = w.curr
+		} else {
+			w.prev = 0
+		}
+		w.curr = 0
+		w.start = w.start.Add(elapsed / w.window * w.window)
+	}
+
+	overlap := 1 - float64(now.Sub(w.start))/float64(w.window)
+	estimate := float64(w.prev)*overlap + float64(w.curr)
+
+	allowed := estimate < float64(w.limit)
+	if allowed {
+		w.curr++
+	}
+	w.mu.Unlock()
+
+	observe(w.Observe, allowed)
+	return allowed
+}
+
+func observe(fn func(bool), allowed bool) {
+	if fn != nil {
+		fn(allowed)
+	}
+}
+
+// A limiter per key, created on first use.
+type Keyed[K comparable] struct {
+	newLimiter func() Limiter
+
+	mu       sync.Mutex
+	limiters map[K]*keyedLimiter
+}
+
+type keyedLimiter struct {
+	Limiter
+	used time.Time
+}
+
+// Returns limiters built by newLimiter, one per key.
+func NewKeyed[K comparable](newLimiter func() Limiter) *Keyed[K] {
+	return &Keyed[K]{newLimiter: newLimiter, limiters: map[K]*keyedLimiter{}}
+}
+
+func (k *Keyed[K]) Allow(key K) bool {
+	k.mu.Lock()
+	l, ok := k.limiters[key]
+	if !ok {
+		l = &keyedLimiter{Limiter: k.newLimiter()}
+		k.limiters[key] = l
+	}
+	l.used = time.Now()
+	k.mu.Unlock()
+
+	return l.Allow()
+}
+
+// Forgets limiters unused for idle, so short lived keys don't pile up.
+// Returns how many were removed.
+func (k *Keyed[K]) Prune(idle time.Duration) int {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	n := 0
+	for key, l := range k.limiters {
+		if time.Since(l.used) > idle {
+			delete(k.limiters, key)
+			n++
+		}
+	}
+	return n
+}
+
+// Returns the number of keys with a limiter.
+func (k *Keyed[K]) Len() int {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return len(k.limiters)
+}
@@ -0,0 +1,109 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+// a clock moved by hand
+type fakeClock struct{ t time.Time }
+
+func (c *fakeClock) now() time.Time          { return c.t }
+func (c *fakeClock) advance(d time.Duration) { c.t = c.t.Add(d) }
+
+func TestTokenBucket(t *testing.T) {
+	clock := &fakeClock{t: time.Unix(0, 0)}
+	b := NewTokenBucket(2, 3)
+	b.now = clock.now
+
+	var allowed, rejected int
+	b.Observe = func(ok bool) {
+		if ok {
+			allowed++
+		} else {
+			rejected++
+		}
+	}
+
+	// the burst, then empty
+	for i := range 4 {
+		if got, want := b.Allow(), i < 3; got != want {
+			t.Errorf("event %d: want: %v, got: %v", i, want, got)
+		}
+	}
+
+	// refills at 2/s
+	clock.advance(500 * time.Millisecond)
+	if !b.Allow() {
+		t.Errorf("token not refilled")
+	}
+	if b.Allow() {
+		t.Errorf("refilled beyond rate")
+	}
+
+	// never beyond the burst
+	clock.advance(time.Hour)
+	if got := b.Tokens(); got != 3 {
+		t.Errorf("want: 3, got: %v", got)
+	}
+	if b.AllowN(4) {
+		t.Errorf("took more than the burst")
+	}
+
+	if allowed != 4 || rejected != 3 {
+		t.Errorf("want: 4 allowed 3 rejected, got: %d %d", allowed, rejected)
+	}
+}
+
+func TestSlidingWindow(t *testing.T) {
+	clock := &fakeClock{t: time.Unix(0, 0)}
+	w := NewSlidingWindow(10, time.Second)
+	w.now = clock.now
+
+	for range 10 {
+		if !w.Allow() {
+			t.Fatalf("rejected within the limit")
+		}
+	}
+	if w.Allow() {
+		t.Errorf("allowed beyond the limit")
+	}
+
+	// half the previous window still overlaps: 10*0.5 counted, 5 free
+	clock.advance(1500 * time.Millisecond)
+	n := 0
+	for w.Allow() {
+		n++
+	}
+	if n != 5 {
+		t.Errorf("want: 5, got: %d", n)
+	}
+
+	// long idle, full limit again
+	clock.advance(time.Minute)
+	n = 0
+	for w.Allow() {
+		n++
+	}
+	if n != 10 {
+		t.Errorf("want: 10, got: %d", n)
+	}
+}
+
+func TestKeyed(t *testing.T) {
+	k := NewKeyed[string](func() Limiter { return NewTokenBucket(0, 1) })
+
+	if !k.Allow("a") || k.Allow("a") {
+		t.Errorf("key a not limited on its own")
+	}
+	if !k.Allow("b") {
+		t.Errorf("key b limited by key a")
+	}
+
+	if n := k.Prune(time.Hour); n != 0 || k.Len() != 2 {
+		t.Errorf("pruned keys in use")
+	}
+	if n := k.Prune(0); n != 2 || k.Len() != 0 {
+		t.Errorf("want: 2 pruned, got: %d", n)
+	}
+}
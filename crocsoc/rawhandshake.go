@@ -0,0 +1,69 @@
+package crocsoc
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+/*
+net/http canonicalizes header names and writes them in sorted order, which
+some embedded clients can't cope with (e.g. expecting "Sec-WebSocket-Accept"
+rather than "Sec-Websocket-Accept", or Upgrade before Connection). With
+RawHandshake on, WsHandler hijacks first and writes the 101 response bytes
+itself:
+
+	HTTP/1.1 101 Switching Protocols\r\n
+	Upgrade: websocket\r\n
+	Connection: Upgrade\r\n
+	Sec-WebSocket-Accept: s3pPLMBiTxaQ9kYGzzhZRbK+xOo=\r\n
+	<extra headers, in order, as given>\r\n
+	\r\n
+*/
+
+// set by RawHandshake
+var rawHandshake bool
+
+// When on, WsHandler writes the 101 response itself over the hijacked
+// connection, in a fixed order with Sec-WebSocket-* casing preserved, instead
+// of through net/http. Must be called before any connections are served.
+func RawHandshake(on bool) {
+	rawHandshake = on
+}
+
+// A response header written exactly as given.
+type HeaderField struct {
+	Name  string
+	Value string
+}
+
+// Writes the 101 response for upgrade request r to w, typically a hijacked
+// connection's buffered writer, which is flushed. r must already have passed
+// ValidateHeaders.
+func WriteRawHandshake(w io.Writer, r *http.Request, extra []HeaderField) error {
+	var b strings.Builder
+
+	b.WriteString("HTTP/1.1 101 Switching Protocols\r\n")
+	b.WriteString("Upgrade: websocket\r\n")
+	b.WriteString("Connection: Upgrade\r\n")
+	b.WriteString("Sec-WebSocket-Accept: " + SecAcceptSha(r.Header.Get("Sec-WebSocket-Key")) + "\r\n")
+
+	for _, h := range extra {
+		// no response splitting through header values
+		if strings.ContainsAny(h.Name, "\r\n:") || strings.ContainsAny(h.Value, "\r\n") {
+			return fmt.Errorf("invalid header %q", h.Name)
+		}
+		b.WriteString(h.Name + ": " + h.Value + "\r\n")
+	}
+	b.WriteString("\r\n")
+
+	if _, err := io.WriteString(w, b.String()); err != nil {
+		return err
+	}
+
+	if f, ok := w.(interface{ Flush() error }); ok {
+		return f.Flush()
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+package crocsoc
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestWriteRawHandshake(t *testing.T) {
+	r := buildRequest(map[string]string{"Sec-WebSocket-Key": "dGhlIHNhbXBsZSBub25jZQ=="})
+
+	var buf bytes.Buffer
+	err := WriteRawHandshake(&buf, r, []HeaderField{
+		{Name: "Sec-WebSocket-Protocol", Value: "chat"},
+		{Name: "x-lowercase", Value: "kept"},
+	})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	want := "HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: s3pPLMBiTxaQ9kYGzzhZRbK+xOo=\r\n" +
+		"Sec-WebSocket-Protocol: chat\r\n" +
+		"x-lowercase: kept\r\n" +
+		"\r\n"
+	if buf.String() != want {
+		t.Errorf("want: %q, got: %q", want, buf.String())
+	}
+
+	err = WriteRawHandshake(io.Discard, r, []HeaderField{{Name: "X-Evil", Value: "a\r\nSet-Cookie: x"}})
+	if err == nil {
+		t.Errorf("header with CRLF accepted")
+	}
+}
+
+func TestWsHandlerRawHandshake(t *testing.T) {
+	RawHandshake(true)
+	defer RawHandshake(false)
+
+	srv := httptest.NewServer(http.HandlerFunc(WsHandler))
+	defer srv.Close()
+
+	conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer conn.Close()
+
+	io.WriteString(conn, "GET / HTTP/1.1\r\n"+
+		"Host: localhost\r\n"+
+		"Upgrade: websocket\r\n"+
+		"Connection: Upgrade\r\n"+
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n"+
+		"Sec-WebSocket-Version: 13\r\n"+
+		"X-Request-ID: abc\r\n\r\n")
+
+	br := bufio.NewReader(conn)
+
+	var lines []string
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		if line == "\r\n" {
+			break
+		}
+		lines = append(lines, strings.TrimRight(line, "\r\n"))
+	}
+
+	want := []string{
+		"HTTP/1.1 101 Switching Protocols",
+		"Upgrade: websocket",
+		"Connection: Upgrade",
+		"Sec-WebSocket-Accept: s3pPLMBiTxaQ9kYGzzhZRbK+xOo=",
+		"X-Request-ID: abc",
+	}
+	if strings.Join(lines, "\n") != strings.Join(want, "\n") {
+		t.Errorf("want: %q, got: %q", want, lines)
+	}
+}
+
+func TestUpgraderRawHandshakeHeaderOrder(t *testing.T) {
+	RawHandshake(true)
+	defer RawHandshake(false)
+
+	var upgrader Upgrader
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, http.Header{"X-C": {"3"}, "X-A": {"1"}, "X-B": {"2"}})
+		if err == nil {
+			conn.NetConn().Close()
+		}
+	}))
+	defer srv.Close()
+
+	conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer conn.Close()
+
+	io.WriteString(conn, "GET / HTTP/1.1\r\n"+
+		"Host: localhost\r\n"+
+		"Upgrade: websocket\r\n"+
+		"Connection: Upgrade\r\n"+
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n"+
+		"Sec-WebSocket-Version: 13\r\n"+
+		"X-Request-ID: abc\r\n\r\n")
+
+	raw, _ := io.ReadAll(conn)
+	if !strings.Contains(string(raw), "X-A: 1\r\nX-B: 2\r\nX-C: 3\r\n") {
+		t.Errorf("want: X-A, X-B, X-C in order, got: %q", raw)
+	}
+}
@@ -0,0 +1,263 @@
+package record
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+	"unicode/utf8"
+)
+
+/*
+HAR 1.2 export, using the fields Chrome devtools writes for WebSocket
+requests (_resourceType "websocket" and _webSocketMessages), so a capture can
+be opened in the browser's network panel or any HAR viewer.
+
+Messages are reported from the recorded connection's point of view: "send"
+for outbound, "receive" for inbound. Control frames are left out, as they
+are in devtools exports. Compressed messages are written inflated.
+*/
+
+type harLog struct {
+	Log struct {
+		Version string     `json:"version"`
+		Creator harCreator `json:"creator"`
+		Entries []harEntry `json:"entries"`
+	} `json:"log"`
+}
+
+type harCreator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type harEntry struct {
+	StartedDateTime string         `json:"startedDateTime"`
+	Time            float64        `json:"time"`
+	Request         harRequest     `json:"request"`
+	Response        harResponse    `json:"response"`
+	Cache           struct{}       `json:"cache"`
+	Timings         harTimings     `json:"timings"`
+	ResourceType    string         `json:"_resourceType"`
+	Messages        []harWSMessage `json:"_webSocketMessages"`
+}
+
+type harRequest struct {
+	Method      string      `json:"method"`
+	URL         string      `json:"url"`
+	HTTPVersion string      `json:"httpVersion"`
+	Headers     []harHeader `json:"headers"`
+	QueryString []harHeader `json:"queryString"`
+	Cookies     []harHeader `json:"cookies"`
+	HeadersSize int         `json:"headersSize"`
+	BodySize    int         `json:"bodySize"`
+}
+
+type harResponse struct {
+	Status      int         `json:"status"`
+	StatusText  string      `json:"statusText"`
+	HTTPVersion string      `json:"httpVersion"`
+	Headers     []harHeader `json:"headers"`
+	Cookies     []harHeader `json:"cookies"`
+	Content     struct {
+		Size     int    `json:"size"`
+		MimeType string `json:"mimeType"`
+	} `json:"content"`
+	RedirectURL string `json:"redirectURL"`
+	HeadersSize int    `json:"headersSize"`
+	BodySize    int    `json:"bodySize"`
+}
+
+type harHeader struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type harTimings struct {
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+}
+
+type harWSMessage struct {
+	Type string `json:"type"`
+	// unix seconds
+	Time   float64 `json:"time"`
+	Opcode byte    `json:"opcode"`
+	// text as is, binary base64 encoded
+	Data string `json:"data"`
+}
+
+// Converts the recording read from src to a HAR file with a single
+// WebSocket entry.
+func ExportHAR(w io.Writer, src io.Reader) error {
+	rd, err := NewReader(src)
+	if err != nil {
+		return err
+	}
+
+	entry := harEntry{
+		StartedDateTime: rd.Start.UTC().Format(time.RFC3339Nano),
+		Request: harRequest{
+			Method:      http.MethodGet,
+			HTTPVersion: "HTTP/1.1",
+			Headers:     []harHeader{},
+			QueryString: []harHeader{},
+			Cookies:     []harHeader{},
+			HeadersSize: -1,
+		},
+		Response: harResponse{
+			Status:      http.StatusSwitchingProtocols,
+			StatusText:  http.StatusText(http.StatusSwitchingProtocols),
+			HTTPVersion: "HTTP/1.1",
+			Headers:     []harHeader{},
+			Cookies:     []harHeader{},
+			HeadersSize: -1,
+		},
+		ResourceType: "websocket",
+		Messages:     []harWSMessage{},
+	}
+	entry.Response.Content.MimeType = "x-unknown"
+
+	// fragments of the message in progress, per direction
+	var pending [2]*harWSMessage
+	// whether it is compressed; whole messages were recorded inflated, a
+	// fragmented one has RSV1 on its first frame
+	var compressed [2]bool
+
+	maxPayload := rd.MaxPayload
+	if maxPayload == 0 {
+		maxPayload = defaultMaxPayload
+	}
+
+	for {
+		rec, err := rd.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		if rec.Handshake {
+			addHandshake(&entry, rec)
+			continue
+		}
+
+		f := rec.Frame
+		if f.Opcode >= 0x8 {
+			continue
+		}
+
+		msg := pending[rec.Direction]
+		if msg == nil {
+			msg = &harWSMessage{
+				Type:   "receive",
+				Time:   float64(rd.Start.Add(rec.Offset).UnixNano()) / 1e9,
+				Opcode: f.Opcode,
+			}
+			if rec.Direction == Outbound {
+				msg.Type = "send"
+			}
+			pending[rec.Direction] = msg
+			compressed[rec.Direction] = rd.Compression && f.Rsv&0x4 != 0
+		}
+		msg.Data += string(f.Payload)
+
+		if f.Fin {
+			if compressed[rec.Direction] {
+				data, err := inflate([]byte(msg.Data), maxPayload)
+				if err != nil {
+					return err
+				}
+				msg.Data = string(data)
+			}
+			if msg.Opcode == 0x2 || !utf8.ValidString(msg.Data) {
+				msg.Data = base64.StdEncoding.EncodeToString([]byte(msg.Data))
+			}
+			entry.Messages = append(entry.Messages, *msg)
+			pending[rec.Direction] = nil
+		}
+	}
+
+	var har harLog
+	har.Log.Version = "1.2"
+	har.Log.Creator = harCreator{Name: "crocsoc", Version: "1"}
+	har.Log.Entries = []harEntry{entry}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(har)
+}
+
+// decompresses a permessage-deflate message, putting back the empty stored
+// block stripped from its end and adding a final one so the inflater stops
+// cleanly
+func inflate(p []byte, limit uint64) ([]byte, error) {
+	tail := []byte{0x00, 0x00, 0xff, 0xff, 0x01, 0x00, 0x00, 0xff, 0xff}
+	fr := flate.NewReader(io.MultiReader(bytes.NewReader(p), bytes.NewReader(tail)))
+	defer fr.Close()
+
+	out, err := io.ReadAll(io.LimitReader(fr, int64(limit)+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to inflate message: %v", err)
+	}
+	if uint64(len(out)) > limit {
+		return nil, fmt.Errorf("message inflates past %d bytes", limit)
+	}
+	return out, nil
+}
+
+// fills the entry's request or response from a handshake record
+func addHandshake(entry *harEntry, rec Record) {
+	br := bufio.NewReader(bytes.NewReader(rec.Frame.Payload))
+
+	// the request is outbound in client side recordings
+	if !bytes.HasPrefix(rec.Frame.Payload, []byte("HTTP/")) {
+		req, err := http.ReadRequest(br)
+		if err != nil {
+			return
+		}
+
+		scheme := "ws"
+		if req.Header.Get("X-Forwarded-Proto") == "https" {
+			scheme = "wss"
+		}
+
+		entry.Request.Method = req.Method
+		entry.Request.URL = scheme + "://" + req.Host + req.URL.RequestURI()
+		entry.Request.Headers = harHeaders(req.Header)
+		entry.Request.Headers = append(entry.Request.Headers, harHeader{Name: "Host", Value: req.Host})
+		for k, vs := range req.URL.Query() {
+			for _, v := range vs {
+				entry.Request.QueryString = append(entry.Request.QueryString, harHeader{Name: k, Value: v})
+			}
+		}
+		return
+	}
+
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil {
+		return
+	}
+
+	entry.Response.Status = resp.StatusCode
+	entry.Response.StatusText = http.StatusText(resp.StatusCode)
+	entry.Response.Headers = harHeaders(resp.Header)
+}
+
+func harHeaders(h http.Header) []harHeader {
+	headers := []harHeader{}
+	for k, vs := range h {
+		for _, v := range vs {
+			headers = append(headers, harHeader{Name: k, Value: v})
+		}
+	}
+	return headers
+}
@@ -0,0 +1,307 @@
+// Package record captures the frames of a WebSocket session to a compact
+// file and replays them through a WSConn, to reproduce bugs from
+// production captures.
+//
+//	rec, _ := record.NewRecorder(f)
+//	rec.Attach(conn)
+//	...
+//	rec.Flush()
+//
+// File format, all integers varint encoded:
+//
+//	"CROCREC3" | start (unix ns) | header flags
+//	record*: offset (ns since start) | flags | payload length | payload
+//
+// where header flags has bit 0 set when permessage-deflate was negotiated,
+// and a record's flags are direction (bit 7, set for outbound), fin (bit 6),
+// handshake (bit 5), RSV1 (bit 4) and the opcode (bits 0-3). Handshake
+// records hold the raw upgrade request or response instead of a frame
+// payload, inbound or outbound as seen by the recorded side.
+package record
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pgxtips/crocsoc/crocsoc"
+)
+
+const magic = "CROCREC3"
+
+// largest payload a Reader accepts without a MaxPayload
+const defaultMaxPayload = 64 << 20
+
+type Direction byte
+
+const (
+	// received from the peer
+	Inbound Direction = iota
+	// sent to the peer
+	Outbound
+)
+
+// One captured frame, or half of the opening handshake.
+type Record struct {
+	// time since the recording started
+	Offset    time.Duration
+	Direction Direction
+	Frame     crocsoc.Frame
+
+	// Frame.Payload holds the raw upgrade request or response rather than a
+	// frame
+	Handshake bool
+}
+
+// Writes the frames of attached connections to a recording.
+type Recorder struct {
+	mu    sync.Mutex
+	w     *bufio.Writer
+	start time.Time
+	err   error
+
+	// permessage-deflate was negotiated, kept in the header so replays and
+	// exports can inflate fragmented compressed messages
+	compression bool
+	// the header flags go out with the first record, by when RecordHandshake
+	// or Attach has said whether compression is on
+	wroteFlags bool
+}
+
+func NewRecorder(w io.Writer) (*Recorder, error) {
+	start := time.Now()
+
+	head := binary.AppendUvarint([]byte(magic), uint64(start.UnixNano()))
+
+	bw := bufio.NewWriter(w)
+	if _, err := bw.Write(head); err != nil {
+		return nil, err
+	}
+
+	return &Recorder{w: bw, start: start}, nil
+}
+
+// Records the opening handshake, so exports can show the upgrade request
+// and response, along with whether permessage-deflate was negotiated. role is that of the recorded connection, the request is
+// outbound for clients and inbound for servers. On the server side resp can
+// be built from the status and headers written, e.g.
+// &http.Response{StatusCode: 101, Header: w.Header()}.
+func (r *Recorder) RecordHandshake(role crocsoc.Role, req *http.Request, resp *http.Response) {
+	r.mu.Lock()
+	r.compression = r.compression || deflateNegotiated(resp.Header)
+	r.mu.Unlock()
+
+	reqDir, respDir := Inbound, Outbound
+	if role == crocsoc.RoleClient {
+		reqDir, respDir = Outbound, Inbound
+	}
+
+	var buf bytes.Buffer
+
+	// headers only, upgrade requests have no body worth keeping
+	req.Header.Write(&buf)
+	raw := fmt.Sprintf("%s %s HTTP/1.1\r\nHost: %s\r\n%s\r\n", req.Method, req.URL.RequestURI(), req.Host, buf.String())
+	r.writeRecord(reqDir, 0x20, []byte(raw))
+
+	buf.Reset()
+	resp.Header.Write(&buf)
+	raw = fmt.Sprintf("HTTP/1.1 %03d %s\r\n%s\r\n", resp.StatusCode, http.StatusText(resp.StatusCode), buf.String())
+	r.writeRecord(respDir, 0x20, []byte(raw))
+}
+
+// Records every frame conn reads or writes from now on. Frames are captured
+// unmasked, after any interceptors registered before Attach. Attach before
+// recording anything else, so the header says whether conn compresses.
+func (r *Recorder) Attach(conn *crocsoc.WSConn) {
+	r.mu.Lock()
+	r.compression = r.compression || conn.Compression
+	r.mu.Unlock()
+
+	conn.InterceptInbound(func(f *crocsoc.Frame) (*crocsoc.Frame, error) {
+		r.write(Inbound, f)
+		return f, nil
+	})
+	conn.InterceptOutbound(func(f *crocsoc.Frame) (*crocsoc.Frame, error) {
+		r.write(Outbound, f)
+		return f, nil
+	})
+}
+
+// Writes out buffered records, returning the first error hit while
+// recording.
+func (r *Recorder) Flush() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.writeFlags()
+	if r.err != nil {
+		return r.err
+	}
+	return r.w.Flush()
+}
+
+func (r *Recorder) write(dir Direction, f *crocsoc.Frame) {
+	flags := f.Opcode & 0x0F
+	if f.Fin {
+		flags |= 0x40
+	}
+	// RSV1 marks a compressed message
+	if f.Rsv&0x4 != 0 {
+		flags |= 0x10
+	}
+
+	r.writeRecord(dir, flags, f.Payload)
+}
+
+// a failing recording must not break the connection, so errors are kept
+// for Flush
+func (r *Recorder) writeRecord(dir Direction, flags byte, payload []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.writeFlags()
+	if r.err != nil {
+		return
+	}
+
+	if dir == Outbound {
+		flags |= 0x80
+	}
+
+	buf := binary.AppendUvarint(nil, uint64(time.Since(r.start)))
+	buf = append(buf, flags)
+	buf = binary.AppendUvarint(buf, uint64(len(payload)))
+	buf = append(buf, payload...)
+
+	_, r.err = r.w.Write(buf)
+}
+
+// finishes the header, called with mu held
+func (r *Recorder) writeFlags() {
+	if r.wroteFlags || r.err != nil {
+		return
+	}
+	r.wroteFlags = true
+
+	var flags byte
+	if r.compression {
+		flags |= 0x01
+	}
+	r.err = r.w.WriteByte(flags)
+}
+
+// whether a handshake response accepted permessage-deflate
+func deflateNegotiated(h http.Header) bool {
+	exts, err := crocsoc.ParseExtensions(h.Values("Sec-WebSocket-Extensions")...)
+	if err != nil {
+		return false
+	}
+	for _, ext := range exts {
+		if strings.EqualFold(ext.Name, "permessage-deflate") {
+			return true
+		}
+	}
+	return false
+}
+
+// Reads records back from a recording.
+type Reader struct {
+	// when the recording started
+	Start time.Time
+	// largest record payload read, 64MB when 0
+	MaxPayload uint64
+	// permessage-deflate was negotiated, so records with RSV1 set start a
+	// compressed message
+	Compression bool
+
+	r *bufio.Reader
+}
+
+func NewReader(r io.Reader) (*Reader, error) {
+	br := bufio.NewReader(r)
+
+	head := make([]byte, len(magic))
+	if _, err := io.ReadFull(br, head); err != nil {
+		return nil, fmt.Errorf("failed to read recording header: %v", err)
+	}
+	if string(head) != magic {
+		return nil, fmt.Errorf("not a crocsoc recording")
+	}
+
+	start, err := binary.ReadUvarint(br)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read recording header: %v", err)
+	}
+
+	flags, err := br.ReadByte()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read recording header: %v", err)
+	}
+
+	return &Reader{Start: time.Unix(0, int64(start)), Compression: flags&0x01 != 0, r: br}, nil
+}
+
+// Returns the next record, or io.EOF at the end of the recording.
+func (rd *Reader) Next() (Record, error) {
+	offset, err := binary.ReadUvarint(rd.r)
+	if err != nil {
+		return Record{}, err
+	}
+
+	flags, err := rd.r.ReadByte()
+	if err != nil {
+		return Record{}, truncated(err)
+	}
+
+	n, err := binary.ReadUvarint(rd.r)
+	if err != nil {
+		return Record{}, truncated(err)
+	}
+
+	maxPayload := rd.MaxPayload
+	if maxPayload == 0 {
+		maxPayload = defaultMaxPayload
+	}
+	if n > maxPayload {
+		return Record{}, fmt.Errorf("record payload of %d bytes over the %d byte limit", n, maxPayload)
+	}
+
+	payload := make([]byte, n)
+	if _, err := io.ReadFull(rd.r, payload); err != nil {
+		return Record{}, truncated(err)
+	}
+
+	rec := Record{
+		Offset:    time.Duration(offset),
+		Direction: Inbound,
+		Handshake: flags&0x20 != 0,
+		Frame: crocsoc.Frame{
+			Fin:     flags&0x40 != 0,
+			Opcode:  flags & 0x0F,
+			Payload: payload,
+		},
+	}
+	if flags&0x10 != 0 {
+		rec.Frame.Rsv = 0x4
+	}
+	if flags&0x80 != 0 {
+		rec.Direction = Outbound
+	}
+
+	return rec, nil
+}
+
+// a recording ending mid record is corrupt, not finished
+func truncated(err error) error {
+	if errors.Is(err, io.EOF) {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
@@ -0,0 +1,359 @@
+package record
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"encoding/json"
+	"net"
+	"net/http"
+	"testing"
+
+	"github.com/pgxtips/crocsoc/crocsoc"
+)
+
+// a loopback tcp connection, buffered unlike net.Pipe so both ends can
+// write at once
+func tcpPair(t *testing.T) (net.Conn, net.Conn) {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer ln.Close()
+
+	clientConn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	serverConn, err := ln.Accept()
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	t.Cleanup(func() {
+		serverConn.Close()
+		clientConn.Close()
+	})
+	return serverConn, clientConn
+}
+
+// records a short session on the server side of a pipe
+func recordSession(t *testing.T) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	rec, err := NewRecorder(&buf)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	serverConn, clientConn := tcpPair(t)
+	server := &crocsoc.WSConn{Conn: serverConn}
+	rec.Attach(server)
+
+	go func() {
+		client := &crocsoc.WSConn{Conn: clientConn, Role: crocsoc.RoleClient}
+		client.SendTextFrame([]byte("hello"))
+		client.WriteFrame(&crocsoc.Frame{Fin: true, Opcode: 0x9, Payload: []byte("p")})
+		client.SendBinaryFrame([]byte{0x01, 0x02})
+		client.SendCloseFrame(1000, "")
+		client.ReadMessage()
+	}()
+
+	for range server.Messages() {
+	}
+
+	if err := rec.Flush(); err != nil {
+		t.Fatalf("%v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestRecord(t *testing.T) {
+	rd, err := NewReader(bytes.NewReader(recordSession(t)))
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	type entry struct {
+		dir    Direction
+		opcode byte
+	}
+	want := []entry{
+		{Inbound, 0x1},
+		{Inbound, 0x9},
+		{Outbound, 0xA}, // pong
+		{Inbound, 0x2},
+		{Inbound, 0x8},
+		{Outbound, 0x8}, // close reply
+	}
+
+	for i, w := range want {
+		rec, err := rd.Next()
+		if err != nil {
+			t.Fatalf("record %d: %v", i, err)
+		}
+
+		if rec.Direction != w.dir || rec.Frame.Opcode != w.opcode {
+			t.Errorf("record %d: want: %v, got: %+v", i, w, rec)
+		}
+	}
+
+	if _, err := rd.Next(); err == nil {
+		t.Errorf("records after the end of the session")
+	}
+}
+
+func TestReplay(t *testing.T) {
+	r := Replay(bytes.NewReader(recordSession(t)), crocsoc.RoleServer, 0)
+
+	var got [][]byte
+	for msg, err := range r.Conn.Messages() {
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		got = append(got, msg.Data)
+	}
+
+	if err := r.Wait(); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	if len(got) != 2 || string(got[0]) != "hello" || !bytes.Equal(got[1], []byte{0x01, 0x02}) {
+		t.Errorf("unexpected replayed messages: %q", got)
+	}
+}
+
+func TestNotARecording(t *testing.T) {
+	if _, err := NewReader(bytes.NewReader([]byte("GET / HTTP/1.1"))); err == nil {
+		t.Errorf("invalid recording accepted")
+	}
+}
+
+func TestRecordRsv1(t *testing.T) {
+	var buf bytes.Buffer
+	rec, err := NewRecorder(&buf)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	rec.write(Inbound, &crocsoc.Frame{Rsv: 0x4, Opcode: 0x1, Payload: []byte("x")})
+	rec.write(Inbound, &crocsoc.Frame{Fin: true, Opcode: 0x0, Payload: []byte("y")})
+	rec.Flush()
+
+	rd, err := NewReader(&buf)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	for _, want := range []byte{0x4, 0} {
+		r, err := rd.Next()
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		if r.Frame.Rsv != want {
+			t.Errorf("want: %#x, got: %#x", want, r.Frame.Rsv)
+		}
+	}
+}
+
+func TestReaderMaxPayload(t *testing.T) {
+	var buf bytes.Buffer
+	rec, err := NewRecorder(&buf)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	rec.Flush()
+
+	// a record claiming an exabyte payload
+	buf.Write([]byte{0x00, 0x42})
+	buf.Write(binary.AppendUvarint(nil, 1<<60))
+
+	rd, err := NewReader(&buf)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if _, err := rd.Next(); err == nil {
+		t.Errorf("oversized record accepted")
+	}
+}
+
+func TestExportHAR(t *testing.T) {
+	var buf bytes.Buffer
+	rec, err := NewRecorder(&buf)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	req, _ := http.NewRequest("GET", "/chat?room=1", nil)
+	req.Host = "example.com"
+	req.Header.Set("Upgrade", "websocket")
+	rec.RecordHandshake(crocsoc.RoleServer, req, &http.Response{
+		StatusCode: http.StatusSwitchingProtocols,
+		Header:     http.Header{"Upgrade": {"websocket"}},
+	})
+
+	rec.write(Inbound, &crocsoc.Frame{Fin: false, Opcode: 0x1, Payload: []byte("Hel")})
+	rec.write(Inbound, &crocsoc.Frame{Fin: true, Opcode: 0x0, Payload: []byte("lo")})
+	rec.write(Outbound, &crocsoc.Frame{Fin: true, Opcode: 0x9})
+	rec.write(Outbound, &crocsoc.Frame{Fin: true, Opcode: 0x2, Payload: []byte{0xff}})
+	rec.Flush()
+
+	var out bytes.Buffer
+	if err := ExportHAR(&out, &buf); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	var har harLog
+	if err := json.Unmarshal(out.Bytes(), &har); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	entry := har.Log.Entries[0]
+	if entry.Request.URL != "ws://example.com/chat?room=1" || entry.Response.Status != 101 {
+		t.Errorf("unexpected handshake: %+v %+v", entry.Request, entry.Response)
+	}
+
+	want := []harWSMessage{
+		{Type: "receive", Opcode: 0x1, Data: "Hello"},
+		{Type: "send", Opcode: 0x2, Data: "/w=="},
+	}
+	if len(entry.Messages) != len(want) {
+		t.Fatalf("want: %d messages, got: %+v", len(want), entry.Messages)
+	}
+	for i, w := range want {
+		got := entry.Messages[i]
+		got.Time = 0
+		if got != w {
+			t.Errorf("message %d: want: %+v, got: %+v", i, w, got)
+		}
+	}
+}
+
+func TestRecordHandshakeClient(t *testing.T) {
+	var buf bytes.Buffer
+	rec, err := NewRecorder(&buf)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	req, _ := http.NewRequest("GET", "/chat", nil)
+	req.Host = "example.com"
+	rec.RecordHandshake(crocsoc.RoleClient, req, &http.Response{StatusCode: http.StatusSwitchingProtocols, Header: http.Header{}})
+	rec.Flush()
+
+	rd, err := NewReader(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	for _, want := range []Direction{Outbound, Inbound} {
+		r, err := rd.Next()
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		if r.Direction != want {
+			t.Errorf("want: %v, got: %v", want, r.Direction)
+		}
+	}
+
+	// the export still tells the request from the response
+	var out bytes.Buffer
+	if err := ExportHAR(&out, &buf); err != nil {
+		t.Fatalf("%v", err)
+	}
+	var har harLog
+	if err := json.Unmarshal(out.Bytes(), &har); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if entry := har.Log.Entries[0]; entry.Request.URL != "ws://example.com/chat" || entry.Response.Status != 101 {
+		t.Errorf("unexpected handshake: %+v %+v", entry.Request, entry.Response)
+	}
+}
+
+// a recording from a compressing connection holding "hello, hello, hello"
+// as a fragmented compressed message
+func compressedRecording(t *testing.T) []byte {
+	t.Helper()
+
+	var deflated bytes.Buffer
+	fw, _ := flate.NewWriter(&deflated, flate.BestSpeed)
+	fw.Write([]byte("hello, hello, hello"))
+	fw.Flush()
+	payload := bytes.TrimSuffix(deflated.Bytes(), []byte{0x00, 0x00, 0xff, 0xff})
+
+	var buf bytes.Buffer
+	rec, err := NewRecorder(&buf)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	serverConn, _ := net.Pipe()
+	rec.Attach(&crocsoc.WSConn{Conn: serverConn, Compression: true})
+
+	rec.write(Inbound, &crocsoc.Frame{Rsv: 0x4, Opcode: 0x1, Payload: payload[:2]})
+	rec.write(Inbound, &crocsoc.Frame{Fin: true, Opcode: 0x0, Payload: payload[2:]})
+	rec.Flush()
+	return buf.Bytes()
+}
+
+func TestReplayCompressed(t *testing.T) {
+	r := Replay(bytes.NewReader(compressedRecording(t)), crocsoc.RoleServer, 0)
+
+	if !r.Conn.Compression {
+		t.Errorf("replayed conn not compressing")
+	}
+
+	var got []string
+	for msg, err := range r.Conn.Messages() {
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		got = append(got, string(msg.Data))
+	}
+	if err := r.Wait(); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	if len(got) != 1 || got[0] != "hello, hello, hello" {
+		t.Errorf("unexpected replayed messages: %q", got)
+	}
+}
+
+func TestExportHARCompressed(t *testing.T) {
+	var out bytes.Buffer
+	if err := ExportHAR(&out, bytes.NewReader(compressedRecording(t))); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	var har harLog
+	if err := json.Unmarshal(out.Bytes(), &har); err != nil {
+		t.Fatalf("%v", err)
+	}
+	msgs := har.Log.Entries[0].Messages
+	if len(msgs) != 1 || msgs[0].Data != "hello, hello, hello" {
+		t.Errorf("want: inflated message, got: %+v", msgs)
+	}
+}
+
+func TestRecordHandshakeCompression(t *testing.T) {
+	var buf bytes.Buffer
+	rec, err := NewRecorder(&buf)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	req, _ := http.NewRequest("GET", "/", nil)
+	rec.RecordHandshake(crocsoc.RoleServer, req, &http.Response{
+		StatusCode: http.StatusSwitchingProtocols,
+		Header:     http.Header{"Sec-Websocket-Extensions": {"permessage-deflate; server_no_context_takeover"}},
+	})
+	rec.Flush()
+
+	rd, err := NewReader(&buf)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if !rd.Compression {
+		t.Errorf("negotiated compression not recorded")
+	}
+}
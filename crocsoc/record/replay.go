@@ -0,0 +1,114 @@
+package record
+
+import (
+	"errors"
+	"io"
+	"net"
+	"time"
+
+	"github.com/pgxtips/crocsoc/crocsoc"
+)
+
+// Feeds the inbound frames of a recording to a WSConn, standing in for the
+// original peer.
+type Replayer struct {
+	// reads the recorded inbound frames; whatever it writes is discarded
+	Conn *crocsoc.WSConn
+
+	done chan struct{}
+	err  error
+}
+
+// Starts replaying src into a new WSConn with the given role, which should
+// match the role of the recorded connection. Frames are delivered at their
+// recorded offsets divided by speed, or as fast as possible when speed is 0.
+// The WSConn has Compression set when the recording negotiated it.
+func Replay(src io.Reader, role crocsoc.Role, speed float64) *Replayer {
+	local, remote := net.Pipe()
+
+	peerRole := crocsoc.RoleClient
+	if role == crocsoc.RoleClient {
+		peerRole = crocsoc.RoleServer
+	}
+
+	r := &Replayer{
+		Conn: &crocsoc.WSConn{Conn: local, Role: role},
+		done: make(chan struct{}),
+	}
+	peer := &crocsoc.WSConn{Conn: remote, Role: peerRole}
+
+	// read up front, so Compression is set before the caller reads. The
+	// peer stays uncompressed: recorded fragments are sent as captured and
+	// whole messages were captured inflated
+	rd, err := NewReader(src)
+	if err == nil {
+		r.Conn.Compression = rd.Compression
+	}
+
+	// the replayed side has nobody to talk to
+	drained := make(chan struct{})
+	go func() {
+		io.Copy(io.Discard, remote)
+		close(drained)
+	}()
+
+	go func() {
+		defer close(r.done)
+
+		closing := false
+		if err == nil {
+			closing, err = feed(rd, peer, speed)
+		}
+		r.err = err
+
+		// a replayed close handshake ends with the replayed side hanging
+		// up, otherwise hang up on it so it sees the recording end
+		if !closing {
+			remote.Close()
+		}
+		<-drained
+	}()
+
+	return r
+}
+
+// Blocks until the recording has been fed through, returning any error
+// reading it.
+func (r *Replayer) Wait() error {
+	<-r.done
+	return r.err
+}
+
+// writes the inbound records of rd through peer, reporting whether a close
+// frame was among them
+func feed(rd *Reader, peer *crocsoc.WSConn, speed float64) (bool, error) {
+	start := time.Now()
+
+	for {
+		rec, err := rd.Next()
+		if errors.Is(err, io.EOF) {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+
+		if rec.Direction != Inbound || rec.Handshake {
+			continue
+		}
+
+		if speed > 0 {
+			at := start.Add(time.Duration(float64(rec.Offset) / speed))
+			time.Sleep(time.Until(at))
+		}
+
+		// the replayed conn already hung up
+		if err := peer.WriteFrame(&rec.Frame); err != nil {
+			return true, nil
+		}
+
+		if rec.Frame.Opcode == 0x8 {
+			return true, nil
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package crocsoc
+
+import (
+	"errors"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+A server shedding load tells clients when to come back, so they don't all
+retry at once: a Retry-After header on 429/503 upgrade rejections, and a
+structured reason with retryAfter on 1013 Try Again Later closes.
+*/
+
+// longest wait suggested to clients
+const maxRetryAfter = 30 * time.Second
+
+// Returns how long clients should wait before reconnecting, from current
+// handshake load: a second for each multiple of SetMaxPendingHandshakes's
+// limit in flight, at least 1s and at most 30s.
+func SuggestedRetryAfter() time.Duration {
+	limit := maxPendingHandshakes.Load()
+	if limit <= 0 {
+		return time.Second
+	}
+
+	d := time.Duration(pendingHandshakes.Load()/limit) * time.Second
+	return min(max(d, time.Second), maxRetryAfter)
+}
+
+// writes the Retry-After header, which only has whole seconds
+func setRetryAfter(h http.Header, d time.Duration) {
+	secs := int((d + time.Second - 1) / time.Second)
+	h.Set("Retry-After", strconv.Itoa(secs))
+}
+
+/*
+1013 indicates that the service is experiencing overload and the client
+should try again later.
+*/
+
+// Closes with 1013 Try Again Later, telling the client to wait after
+// before reconnecting, e.g. while draining for a deploy. Keep reading to
+// complete the close handshake.
+func (c *WSConn) CloseTryAgainLater(after time.Duration) error {
+	return c.CloseWithReason(1013, CloseReason{
+		Code:       "try_again_later",
+		RetryAfter: int((after + time.Second - 1) / time.Second),
+	})
+}
+
+// Parses a Retry-After header value, either seconds or an HTTP date.
+func ParseRetryAfter(v string) (time.Duration, bool) {
+	v = strings.TrimSpace(v)
+	if v == "" {
+		return 0, false
+	}
+
+	if secs, err := strconv.Atoi(v); err == nil {
+		if secs < 0 {
+			return 0, false
+		}
+		return time.Duration(secs) * time.Second, true
+	}
+
+	t, err := http.ParseTime(v)
+	if err != nil {
+		return 0, false
+	}
+	return max(time.Until(t), 0), true
+}
+
+// Returns the wait the server asked for when err is a rejected upgrade
+// (*HandshakeError) or a close (*CloseError) carrying a retry hint.
+func RetryAfterHint(err error) (time.Duration, bool) {
+	var herr *HandshakeError
+	if errors.As(err, &herr) && herr.RetryAfter > 0 {
+		return herr.RetryAfter, true
+	}
+
+	var cerr *CloseError
+	if errors.As(err, &cerr) {
+		if r, ok := ParseCloseReason(cerr.Reason); ok && r.RetryAfter > 0 {
+			return time.Duration(r.RetryAfter) * time.Second, true
+		}
+	}
+
+	return 0, false
+}
+
+// Returns how long a client should wait before reconnect attempt number
+// attempt (from 0) after failing with err: exponential backoff from 500ms
+// up to 30s with full jitter, but never sooner than the server's hint.
+func ReconnectDelay(attempt int, err error) time.Duration {
+	backoff := maxRetryAfter
+	if attempt < 6 {
+		backoff = min(500*time.Millisecond<<attempt, maxRetryAfter)
+	}
+	d := rand.N(backoff + 1)
+
+	if hint, ok := RetryAfterHint(err); ok {
+		// spread clients told the same wait over the following second
+		d = max(d, hint+rand.N(time.Second))
+	}
+	return d
+}
@@ -0,0 +1,91 @@
+package crocsoc
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSuggestedRetryAfter(t *testing.T) {
+	SetMaxPendingHandshakes(2)
+	defer SetMaxPendingHandshakes(0)
+
+	for range 6 {
+		beginHandshake()
+	}
+	defer func() {
+		for range 6 {
+			endHandshake()
+		}
+	}()
+
+	if got := SuggestedRetryAfter(); got != 3*time.Second {
+		t.Errorf("want: 3s, got: %v", got)
+	}
+
+	w := httptest.NewRecorder()
+	WsHandler(w, buildRequest(nil))
+	if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") != "3" {
+		t.Errorf("want: 503 with Retry-After 3, got: %d %q", w.Code, w.Header().Get("Retry-After"))
+	}
+
+	// the client reads it back
+	resp := w.Result()
+	err := ValidateResponse(resp, GenerateKey())
+	if hint, ok := RetryAfterHint(err); !ok || hint != 3*time.Second {
+		t.Errorf("want: 3s, got: %v %v", hint, ok)
+	}
+	if d := ReconnectDelay(0, err); d < 3*time.Second || d > 4*time.Second {
+		t.Errorf("delay %v ignores the hint", d)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	tests := []struct {
+		in   string
+		want time.Duration
+		ok   bool
+	}{
+		{"120", 2 * time.Minute, true},
+		{" 0 ", 0, true},
+		{"-1", 0, false},
+		{"soon", 0, false},
+		{"Wed, 21 Oct 2015 07:28:00 GMT", 0, true},
+	}
+
+	for _, tt := range tests {
+		got, ok := ParseRetryAfter(tt.in)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("%q: want: %v %v, got: %v %v", tt.in, tt.want, tt.ok, got, ok)
+		}
+	}
+}
+
+func TestCloseTryAgainLater(t *testing.T) {
+	serverConn, clientConn := net.Pipe()
+	defer serverConn.Close()
+	defer clientConn.Close()
+
+	server := &WSConn{Conn: serverConn}
+	go server.CloseTryAgainLater(1500 * time.Millisecond)
+
+	f, err := readFrame(clientConn)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	cerr := &CloseError{Code: 1013, Reason: string(f.Payload[2:])}
+	if hint, ok := RetryAfterHint(cerr); !ok || hint != 2*time.Second {
+		t.Errorf("want: 2s, got: %v %v", hint, ok)
+	}
+}
+
+func TestReconnectDelay(t *testing.T) {
+	for attempt := range 10 {
+		if d := ReconnectDelay(attempt, nil); d < 0 || d > maxRetryAfter {
+			t.Errorf("attempt %d: delay %v out of range", attempt, d)
+		}
+	}
+}
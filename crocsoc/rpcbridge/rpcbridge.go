@@ -0,0 +1,177 @@
+// Package rpcbridge tunnels Connect and gRPC-Web streaming RPCs through a
+// WebSocket, one RPC per connection, for browsers behind proxies that only
+// let WebSocket through. The RPC is served by an ordinary http.Handler, such
+// as one generated by connect-go, so bidi streaming works without HTTP/2
+// from the browser.
+//
+// Client to server:
+//
+//	text    {"procedure":"/acme.chat.v1.ChatService/Chat","header":{"Content-Type":["application/connect+proto"]}}
+//	binary  request body bytes, the protocol's enveloped messages as is
+//	text    {"end":true}, half-closes the request body
+//
+// Server to client:
+//
+//	text    {"status":200,"header":{...}}, before any body bytes
+//	binary  response body bytes, one message per handler write
+//	close   1000 once the handler returns
+//
+// Only protocols carrying their trailers in the body work, i.e. Connect
+// streaming and gRPC-Web, not gRPC over HTTP/2.
+package rpcbridge
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+
+	"github.com/pgxtips/crocsoc/crocsoc"
+)
+
+// First message from the client, naming the RPC.
+type Open struct {
+	Procedure string      `json:"procedure"`
+	Header    http.Header `json:"header,omitempty"`
+}
+
+// Sent before the response body.
+type ResponseHead struct {
+	Status int         `json:"status"`
+	Header http.Header `json:"header,omitempty"`
+}
+
+// the client's half-close
+type end struct {
+	End bool `json:"end"`
+}
+
+// Serves tunnelled RPCs with Handler.
+type Bridge struct {
+	Handler http.Handler
+}
+
+// Serves the one RPC tunnelled through conn, returning once the handler has
+// returned and the connection is closed.
+func (b *Bridge) Serve(ctx context.Context, conn *crocsoc.WSConn) error {
+	open, err := crocsoc.Typed[Open](conn, crocsoc.JSONCodec).Receive()
+	if err != nil {
+		return fmt.Errorf("failed to read open message: %v", err)
+	}
+
+	if open.Procedure == "" || open.Procedure[0] != '/' {
+		conn.SendCloseFrame(1008, "invalid procedure")
+		return fmt.Errorf("invalid procedure %q", open.Procedure)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	body, bodyW := io.Pipe()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, open.Procedure, body)
+	if err != nil {
+		conn.SendCloseFrame(1008, "invalid procedure")
+		return err
+	}
+	if open.Header != nil {
+		req.Header = open.Header
+	}
+	req.ContentLength = -1
+	req.RemoteAddr = conn.Conn.RemoteAddr().String()
+
+	// feeds the request body until the client half-closes, cancelling the
+	// RPC if the client goes away first
+	read := make(chan struct{})
+	go func() {
+		defer close(read)
+
+		for msg, err := range conn.Messages() {
+			if err != nil {
+				bodyW.CloseWithError(err)
+				cancel()
+				return
+			}
+
+			if msg.Opcode == 0x1 {
+				var e end
+				if json.Unmarshal(msg.Data, &e) == nil && e.End {
+					bodyW.Close()
+				}
+				continue
+			}
+
+			if _, err := bodyW.Write(msg.Data); err != nil {
+				// handler stopped reading the body, drop the rest
+				continue
+			}
+		}
+
+		// closed before half-closing
+		bodyW.CloseWithError(io.ErrUnexpectedEOF)
+		cancel()
+	}()
+
+	w := &responseWriter{conn: conn, header: http.Header{}}
+	b.Handler.ServeHTTP(w, req)
+
+	werr := w.writeHead()
+	body.Close()
+
+	// the client answers, ending the read loop
+	conn.SendCloseFrame(1000, "")
+	<-read
+
+	return werr
+}
+
+// sends everything the handler writes as binary messages
+type responseWriter struct {
+	conn   *crocsoc.WSConn
+	header http.Header
+
+	mu     sync.Mutex
+	status int
+	err    error
+}
+
+func (w *responseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *responseWriter) WriteHeader(status int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.status == 0 {
+		w.status = status
+		w.err = crocsoc.Typed[ResponseHead](w.conn, crocsoc.JSONCodec).Send(ResponseHead{Status: status, Header: w.header.Clone()})
+	}
+}
+
+func (w *responseWriter) Write(p []byte) (int, error) {
+	if err := w.writeHead(); err != nil {
+		return 0, err
+	}
+
+	if err := w.conn.SendBinaryFrame(p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// every write is sent straight away
+func (w *responseWriter) Flush() {}
+
+func (w *responseWriter) writeHead() error {
+	w.WriteHeader(http.StatusOK)
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.err != nil {
+		return fmt.Errorf("failed to send response head: %v", w.err)
+	}
+	return nil
+}
@@ -0,0 +1,108 @@
+package rpcbridge
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+
+	"github.com/pgxtips/crocsoc/crocsoc"
+)
+
+// streams each chunk of the request body straight back, as a bidi RPC would
+func echoHandler(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/echo.v1.EchoService/Echo" || r.Header.Get("Content-Type") != "application/connect+proto" {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/connect+proto")
+	w.WriteHeader(http.StatusOK)
+
+	buf := make([]byte, 64)
+	for {
+		n, err := r.Body.Read(buf)
+		if n > 0 {
+			w.Write(buf[:n])
+			w.(http.Flusher).Flush()
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func TestBridge(t *testing.T) {
+	serverConn, clientConn := net.Pipe()
+	defer serverConn.Close()
+	defer clientConn.Close()
+
+	bridge := &Bridge{Handler: http.HandlerFunc(echoHandler)}
+
+	served := make(chan error)
+	go func() {
+		served <- bridge.Serve(context.Background(), &crocsoc.WSConn{Conn: serverConn})
+	}()
+
+	client := &crocsoc.WSConn{Conn: clientConn, Role: crocsoc.RoleClient}
+
+	go func() {
+		crocsoc.Typed[Open](client, crocsoc.JSONCodec).Send(Open{
+			Procedure: "/echo.v1.EchoService/Echo",
+			Header:    http.Header{"Content-Type": {"application/connect+proto"}},
+		})
+
+		client.SendBinaryFrame([]byte("first"))
+		client.SendBinaryFrame([]byte("second"))
+		client.SendTextFrame([]byte(`{"end":true}`))
+	}()
+
+	head, err := crocsoc.Typed[ResponseHead](client, crocsoc.JSONCodec).Receive()
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if head.Status != http.StatusOK || head.Header.Get("Content-Type") != "application/connect+proto" {
+		t.Errorf("unexpected head: %+v", head)
+	}
+
+	var got []string
+	for {
+		msg, err := client.ReadMessage()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		got = append(got, string(msg))
+	}
+
+	if len(got) != 2 || got[0] != "first" || got[1] != "second" {
+		t.Errorf("want: [first second], got: %q", got)
+	}
+
+	if err := <-served; err != nil {
+		t.Errorf("%v", err)
+	}
+}
+
+func TestBridgeNotFound(t *testing.T) {
+	serverConn, clientConn := net.Pipe()
+	defer serverConn.Close()
+	defer clientConn.Close()
+
+	bridge := &Bridge{Handler: http.HandlerFunc(echoHandler)}
+	go bridge.Serve(context.Background(), &crocsoc.WSConn{Conn: serverConn})
+
+	client := &crocsoc.WSConn{Conn: clientConn, Role: crocsoc.RoleClient}
+	go crocsoc.Typed[Open](client, crocsoc.JSONCodec).Send(Open{Procedure: "/nope"})
+
+	head, err := crocsoc.Typed[ResponseHead](client, crocsoc.JSONCodec).Receive()
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if head.Status != http.StatusNotFound {
+		t.Errorf("want: 404, got: %d", head.Status)
+	}
+}
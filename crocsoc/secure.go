@@ -0,0 +1,95 @@
+package crocsoc
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+/*
+Behind a TLS terminating proxy every upgrade arrives over plain http, so
+r.TLS alone can't tell wss:// from ws://. Proxies pass the original scheme
+in X-Forwarded-Proto, which is only believed when the request comes from a
+proxy registered with SetTrustedProxies, as any client can send the header.
+*/
+
+// proxies whose X-Forwarded-Proto is believed, none unless SetTrustedProxies
+// is called
+var trustedProxies []netip.Prefix
+
+// set by RequireSecure
+var requireSecure bool
+
+// Sets the proxies, as CIDR prefixes or bare addresses, whose
+// X-Forwarded-Proto header is trusted. Must be called before any
+// connections are served.
+func SetTrustedProxies(proxies ...string) error {
+	prefixes := make([]netip.Prefix, 0, len(proxies))
+
+	for _, p := range proxies {
+		if !strings.Contains(p, "/") {
+			addr, err := netip.ParseAddr(p)
+			if err != nil {
+				return fmt.Errorf("invalid trusted proxy %q: %v", p, err)
+			}
+			prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+
+		prefix, err := netip.ParsePrefix(p)
+		if err != nil {
+			return fmt.Errorf("invalid trusted proxy %q: %v", p, err)
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+
+	trustedProxies = prefixes
+	return nil
+}
+
+// When on, WsHandler rejects upgrades that did not arrive over TLS (wss://)
+// with 403 Forbidden. Must be called before any connections are served.
+func RequireSecure(on bool) {
+	requireSecure = on
+}
+
+// Reports whether the client's original connection was secure, either TLS
+// to this server or https/wss according to a trusted proxy.
+func IsSecure(r *http.Request) bool {
+	if r.TLS != nil {
+		return true
+	}
+
+	proto := r.Header.Get("X-Forwarded-Proto")
+	if proto == "" || !fromTrustedProxy(r) {
+		return false
+	}
+
+	// proxies append to the list, the first entry is the client's scheme
+	proto, _, _ = strings.Cut(proto, ",")
+	proto = strings.ToLower(strings.TrimSpace(proto))
+
+	return proto == "https" || proto == "wss"
+}
+
+func fromTrustedProxy(r *http.Request) bool {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+
+	for _, p := range trustedProxies {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
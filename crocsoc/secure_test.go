@@ -0,0 +1,73 @@
+package crocsoc
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestIsSecure(t *testing.T) {
+	if err := SetTrustedProxies("10.0.0.0/8", "::1"); err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer SetTrustedProxies()
+
+	tests := []struct {
+		remote string
+		proto  string
+		tls    bool
+		want   bool
+	}{
+		{"192.0.2.1:1234", "", false, false},
+		{"192.0.2.1:1234", "", true, true},
+		// untrusted clients can't claim https
+		{"192.0.2.1:1234", "https", false, false},
+		{"10.1.2.3:1234", "https", false, true},
+		{"10.1.2.3:1234", "wss", false, true},
+		{"10.1.2.3:1234", "http", false, false},
+		{"[::1]:1234", "HTTPS", false, true},
+		{"[::ffff:10.1.2.3]:1234", "https", false, true},
+		// first entry is the client's scheme
+		{"10.1.2.3:1234", "http, https", false, false},
+		{"10.1.2.3:1234", "https, http", false, true},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = tt.remote
+		if tt.proto != "" {
+			r.Header.Set("X-Forwarded-Proto", tt.proto)
+		}
+		if tt.tls {
+			r.TLS = &tls.ConnectionState{}
+		}
+
+		if got := IsSecure(r); got != tt.want {
+			t.Errorf("%s %q tls=%v: want: %v, got: %v", tt.remote, tt.proto, tt.tls, tt.want, got)
+		}
+	}
+
+	if err := SetTrustedProxies("not an address"); err == nil {
+		t.Errorf("invalid proxy accepted")
+	}
+}
+
+func TestRequireSecure(t *testing.T) {
+	RequireSecure(true)
+	defer RequireSecure(false)
+
+	r := buildRequest(map[string]string{
+		"Upgrade":               "websocket",
+		"Connection":            "Upgrade",
+		"Sec-WebSocket-Key":     "dGhlIHNhbXBsZSBub25jZQ==",
+		"Sec-WebSocket-Version": "13",
+	})
+	w := httptest.NewRecorder()
+
+	WsHandler(w, r)
+
+	if w.Code != http.StatusForbidden {
+		t.Errorf("want: %d, got: %d", http.StatusForbidden, w.Code)
+	}
+}
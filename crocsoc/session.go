@@ -0,0 +1,159 @@
+package crocsoc
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+)
+
+// Returned by Session.Next after Close.
+var ErrSessionClosed = errors.New("session closed")
+
+// Merges the inbound messages of one user's connections, e.g. a browser
+// tab each, into a single ordered stream, so the application handles a
+// user's actions one at a time whichever tab they came from.
+//
+//	sess := crocsoc.NewSession()
+//	sess.Add(conn, r.URL.Query().Get("tab"))
+//	for {
+//		msg, err := sess.Next(ctx)
+//		...
+//	}
+//
+// Messages are ordered by when the session received them; each tab's own
+// messages stay in the order it sent them.
+type Session struct {
+	out  chan SessionMessage
+	done chan struct{}
+	once sync.Once
+
+	// guards seq and sending to out, so seq follows delivery order
+	sendMu sync.Mutex
+	seq    uint64
+
+	mu sync.Mutex
+	// in the order they were added
+	tabs []sessionTab
+
+	// set by ElectPrimary
+	election *election
+}
+
+type sessionTab struct {
+	id   string
+	conn *WSConn
+}
+
+// A message from one of a session's connections.
+type SessionMessage struct {
+	Message
+	// position in the session's stream, from 1
+	Seq uint64
+	// the tab id the connection was added with
+	Tab  string
+	Conn *WSConn
+}
+
+func NewSession() *Session {
+	return &Session{
+		out:  make(chan SessionMessage),
+		done: make(chan struct{}),
+	}
+}
+
+// Starts merging conn's messages into the session, tagged with tab. conn
+// leaves the session when it closes.
+func (s *Session) Add(conn *WSConn, tab string) {
+	s.mu.Lock()
+	s.tabs = append(s.tabs, sessionTab{id: tab, conn: conn})
+	s.mu.Unlock()
+
+	s.announce(conn)
+
+	go s.read(conn, tab)
+}
+
+// Returns the next message from any of the session's connections.
+func (s *Session) Next(ctx context.Context) (SessionMessage, error) {
+	select {
+	case msg := <-s.out:
+		return msg, nil
+	case <-s.done:
+		return SessionMessage{}, ErrSessionClosed
+	case <-ctx.Done():
+		return SessionMessage{}, ctx.Err()
+	}
+}
+
+// Returns the tabs of connections still in the session, oldest first.
+func (s *Session) Tabs() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ids := make([]string, len(s.tabs))
+	for i, t := range s.tabs {
+		ids[i] = t.id
+	}
+	return ids
+}
+
+// Stops the session, closing its connections with 1000. Messages not yet
+// returned by Next are dropped.
+func (s *Session) Close() {
+	s.once.Do(func() {
+		close(s.done)
+
+		s.mu.Lock()
+		tabs := slices.Clone(s.tabs)
+		s.mu.Unlock()
+
+		// each read loop ends on the peer's close reply, or right away if
+		// it's waiting to deliver. A peer that stopped reading mustn't
+		// hold up Close
+		for _, t := range tabs {
+			go t.conn.SendCloseFrame(1000, "")
+		}
+	})
+}
+
+func (s *Session) read(conn *WSConn, tab string) {
+	defer s.remove(conn)
+
+	for msg, err := range conn.Messages() {
+		if err != nil {
+			return
+		}
+		if !s.deliver(SessionMessage{Message: msg, Tab: tab, Conn: conn}) {
+			return
+		}
+	}
+}
+
+func (s *Session) deliver(msg SessionMessage) bool {
+	s.sendMu.Lock()
+	defer s.sendMu.Unlock()
+
+	msg.Seq = s.seq + 1
+
+	select {
+	case s.out <- msg:
+		s.seq++
+		return true
+	case <-s.done:
+		return false
+	}
+}
+
+func (s *Session) remove(conn *WSConn) {
+	s.mu.Lock()
+	for i, t := range s.tabs {
+		if t.conn == conn {
+			s.tabs = append(s.tabs[:i], s.tabs[i+1:]...)
+			break
+		}
+	}
+	s.mu.Unlock()
+
+	s.announce(nil)
+}
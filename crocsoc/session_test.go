@@ -0,0 +1,101 @@
+package crocsoc
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+	"slices"
+	"testing"
+	"time"
+)
+
+// adds a connection to sess, returning the client end
+func addTab(t *testing.T, sess *Session, tab string) *WSConn {
+	t.Helper()
+
+	serverConn, clientConn := net.Pipe()
+	t.Cleanup(func() {
+		serverConn.Close()
+		clientConn.Close()
+	})
+
+	sess.Add(&WSConn{Conn: serverConn}, tab)
+	return &WSConn{Conn: clientConn, Role: RoleClient}
+}
+
+func TestSession(t *testing.T) {
+	sess := NewSession()
+	defer sess.Close()
+
+	a := addTab(t, sess, "a")
+	b := addTab(t, sess, "b")
+
+	if tabs := sess.Tabs(); !slices.Equal(tabs, []string{"a", "b"}) {
+		t.Errorf("want: [a b], got: %v", tabs)
+	}
+
+	go func() {
+		a.SendTextFrame([]byte("a1"))
+		a.SendTextFrame([]byte("a2"))
+	}()
+	go b.SendTextFrame([]byte("b1"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var fromA []string
+	for want := uint64(1); want <= 3; want++ {
+		msg, err := sess.Next(ctx)
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		if msg.Seq != want {
+			t.Errorf("want: seq %d, got: %d", want, msg.Seq)
+		}
+		if string(msg.Data[:1]) != msg.Tab {
+			t.Errorf("message %s tagged %s", msg.Data, msg.Tab)
+		}
+		if msg.Tab == "a" {
+			fromA = append(fromA, string(msg.Data))
+		}
+	}
+
+	if !slices.Equal(fromA, []string{"a1", "a2"}) {
+		t.Errorf("tab order lost: %v", fromA)
+	}
+
+	// a tab leaving
+	go a.ReadMessage()
+	a.SendCloseFrame(1000, "")
+	for slices.Contains(sess.Tabs(), "a") {
+		time.Sleep(time.Millisecond)
+	}
+
+	sess.Close()
+	if _, err := sess.Next(ctx); !errors.Is(err, ErrSessionClosed) {
+		t.Errorf("want: ErrSessionClosed, got: %v", err)
+	}
+}
+
+func TestSessionCloseClosesConns(t *testing.T) {
+	sess := NewSession()
+	a := addTab(t, sess, "a")
+
+	closed := make(chan *Frame, 1)
+	go func() {
+		f, _ := readFrame(a.Conn)
+		closed <- f
+	}()
+
+	sess.Close()
+
+	select {
+	case f := <-closed:
+		if f == nil || f.Opcode != 0x8 || binary.BigEndian.Uint16(f.Payload) != 1000 {
+			t.Errorf("want: 1000 close, got: %+v", f)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("client never received the close")
+	}
+}
@@ -0,0 +1,251 @@
+// Package signalr implements the server side of the SignalR JSON hub
+// protocol over a crocsoc connection, so .NET and JavaScript SignalR clients
+// can talk to a Go backend. Only the WebSockets transport is supported, and
+// clients must skip the negotiate request:
+//
+//	new signalR.HubConnectionBuilder()
+//		.withUrl(url, { skipNegotiation: true, transport: signalR.HttpTransportType.WebSockets })
+//
+// Streaming invocations are refused with an error completion.
+//
+//	hub, err := signalr.Accept(conn, signalr.Options{})
+//	for {
+//		inv, err := hub.Next()
+//		...
+//		hub.Complete(inv, result, nil)
+//	}
+package signalr
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/pgxtips/crocsoc/crocsoc"
+)
+
+/*
+Every message is a JSON object followed by the record separator 0x1E, and
+one WebSocket message may carry several:
+
+	client -> {"protocol":"json","version":1}␞
+	server -> {}␞
+	client -> {"type":1,"invocationId":"0","target":"Send","arguments":["hi"]}␞
+	server -> {"type":3,"invocationId":"0","result":"ok"}␞
+	server -> {"type":6}␞
+
+Message types: 1 Invocation, 2 StreamItem, 3 Completion, 4 StreamInvocation,
+5 CancelInvocation, 6 Ping, 7 Close.
+*/
+
+const recordSeparator = 0x1E
+
+const (
+	typeInvocation       = 1
+	typeStreamItem       = 2
+	typeCompletion       = 3
+	typeStreamInvocation = 4
+	typeCancelInvocation = 5
+	typePing             = 6
+	typeClose            = 7
+)
+
+type Options struct {
+	// how often the server pings to keep the client's server timeout from
+	// firing, 15s by default as in ASP.NET Core
+	KeepAliveInterval time.Duration
+}
+
+// A hub method call from the client.
+type Invocation struct {
+	// empty for fire and forget calls, which get no completion
+	InvocationID string
+	Target       string
+	Arguments    []json.RawMessage
+}
+
+// A SignalR client connection.
+type Conn struct {
+	Conn *crocsoc.WSConn
+
+	// records read but not yet returned
+	pending [][]byte
+
+	done chan struct{}
+	once sync.Once
+}
+
+type message struct {
+	Type         int               `json:"type"`
+	InvocationID string            `json:"invocationId,omitempty"`
+	Target       string            `json:"target,omitempty"`
+	Arguments    []json.RawMessage `json:"arguments,omitempty"`
+	Result       any               `json:"result,omitempty"`
+	Error        string            `json:"error,omitempty"`
+}
+
+type handshakeRequest struct {
+	Protocol string `json:"protocol"`
+	Version  int    `json:"version"`
+}
+
+// Completes the SignalR handshake on conn and starts keep-alive pings.
+func Accept(conn *crocsoc.WSConn, opts Options) (*Conn, error) {
+	if opts.KeepAliveInterval <= 0 {
+		opts.KeepAliveInterval = 15 * time.Second
+	}
+
+	c := &Conn{Conn: conn, done: make(chan struct{})}
+
+	raw, err := c.nextRecord()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read handshake: %v", err)
+	}
+
+	var req handshakeRequest
+	if err := json.Unmarshal(raw, &req); err != nil {
+		return nil, fmt.Errorf("malformed handshake: %v", err)
+	}
+
+	if req.Protocol != "json" || req.Version != 1 {
+		msg := fmt.Sprintf("unsupported protocol %s version %d", req.Protocol, req.Version)
+		c.writeRecord(map[string]string{"error": msg})
+		conn.SendCloseFrame(1002, "")
+		return nil, fmt.Errorf("signalr: %s", msg)
+	}
+
+	if err := c.writeRecord(struct{}{}); err != nil {
+		return nil, err
+	}
+
+	go c.keepAlive(opts.KeepAliveInterval)
+
+	return c, nil
+}
+
+// Reads until the client invokes a hub method. Returns io.EOF once the
+// client has closed the connection.
+func (c *Conn) Next() (Invocation, error) {
+	for {
+		raw, err := c.nextRecord()
+		if err != nil {
+			c.stop()
+			return Invocation{}, err
+		}
+
+		var msg message
+		if err := json.Unmarshal(raw, &msg); err != nil {
+			return Invocation{}, fmt.Errorf("malformed message: %v", err)
+		}
+
+		switch msg.Type {
+		case typeInvocation:
+			return Invocation{InvocationID: msg.InvocationID, Target: msg.Target, Arguments: msg.Arguments}, nil
+		case typeStreamInvocation:
+			c.writeRecord(message{Type: typeCompletion, InvocationID: msg.InvocationID, Error: "streaming is not supported"})
+		case typeClose:
+			c.stop()
+			c.Conn.SendCloseFrame(1000, "")
+
+			// wait for the close reply, anything else is too late
+			for {
+				if _, err := c.Conn.ReadMessage(); err != nil {
+					return Invocation{}, io.EOF
+				}
+			}
+		}
+		// pings, cancels and stream items need no answer
+	}
+}
+
+// Sends the result of inv, or err as the failure. Fire and forget
+// invocations are not completed.
+func (c *Conn) Complete(inv Invocation, result any, err error) error {
+	if inv.InvocationID == "" {
+		return nil
+	}
+
+	msg := message{Type: typeCompletion, InvocationID: inv.InvocationID, Result: result}
+	if err != nil {
+		msg = message{Type: typeCompletion, InvocationID: inv.InvocationID, Error: err.Error()}
+	}
+	return c.writeRecord(msg)
+}
+
+// Invokes the client method target, e.g. one registered with
+// connection.on("ReceiveMessage", ...).
+func (c *Conn) Send(target string, args ...any) error {
+	raw := make([]json.RawMessage, len(args))
+	for i, a := range args {
+		b, err := json.Marshal(a)
+		if err != nil {
+			return fmt.Errorf("failed to encode argument: %v", err)
+		}
+		raw[i] = b
+	}
+
+	// clients expect an arguments array even when empty
+	return c.writeRecord(struct {
+		Type      int               `json:"type"`
+		Target    string            `json:"target"`
+		Arguments []json.RawMessage `json:"arguments"`
+	}{typeInvocation, target, raw})
+}
+
+// Sends a close message, with errMsg shown to the client when set, and
+// starts the close handshake. Keep calling Next to complete it.
+func (c *Conn) Close(errMsg string) error {
+	c.stop()
+	c.writeRecord(message{Type: typeClose, Error: errMsg})
+	return c.Conn.SendCloseFrame(1000, "")
+}
+
+func (c *Conn) nextRecord() ([]byte, error) {
+	for len(c.pending) == 0 {
+		msg, err := c.Conn.ReadMessage()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, rec := range bytes.Split(msg, []byte{recordSeparator}) {
+			if len(rec) > 0 {
+				c.pending = append(c.pending, rec)
+			}
+		}
+	}
+
+	rec := c.pending[0]
+	c.pending = c.pending[1:]
+	return rec, nil
+}
+
+func (c *Conn) writeRecord(v any) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("failed to encode message: %v", err)
+	}
+	return c.Conn.SendTextFrame(append(b, recordSeparator))
+}
+
+func (c *Conn) keepAlive(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-ticker.C:
+			if err := c.writeRecord(message{Type: typePing}); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func (c *Conn) stop() {
+	c.once.Do(func() { close(c.done) })
+}
@@ -0,0 +1,118 @@
+package signalr
+
+import (
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/pgxtips/crocsoc/crocsoc"
+)
+
+func readText(t *testing.T, c *crocsoc.WSConn) string {
+	t.Helper()
+
+	msg, err := c.ReadMessage()
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	return string(msg)
+}
+
+func TestHub(t *testing.T) {
+	serverConn, clientConn := net.Pipe()
+	defer serverConn.Close()
+	defer clientConn.Close()
+
+	hubs := make(chan *Conn, 1)
+	invs := make(chan Invocation)
+	go func() {
+		hub, err := Accept(&crocsoc.WSConn{Conn: serverConn}, Options{KeepAliveInterval: time.Hour})
+		if err != nil {
+			t.Errorf("%v", err)
+			return
+		}
+		hubs <- hub
+
+		for {
+			inv, err := hub.Next()
+			if err != nil {
+				close(invs)
+				return
+			}
+			invs <- inv
+		}
+	}()
+
+	client := &crocsoc.WSConn{Conn: clientConn, Role: crocsoc.RoleClient}
+
+	client.SendTextFrame([]byte("{\"protocol\":\"json\",\"version\":1}\x1e"))
+	if got := readText(t, client); got != "{}\x1e" {
+		t.Fatalf("want: {}␞, got: %q", got)
+	}
+	hub := <-hubs
+
+	// two records in one message, a ping and an invocation
+	client.SendTextFrame([]byte("{\"type\":6}\x1e{\"type\":1,\"invocationId\":\"7\",\"target\":\"Send\",\"arguments\":[\"hi\"]}\x1e"))
+
+	inv := <-invs
+	if inv.Target != "Send" || inv.InvocationID != "7" || len(inv.Arguments) != 1 || string(inv.Arguments[0]) != `"hi"` {
+		t.Errorf("unexpected invocation: %+v", inv)
+	}
+
+	go hub.Complete(inv, "ok", nil)
+	if got := readText(t, client); got != "{\"type\":3,\"invocationId\":\"7\",\"result\":\"ok\"}\x1e" {
+		t.Errorf("unexpected completion: %q", got)
+	}
+
+	go hub.Complete(inv, nil, errors.New("nope"))
+	if got := readText(t, client); got != "{\"type\":3,\"invocationId\":\"7\",\"error\":\"nope\"}\x1e" {
+		t.Errorf("unexpected completion: %q", got)
+	}
+
+	go hub.Send("ReceiveMessage")
+	if got := readText(t, client); got != "{\"type\":1,\"target\":\"ReceiveMessage\",\"arguments\":[]}\x1e" {
+		t.Errorf("unexpected invocation: %q", got)
+	}
+
+	// streaming is refused
+	client.SendTextFrame([]byte("{\"type\":4,\"invocationId\":\"8\",\"target\":\"Counter\",\"arguments\":[]}\x1e"))
+	if got := readText(t, client); got != "{\"type\":3,\"invocationId\":\"8\",\"error\":\"streaming is not supported\"}\x1e" {
+		t.Errorf("unexpected completion: %q", got)
+	}
+
+	client.SendTextFrame([]byte("{\"type\":7}\x1e"))
+	if _, err := client.ReadMessage(); err != io.EOF {
+		t.Errorf("want: EOF, got: %v", err)
+	}
+	if _, ok := <-invs; ok {
+		t.Errorf("invocation after close")
+	}
+}
+
+func TestHandshakeRejected(t *testing.T) {
+	serverConn, clientConn := net.Pipe()
+	defer serverConn.Close()
+	defer clientConn.Close()
+
+	accepted := make(chan error)
+	go func() {
+		_, err := Accept(&crocsoc.WSConn{Conn: serverConn}, Options{})
+		accepted <- err
+	}()
+
+	client := &crocsoc.WSConn{Conn: clientConn, Role: crocsoc.RoleClient}
+	client.SendTextFrame([]byte("{\"protocol\":\"messagepack\",\"version\":1}\x1e"))
+
+	if got := readText(t, client); got != "{\"error\":\"unsupported protocol messagepack version 1\"}\x1e" {
+		t.Errorf("unexpected handshake response: %q", got)
+	}
+
+	// close frame
+	go client.ReadMessage()
+
+	if err := <-accepted; err == nil {
+		t.Errorf("handshake accepted")
+	}
+}
@@ -0,0 +1,93 @@
+package crocsoc
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+/*
+State snapshots pushed by the server, encoded per connection:
+
+	- gzip compressed JSON in a binary message, when the client announced
+	  CapSnapshotGzip during Negotiate and the snapshot is big enough for
+	  compression to pay off
+	- plain JSON in a text message otherwise
+
+The opcode tells ReceiveSnapshot which one arrived, so clients without the
+capability never see a binary snapshot.
+*/
+
+// Capability a client announces in its Hello to accept compressed snapshots.
+const CapSnapshotGzip = "snapshot-gzip"
+
+// below this many bytes of JSON gzip's overhead outweighs the savings
+const snapshotGzipMin = 1024
+
+// Sends snapshots to one connection in the best encoding it supports.
+type SnapshotSender struct {
+	Conn *WSConn
+	gzip bool
+}
+
+// Picks the snapshot encoding for conn from the capabilities agreed on by
+// Negotiate. The server must announce CapSnapshotGzip in its own Hello too.
+func NewSnapshotSender(conn *WSConn, agreed Agreement) *SnapshotSender {
+	return &SnapshotSender{Conn: conn, gzip: agreed.Has(CapSnapshotGzip)}
+}
+
+func (s *SnapshotSender) Send(v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("failed to encode snapshot: %v", err)
+	}
+
+	if !s.gzip || len(data) < snapshotGzipMin {
+		return s.Conn.SendTextFrame(data)
+	}
+
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Write(data)
+	if err := zw.Close(); err != nil {
+		return fmt.Errorf("failed to compress snapshot: %v", err)
+	}
+
+	return s.Conn.SendBinaryFrame(buf.Bytes())
+}
+
+// Reads the next message as a snapshot sent by SnapshotSender, in either
+// encoding, and decodes it into v.
+func ReceiveSnapshot(conn *WSConn, v any) error {
+	opcode, data, err := conn.readMessage()
+	if err != nil {
+		return err
+	}
+
+	if opcode == 0x2 {
+		zr, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return fmt.Errorf("failed to decompress snapshot: %v", err)
+		}
+
+		// bounded like permessage-deflate, by the read limit or 32MB
+		limit := conn.ReadLimit()
+		if limit <= 0 {
+			limit = maxInflateSize
+		}
+
+		if data, err = io.ReadAll(io.LimitReader(zr, limit+1)); err != nil {
+			return fmt.Errorf("failed to decompress snapshot: %v", err)
+		}
+		if int64(len(data)) > limit {
+			return fmt.Errorf("snapshot decompresses past %d bytes", limit)
+		}
+	}
+
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("failed to decode snapshot: %v", err)
+	}
+	return nil
+}
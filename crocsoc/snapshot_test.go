@@ -0,0 +1,83 @@
+package crocsoc
+
+import (
+	"bytes"
+	"compress/gzip"
+	"net"
+	"slices"
+	"strings"
+	"testing"
+)
+
+type boardSnapshot struct {
+	Cells []string `json:"cells"`
+}
+
+func TestSnapshotEncoding(t *testing.T) {
+	small := boardSnapshot{Cells: []string{"x", "o"}}
+	big := boardSnapshot{Cells: slices.Repeat([]string{strings.Repeat("x", 64)}, 64)}
+
+	tests := []struct {
+		caps   []string
+		snap   boardSnapshot
+		opcode byte
+	}{
+		{nil, big, 0x1},
+		{[]string{CapSnapshotGzip}, big, 0x2},
+		// too small to be worth compressing
+		{[]string{CapSnapshotGzip}, small, 0x1},
+	}
+
+	for _, tt := range tests {
+		serverConn, clientConn := net.Pipe()
+
+		sender := NewSnapshotSender(&WSConn{Conn: serverConn}, Agreement{Capabilities: tt.caps})
+		go sender.Send(tt.snap)
+
+		client := &WSConn{Conn: clientConn, Role: RoleClient}
+
+		// peek at the opcode through an interceptor
+		var opcode byte
+		client.InterceptInbound(func(f *Frame) (*Frame, error) {
+			opcode = f.Opcode
+			return f, nil
+		})
+
+		var got boardSnapshot
+		if err := ReceiveSnapshot(client, &got); err != nil {
+			t.Errorf("%v", err)
+		}
+
+		if opcode != tt.opcode {
+			t.Errorf("caps %v, %d cells: want: %x, got: %x", tt.caps, len(tt.snap.Cells), tt.opcode, opcode)
+		}
+		if !slices.Equal(got.Cells, tt.snap.Cells) {
+			t.Errorf("snapshot corrupted")
+		}
+
+		serverConn.Close()
+		clientConn.Close()
+	}
+}
+
+func TestReceiveSnapshotBomb(t *testing.T) {
+	serverConn, clientConn := net.Pipe()
+	defer serverConn.Close()
+	defer clientConn.Close()
+
+	// 1MB of zeros, a few KB gzipped
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Write(make([]byte, 1<<20))
+	zw.Close()
+
+	go (&WSConn{Conn: serverConn}).SendBinaryFrame(buf.Bytes())
+
+	client := &WSConn{Conn: clientConn, Role: RoleClient}
+	client.SetReadLimit(64 << 10)
+
+	var v any
+	if err := ReceiveSnapshot(client, &v); err == nil || !strings.Contains(err.Error(), "decompresses past") {
+		t.Errorf("want: decompression limit error, got: %v", err)
+	}
+}
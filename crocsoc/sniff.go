@@ -0,0 +1,40 @@
+package crocsoc
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+)
+
+// Returned by ReadMessage when a message breaks the connection's
+// TextOnly or SniffText guard. The connection has sent a 1003 close frame
+// by then, further reads complete the close handshake.
+var ErrUnsupportedData = errors.New("unsupported data")
+
+/*
+1003 indicates that an endpoint is terminating the connection
+because it has received a type of data it cannot accept (e.g., an
+endpoint that understands only text data MAY send this if it
+receives a binary message).
+*/
+func (c *WSConn) sniff(opcode byte, payload []byte) error {
+	var reason string
+
+	switch {
+	case opcode == 0x2 && c.TextOnly:
+		reason = "binary message on text-only endpoint"
+	// valid UTF-8 may carry NUL, but a text payload with one is almost
+	// always binary data sent with the wrong opcode
+	case opcode == 0x1 && c.SniffText && bytes.IndexByte(payload, 0x00) >= 0:
+		reason = "binary data in text message"
+	default:
+		return nil
+	}
+
+	c.logger().Warn("rejecting message", "reason", reason)
+
+	if err := c.SendCloseFrame(1003, reason); err != nil {
+		return err
+	}
+	return fmt.Errorf("%w: %s", ErrUnsupportedData, reason)
+}
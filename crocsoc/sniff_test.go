@@ -0,0 +1,71 @@
+package crocsoc
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"testing"
+)
+
+func TestSniffGuards(t *testing.T) {
+	tests := []struct {
+		name      string
+		textOnly  bool
+		sniffText bool
+		opcode    byte
+		payload   []byte
+		reject    bool
+	}{
+		{"text allowed", true, true, 0x1, []byte("hello"), false},
+		{"binary allowed", false, false, 0x2, []byte{0x00, 0x01}, false},
+		{"nul text unsniffed", false, false, 0x1, []byte("a\x00b"), false},
+		{"binary on text only", true, false, 0x2, []byte{0x01}, true},
+		{"nul text sniffed", false, true, 0x1, []byte("a\x00b"), true},
+	}
+
+	for _, tt := range tests {
+		serverConn, clientConn := net.Pipe()
+
+		server := &WSConn{Conn: serverConn, TextOnly: tt.textOnly, SniffText: tt.sniffText}
+		client := &WSConn{Conn: clientConn, Role: RoleClient}
+
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			client.WriteFrame(&Frame{Fin: true, Opcode: tt.opcode, Payload: tt.payload})
+
+			if !tt.reject {
+				return
+			}
+
+			frame, err := readFrame(clientConn)
+			if err != nil || frame.Opcode != 0x8 {
+				t.Errorf("%s: want close frame, got: %v %v", tt.name, frame, err)
+				return
+			}
+			if code := binary.BigEndian.Uint16(frame.Payload); code != 1003 {
+				t.Errorf("%s: want: 1003, got: %d", tt.name, code)
+			}
+			client.SendCloseFrame(1003, "")
+		}()
+
+		_, err := server.ReadMessage()
+		if tt.reject {
+			if !errors.Is(err, ErrUnsupportedData) {
+				t.Errorf("%s: want: ErrUnsupportedData, got: %v", tt.name, err)
+			}
+
+			// completes the close handshake
+			if _, err := server.ReadMessage(); err != io.EOF {
+				t.Errorf("%s: want: EOF, got: %v", tt.name, err)
+			}
+		} else if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+		}
+
+		<-done
+		serverConn.Close()
+		clientConn.Close()
+	}
+}
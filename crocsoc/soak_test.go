@@ -0,0 +1,201 @@
+//go:build soak
+
+package crocsoc
+
+/*
+Soak harness: churns connections through the opening handshake, a message
+exchange and the close handshake for a long time, sampling heap and goroutine
+counts, to catch lifecycle leaks.
+
+	go test -tags soak -run TestSoak -v ./crocsoc -soak.duration=2h
+*/
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var (
+	soakDuration = flag.Duration("soak.duration", time.Minute, "how long to churn connections")
+	soakConns    = flag.Int("soak.conns", 32, "concurrent connections")
+	soakInterval = flag.Duration("soak.interval", 10*time.Second, "how often to sample heap and goroutines")
+)
+
+// echoes every message back until the peer closes
+func soakHandler(w http.ResponseWriter, r *http.Request) {
+	if err := OpeningHandshake(w, r); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	conn, rw, err := w.(http.Hijacker).Hijack()
+	if err != nil {
+		return
+	}
+
+	ws := &WSConn{Conn: conn, RW: rw}
+
+	go func() {
+		for msg, err := range ws.Messages() {
+			if err != nil {
+				ws.Terminate()
+				return
+			}
+			ws.WriteFrame(&Frame{Fin: true, Opcode: msg.Opcode, Payload: msg.Data})
+		}
+	}()
+}
+
+// one connection lifetime from the client side
+func soakSession(addr string) error {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	wk := GenerateKey()
+	req, err := BuildUpgradeRequest("ws://"+addr+"/soak", wk, nil)
+	if err != nil {
+		return err
+	}
+	if err := req.Write(conn); err != nil {
+		return err
+	}
+
+	resp, err := http.ReadResponse(bufio.NewReader(conn), req)
+	if err != nil {
+		return err
+	}
+	if err := ValidateResponse(resp, wk); err != nil {
+		return err
+	}
+
+	client := &WSConn{Conn: conn, Role: RoleClient}
+
+	for i := range 10 {
+		want := fmt.Sprintf("soak %d", i)
+		if err := client.SendTextFrame([]byte(want)); err != nil {
+			return err
+		}
+
+		msg, err := client.ReadMessage()
+		if err != nil {
+			return err
+		}
+		if string(msg) != want {
+			return fmt.Errorf("want: %s, got: %s", want, msg)
+		}
+	}
+
+	if err := client.CloseWrite(1000, ""); err != nil {
+		return err
+	}
+	if _, err := client.ReadMessage(); !errors.Is(err, io.EOF) {
+		return fmt.Errorf("want: EOF after close, got: %v", err)
+	}
+
+	return nil
+}
+
+type soakSample struct {
+	heap       uint64
+	goroutines int
+}
+
+func sampleRuntime() soakSample {
+	runtime.GC()
+
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	return soakSample{heap: ms.HeapAlloc, goroutines: runtime.NumGoroutine()}
+}
+
+func TestSoak(t *testing.T) {
+	// a close frame is logged per session
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(slog.New(slog.DiscardHandler))
+
+	srv := httptest.NewServer(http.HandlerFunc(soakHandler))
+	defer srv.Close()
+
+	addr := srv.Listener.Addr().String()
+	baseline := sampleRuntime()
+
+	var sessions, failures atomic.Int64
+	deadline := time.Now().Add(*soakDuration)
+
+	var wg sync.WaitGroup
+	for range *soakConns {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for time.Now().Before(deadline) {
+				if err := soakSession(addr); err != nil {
+					failures.Add(1)
+					t.Logf("session failed: %v", err)
+				}
+				sessions.Add(1)
+			}
+		}()
+	}
+
+	ticker := time.NewTicker(*soakInterval)
+	defer ticker.Stop()
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+sampling:
+	for {
+		select {
+		case <-ticker.C:
+			s := sampleRuntime()
+			t.Logf("sessions=%d failures=%d heap=%dKiB goroutines=%d",
+				sessions.Load(), failures.Load(), s.heap/1024, s.goroutines)
+		case <-done:
+			break sampling
+		}
+	}
+
+	if n := failures.Load(); n > 0 {
+		t.Errorf("%d of %d sessions failed", n, sessions.Load())
+	}
+
+	// every connection goroutine should wind down once the churn stops
+	srv.CloseClientConnections()
+	settle := time.Now().Add(5 * time.Second)
+	final := sampleRuntime()
+	for final.goroutines > baseline.goroutines+2 && time.Now().Before(settle) {
+		time.Sleep(100 * time.Millisecond)
+		final = sampleRuntime()
+	}
+
+	t.Logf("baseline: heap=%dKiB goroutines=%d, final: heap=%dKiB goroutines=%d",
+		baseline.heap/1024, baseline.goroutines, final.heap/1024, final.goroutines)
+
+	if final.goroutines > baseline.goroutines+2 {
+		buf := make([]byte, 1<<20)
+		t.Errorf("goroutine leak: %d -> %d\n%s",
+			baseline.goroutines, final.goroutines, buf[:runtime.Stack(buf, true)])
+	}
+
+	if final.heap > 2*baseline.heap+(8<<20) {
+		t.Errorf("heap grew from %dKiB to %dKiB", baseline.heap/1024, final.heap/1024)
+	}
+}
@@ -0,0 +1,361 @@
+// Package socketio speaks enough of engine.io v4 and the socket.io v5
+// protocol (socket.io 3.x/4.x) over a crocsoc connection for existing
+// socket.io browser clients to connect during a migration. Only the
+// websocket transport is supported, so clients must skip long polling:
+//
+//	io("https://example.com", { transports: ["websocket"] })
+//
+// which connects to /socket.io/?EIO=4&transport=websocket. Binary
+// attachments are not supported.
+//
+//	sock, err := socketio.Accept(conn, socketio.Options{})
+//	for {
+//		ev, err := sock.Next()
+//		...
+//		sock.Emit(ev.Namespace, "reply", "hello")
+//	}
+package socketio
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pgxtips/crocsoc/crocsoc"
+)
+
+/*
+engine.io packets are text messages starting with the packet type:
+
+	0 open     server -> client, handshake data
+	1 close
+	2 ping     server -> client every pingInterval
+	3 pong     client -> server
+	4 message  carries a socket.io packet
+	6 noop
+
+socket.io packets, inside engine.io messages:
+
+	<type>[<namespace>,][<ack id>][<json>]
+
+	0 CONNECT, 1 DISCONNECT, 2 EVENT, 3 ACK, 4 CONNECT_ERROR
+
+e.g. 42["chat","hello"], 421["chat","hello"] (wants ack 1), 431["ok"],
+40/admin,{"token":"..."}
+*/
+
+// Returned by Next for binary events, which need attachments.
+var ErrBinaryUnsupported = errors.New("socketio: binary attachments are not supported")
+
+type Options struct {
+	// how often the server pings, 25s by default
+	PingInterval time.Duration
+	// how long to wait for the pong before dropping the client, 20s by
+	// default
+	PingTimeout time.Duration
+	// largest message accepted, 1MB by default
+	MaxPayload int64
+
+	// called for each namespace CONNECT with the client's auth payload,
+	// an error is sent back as CONNECT_ERROR. All connects are accepted
+	// when nil.
+	Authorize func(namespace string, auth json.RawMessage) error
+}
+
+// An event emitted by the client.
+type Event struct {
+	Namespace string
+	Name      string
+	Args      []json.RawMessage
+
+	// -1 unless the client wants an acknowledgement, see Socket.Ack
+	AckID int
+}
+
+// A socket.io client connection.
+type Socket struct {
+	Conn *crocsoc.WSConn
+	// engine.io session id
+	SID string
+
+	opts Options
+
+	mu sync.Mutex
+	// socket.io session id per connected namespace
+	namespaces map[string]string
+	pongAt     time.Time
+
+	done chan struct{}
+	once sync.Once
+}
+
+// Sends the engine.io open packet on conn and starts pinging the client.
+func Accept(conn *crocsoc.WSConn, opts Options) (*Socket, error) {
+	if opts.PingInterval <= 0 {
+		opts.PingInterval = 25 * time.Second
+	}
+	if opts.PingTimeout <= 0 {
+		opts.PingTimeout = 20 * time.Second
+	}
+	if opts.MaxPayload <= 0 {
+		opts.MaxPayload = 1_000_000
+	}
+
+	s := &Socket{
+		Conn:       conn,
+		SID:        newSID(),
+		opts:       opts,
+		namespaces: map[string]string{},
+		pongAt:     time.Now(),
+		done:       make(chan struct{}),
+	}
+
+	conn.SetReadLimit(opts.MaxPayload)
+
+	open, _ := json.Marshal(map[string]any{
+		"sid":          s.SID,
+		"upgrades":     []string{},
+		"pingInterval": opts.PingInterval.Milliseconds(),
+		"pingTimeout":  opts.PingTimeout.Milliseconds(),
+		"maxPayload":   opts.MaxPayload,
+	})
+	if err := conn.SendTextFrame(append([]byte("0"), open...)); err != nil {
+		return nil, err
+	}
+
+	go s.ping()
+
+	return s, nil
+}
+
+// Reads until the client emits an event, answering pings, namespace
+// connects and disconnects on the way. Returns io.EOF once the connection has
+// closed.
+func (s *Socket) Next() (Event, error) {
+	for {
+		msg, err := s.Conn.ReadMessage()
+		if err != nil {
+			s.stop()
+			return Event{}, err
+		}
+
+		if len(msg) == 0 {
+			continue
+		}
+
+		switch msg[0] {
+		case '3': // pong
+			s.mu.Lock()
+			s.pongAt = time.Now()
+			s.mu.Unlock()
+		case '1': // close
+			s.Close()
+			return Event{}, io.EOF
+		case '4': // message
+			ev, ok, err := s.handle(string(msg[1:]))
+			if err != nil {
+				return Event{}, err
+			}
+			if ok {
+				return ev, nil
+			}
+		}
+	}
+}
+
+// Emits event with args to the client in namespace, "/" being the default.
+func (s *Socket) Emit(namespace, event string, args ...any) error {
+	data, err := json.Marshal(append([]any{event}, args...))
+	if err != nil {
+		return fmt.Errorf("failed to encode event: %v", err)
+	}
+	return s.send(packet{Type: '2', Namespace: namespace, ID: -1, Data: data})
+}
+
+// Acknowledges an event that asked for it, with args as the ack's
+// arguments.
+func (s *Socket) Ack(ev Event, args ...any) error {
+	if ev.AckID < 0 {
+		return fmt.Errorf("event %q did not ask for an ack", ev.Name)
+	}
+
+	data, err := json.Marshal(append([]any{}, args...))
+	if err != nil {
+		return fmt.Errorf("failed to encode ack: %v", err)
+	}
+	return s.send(packet{Type: '3', Namespace: ev.Namespace, ID: ev.AckID, Data: data})
+}
+
+// Sends the engine.io close packet and stops pinging. Keep reading to
+// complete the close handshake.
+func (s *Socket) Close() error {
+	s.stop()
+	s.Conn.SendTextFrame([]byte("1"))
+	return s.Conn.SendCloseFrame(1000, "")
+}
+
+// handles one socket.io packet, reporting whether it was an event
+func (s *Socket) handle(raw string) (Event, bool, error) {
+	p, err := parsePacket(raw)
+	if err != nil {
+		return Event{}, false, err
+	}
+
+	switch p.Type {
+	case '0': // connect
+		return Event{}, false, s.connect(p)
+	case '1': // disconnect
+		s.mu.Lock()
+		delete(s.namespaces, p.Namespace)
+		s.mu.Unlock()
+		return Event{}, false, nil
+	case '2': // event
+		// only namespaces the client connected to, past Authorize
+		s.mu.Lock()
+		_, connected := s.namespaces[p.Namespace]
+		s.mu.Unlock()
+		if !connected {
+			data, _ := json.Marshal(map[string]string{"message": "not connected to namespace"})
+			return Event{}, false, s.send(packet{Type: '4', Namespace: p.Namespace, ID: -1, Data: data})
+		}
+
+		var arr []json.RawMessage
+		if err := json.Unmarshal(p.Data, &arr); err != nil || len(arr) == 0 {
+			return Event{}, false, fmt.Errorf("socketio: malformed event %q", raw)
+		}
+
+		ev := Event{Namespace: p.Namespace, Args: arr[1:], AckID: p.ID}
+		if err := json.Unmarshal(arr[0], &ev.Name); err != nil {
+			return Event{}, false, fmt.Errorf("socketio: malformed event name %s", arr[0])
+		}
+		return ev, true, nil
+	case '5', '6':
+		return Event{}, false, ErrBinaryUnsupported
+	}
+
+	return Event{}, false, nil
+}
+
+func (s *Socket) connect(p packet) error {
+	if s.opts.Authorize != nil {
+		if err := s.opts.Authorize(p.Namespace, p.Data); err != nil {
+			data, _ := json.Marshal(map[string]string{"message": err.Error()})
+			return s.send(packet{Type: '4', Namespace: p.Namespace, ID: -1, Data: data})
+		}
+	}
+
+	sid := newSID()
+
+	s.mu.Lock()
+	s.namespaces[p.Namespace] = sid
+	s.mu.Unlock()
+
+	data, _ := json.Marshal(map[string]string{"sid": sid})
+	return s.send(packet{Type: '0', Namespace: p.Namespace, ID: -1, Data: data})
+}
+
+func (s *Socket) send(p packet) error {
+	return s.Conn.SendTextFrame([]byte("4" + p.encode()))
+}
+
+// pings every PingInterval, dropping the client once a pong is overdue
+func (s *Socket) ping() {
+	ticker := time.NewTicker(s.opts.PingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+		}
+
+		s.mu.Lock()
+		overdue := time.Since(s.pongAt) > s.opts.PingInterval+s.opts.PingTimeout
+		s.mu.Unlock()
+
+		if overdue {
+			s.Conn.Terminate()
+			return
+		}
+
+		if err := s.Conn.SendTextFrame([]byte("2")); err != nil {
+			return
+		}
+	}
+}
+
+func (s *Socket) stop() {
+	s.once.Do(func() { close(s.done) })
+}
+
+type packet struct {
+	Type      byte
+	Namespace string
+	// ack id, -1 for none
+	ID   int
+	Data json.RawMessage
+}
+
+func parsePacket(raw string) (packet, error) {
+	if raw == "" || raw[0] < '0' || raw[0] > '6' {
+		return packet{}, fmt.Errorf("socketio: malformed packet %q", raw)
+	}
+
+	p := packet{Type: raw[0], Namespace: "/", ID: -1}
+	rest := raw[1:]
+
+	if p.Type == '5' || p.Type == '6' {
+		return p, nil
+	}
+
+	if strings.HasPrefix(rest, "/") {
+		ns, after, found := strings.Cut(rest, ",")
+		p.Namespace, rest = ns, after
+		if !found {
+			rest = ""
+		}
+	}
+
+	i := 0
+	for i < len(rest) && rest[i] >= '0' && rest[i] <= '9' {
+		i++
+	}
+	if i > 0 {
+		p.ID, _ = strconv.Atoi(rest[:i])
+		rest = rest[i:]
+	}
+
+	if rest != "" {
+		p.Data = json.RawMessage(rest)
+	}
+	return p, nil
+}
+
+func (p packet) encode() string {
+	var b strings.Builder
+
+	b.WriteByte(p.Type)
+	if p.Namespace != "" && p.Namespace != "/" {
+		b.WriteString(p.Namespace + ",")
+	}
+	if p.ID >= 0 {
+		b.WriteString(strconv.Itoa(p.ID))
+	}
+	b.Write(p.Data)
+
+	return b.String()
+}
+
+func newSID() string {
+	b := make([]byte, 15)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
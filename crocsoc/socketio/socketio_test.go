@@ -0,0 +1,220 @@
+package socketio
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pgxtips/crocsoc/crocsoc"
+)
+
+func TestParsePacket(t *testing.T) {
+	tests := []struct {
+		raw  string
+		want packet
+	}{
+		{"0", packet{Type: '0', Namespace: "/", ID: -1}},
+		{`0{"token":"abc"}`, packet{Type: '0', Namespace: "/", ID: -1, Data: json.RawMessage(`{"token":"abc"}`)}},
+		{"0/admin,", packet{Type: '0', Namespace: "/admin", ID: -1}},
+		{"1/admin,", packet{Type: '1', Namespace: "/admin", ID: -1}},
+		{`2["chat","hi"]`, packet{Type: '2', Namespace: "/", ID: -1, Data: json.RawMessage(`["chat","hi"]`)}},
+		{`2/admin,12["chat"]`, packet{Type: '2', Namespace: "/admin", ID: 12, Data: json.RawMessage(`["chat"]`)}},
+		{`31["ok"]`, packet{Type: '3', Namespace: "/", ID: 1, Data: json.RawMessage(`["ok"]`)}},
+	}
+
+	for _, tt := range tests {
+		got, err := parsePacket(tt.raw)
+		if err != nil {
+			t.Errorf("%s: %v", tt.raw, err)
+			continue
+		}
+		if got.Type != tt.want.Type || got.Namespace != tt.want.Namespace || got.ID != tt.want.ID || string(got.Data) != string(tt.want.Data) {
+			t.Errorf("%s: want: %+v, got: %+v", tt.raw, tt.want, got)
+		}
+
+		if enc := got.encode(); enc != tt.raw {
+			t.Errorf("encode: want: %s, got: %s", tt.raw, enc)
+		}
+	}
+
+	// the comma after a namespace is optional with nothing after it
+	if p, err := parsePacket("1/admin"); err != nil || p.Namespace != "/admin" {
+		t.Errorf("want: /admin, got: %+v %v", p, err)
+	}
+
+	for _, raw := range []string{"", "x", "9"} {
+		if _, err := parsePacket(raw); err == nil {
+			t.Errorf("%q accepted", raw)
+		}
+	}
+}
+
+func readText(t *testing.T, c *crocsoc.WSConn) string {
+	t.Helper()
+
+	msg, err := c.ReadMessage()
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	return string(msg)
+}
+
+func TestSocket(t *testing.T) {
+	serverConn, clientConn := net.Pipe()
+	defer serverConn.Close()
+	defer clientConn.Close()
+
+	socks := make(chan *Socket, 1)
+	events := make(chan Event)
+	go func() {
+		sock, err := Accept(&crocsoc.WSConn{Conn: serverConn}, Options{PingInterval: time.Hour})
+		if err != nil {
+			t.Errorf("%v", err)
+			return
+		}
+		socks <- sock
+
+		for {
+			ev, err := sock.Next()
+			if err != nil {
+				close(events)
+				return
+			}
+			events <- ev
+		}
+	}()
+
+	client := &crocsoc.WSConn{Conn: clientConn, Role: crocsoc.RoleClient}
+
+	open := readText(t, client)
+	var handshake struct {
+		SID          string `json:"sid"`
+		PingInterval int64  `json:"pingInterval"`
+	}
+	if !strings.HasPrefix(open, "0") || json.Unmarshal([]byte(open[1:]), &handshake) != nil || handshake.SID == "" {
+		t.Fatalf("unexpected open packet: %s", open)
+	}
+	if handshake.PingInterval != time.Hour.Milliseconds() {
+		t.Errorf("want: %d, got: %d", time.Hour.Milliseconds(), handshake.PingInterval)
+	}
+	sock := <-socks
+
+	client.SendTextFrame([]byte("40"))
+	if connected := readText(t, client); !strings.HasPrefix(connected, `40{"sid":"`) {
+		t.Errorf("unexpected connect reply: %s", connected)
+	}
+
+	client.SendTextFrame([]byte(`421["chat","hi"]`))
+	ev := <-events
+	if ev.Name != "chat" || ev.AckID != 1 || len(ev.Args) != 1 || string(ev.Args[0]) != `"hi"` {
+		t.Errorf("unexpected event: %+v", ev)
+	}
+
+	go sock.Ack(ev, "ok")
+	if ack := readText(t, client); ack != `431["ok"]` {
+		t.Errorf("want: 431[\"ok\"], got: %s", ack)
+	}
+
+	go sock.Emit("/admin", "news", 1)
+	if news := readText(t, client); news != `42/admin,["news",1]` {
+		t.Errorf(`want: 42/admin,["news",1], got: %s`, news)
+	}
+}
+
+func TestSocketPingTimeout(t *testing.T) {
+	serverConn, clientConn := net.Pipe()
+	defer serverConn.Close()
+	defer clientConn.Close()
+
+	go func() {
+		sock, err := Accept(&crocsoc.WSConn{Conn: serverConn}, Options{PingInterval: 10 * time.Millisecond, PingTimeout: 10 * time.Millisecond})
+		if err != nil {
+			return
+		}
+		for {
+			if _, err := sock.Next(); err != nil {
+				return
+			}
+		}
+	}()
+
+	client := &crocsoc.WSConn{Conn: clientConn, Role: crocsoc.RoleClient}
+	readText(t, client) // open
+
+	// answer the first ping, then go quiet
+	if ping := readText(t, client); ping != "2" {
+		t.Fatalf("want: 2, got: %s", ping)
+	}
+	client.SendTextFrame([]byte("3"))
+
+	deadline := time.After(2 * time.Second)
+	for {
+		select {
+		case <-deadline:
+			t.Fatalf("client not dropped")
+		default:
+		}
+
+		if _, err := client.ReadMessage(); err != nil {
+			return
+		}
+	}
+}
+
+func TestSocketUnconnectedNamespace(t *testing.T) {
+	serverConn, clientConn := net.Pipe()
+	defer serverConn.Close()
+	defer clientConn.Close()
+
+	events := make(chan Event)
+	go func() {
+		sock, err := Accept(&crocsoc.WSConn{Conn: serverConn}, Options{
+			PingInterval: time.Hour,
+			Authorize: func(namespace string, auth json.RawMessage) error {
+				if namespace == "/admin" {
+					return errors.New("forbidden")
+				}
+				return nil
+			},
+		})
+		if err != nil {
+			t.Errorf("%v", err)
+			return
+		}
+
+		for {
+			ev, err := sock.Next()
+			if err != nil {
+				close(events)
+				return
+			}
+			events <- ev
+		}
+	}()
+
+	client := &crocsoc.WSConn{Conn: clientConn, Role: crocsoc.RoleClient}
+	readText(t, client) // open
+
+	client.SendTextFrame([]byte("40/admin,"))
+	if reply := readText(t, client); !strings.HasPrefix(reply, "44/admin,") {
+		t.Errorf("want: CONNECT_ERROR, got: %s", reply)
+	}
+
+	// events skipping or failing CONNECT are refused
+	for _, raw := range []string{`42/admin,["x"]`, `42["x"]`} {
+		client.SendTextFrame([]byte(raw))
+		if reply := readText(t, client); !strings.HasPrefix(reply, "44") {
+			t.Errorf("%s: want: CONNECT_ERROR, got: %s", raw, reply)
+		}
+	}
+
+	client.SendTextFrame([]byte("40"))
+	readText(t, client)
+	client.SendTextFrame([]byte(`42["x"]`))
+	if ev := <-events; ev.Name != "x" || ev.Namespace != "/" {
+		t.Errorf("unexpected event: %+v", ev)
+	}
+}
@@ -0,0 +1,278 @@
+package crocsoc
+
+import (
+	"bytes"
+	"compress/flate"
+	"errors"
+	"fmt"
+	"io"
+	"unicode/utf8"
+)
+
+/*
+Streaming messages, for payloads too large to hold in memory at once.
+NextReader hands out a message's payload as its frames arrive, and
+NextWriter sends what is written as continuation frames:
+
+	mt, r, err := conn.NextReader()
+	...
+	w, err := conn.NextWriter(mt)
+	io.Copy(w, r)
+	w.Close()
+
+Control frames arriving between fragments are answered as usual. The same
+checks as ReadMessage apply: the read limit, TextOnly and SniffText, and
+UTF-8 validity of text, though the latter are only known once the bytes
+breaking them have been read, so part of a rejected message may already
+have been handed out.
+*/
+
+// bytes buffered by a NextWriter before a frame is sent
+const streamFrameSize = 16 << 10
+
+// Returns the next text or binary message as a reader over its payload,
+// read from the connection as it is consumed. Any unread part of the
+// message NextReader returned last is discarded first. Returns io.EOF once
+// the connection has closed.
+func (c *Conn) NextReader() (msgType int, r io.Reader, err error) {
+	if err := c.discardReader(); err != nil {
+		return 0, nil, err
+	}
+
+	c.waitReadable()
+
+	f, err := c.nextFrame(c.remainingAfter(0), true)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	if f.Opcode != 0x1 && f.Opcode != 0x2 {
+		return 0, nil, fmt.Errorf("unsupported opcode %x", f.Opcode)
+	}
+	if err := c.sniff(f.Opcode, nil); err != nil {
+		return 0, nil, err
+	}
+
+	mr := &messageReader{c: c.WSConn, payload: f.Payload, fin: f.Fin, read: int64(len(f.Payload))}
+	c.reader = mr
+	r = mr
+
+	// a fragmented compressed message, whole ones come inflated
+	if f.Rsv == 0x4 {
+		r = &inflateReader{
+			c:     c.WSConn,
+			fr:    flate.NewReader(io.MultiReader(mr, bytes.NewReader(deflateTail), bytes.NewReader(deflateFinal))),
+			limit: inflateLimit(c.remainingAfter(0)),
+		}
+	}
+
+	if f.Opcode == 0x1 {
+		r = &textReader{c: c.WSConn, r: r}
+	}
+
+	return int(f.Opcode), r, nil
+}
+
+// Returns a writer sending what is written as one message of msgType,
+// text or binary, in frames of up to 16KB. Close sends the final frame. No
+// other text or binary message may be written before then, control frames
+// may.
+func (c *Conn) NextWriter(msgType int) (io.WriteCloser, error) {
+	if msgType != TextMessage && msgType != BinaryMessage {
+		return nil, fmt.Errorf("unsupported message type %d for NextWriter", msgType)
+	}
+	return &messageWriter{c: c.WSConn, opcode: byte(msgType)}, nil
+}
+
+// reads the rest of the message NextReader last returned
+func (c *Conn) discardReader() error {
+	if c.reader == nil {
+		return nil
+	}
+	mr := c.reader
+	c.reader = nil
+
+	_, err := io.Copy(io.Discard, mr)
+	return err
+}
+
+// the raw payload of a message's frames, as they arrive
+type messageReader struct {
+	c *WSConn
+
+	// unread payload of the current frame
+	payload []byte
+	// whether the current frame is the last
+	fin bool
+	// payload bytes of all frames so far, for the read limit
+	read int64
+	err  error
+}
+
+func (r *messageReader) Read(p []byte) (int, error) {
+	for len(r.payload) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		if r.fin {
+			r.err = io.EOF
+			return 0, io.EOF
+		}
+
+		f, err := r.c.nextFrame(r.c.remainingAfter(r.read), false)
+		if errors.Is(err, io.EOF) {
+			// closed part way through the message
+			err = io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			r.err = err
+			return 0, err
+		}
+
+		// all subsequent fragments must be continuation frames opcode 0x0
+		if f.Opcode != 0x0 {
+			r.err = fmt.Errorf("unexpected opcode %x in continuation frame", f.Opcode)
+			return 0, r.err
+		}
+
+		r.payload, r.fin = f.Payload, f.Fin
+		r.read += int64(len(f.Payload))
+	}
+
+	n := copy(p, r.payload)
+	r.payload = r.payload[n:]
+	return n, nil
+}
+
+// inflates a fragmented compressed message within the read limit
+type inflateReader struct {
+	c  *WSConn
+	fr io.ReadCloser
+	// inflated bytes so far
+	n     int64
+	limit int64
+	err   error
+}
+
+func (r *inflateReader) Read(p []byte) (int, error) {
+	if r.err != nil {
+		return 0, r.err
+	}
+
+	n, err := r.fr.Read(p)
+	r.n += int64(n)
+
+	if r.n > r.limit {
+		r.err = r.c.rejectTooBig()
+		return 0, r.err
+	}
+	if err != nil && !errors.Is(err, io.EOF) {
+		err = fmt.Errorf("failed to inflate message: %v", err)
+	}
+	if err != nil {
+		r.fr.Close()
+		r.err = err
+	}
+	return n, err
+}
+
+// checks a text message is UTF-8, and free of NUL with SniffText, as it is
+// read
+type textReader struct {
+	c *WSConn
+	r io.Reader
+	// start of a rune cut off by the end of the last read
+	partial []byte
+}
+
+func (r *textReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+
+	if err := r.c.sniff(0x1, p[:n]); err != nil {
+		return 0, err
+	}
+
+	data := append(r.partial, p[:n]...)
+	cut := len(data) - incompleteRune(data)
+	if !utf8.Valid(data[:cut]) {
+		return 0, fmt.Errorf("invalid UTF-8 in text frame")
+	}
+	r.partial = append(r.partial[:0], data[cut:]...)
+
+	if errors.Is(err, io.EOF) && len(r.partial) > 0 {
+		return 0, fmt.Errorf("invalid UTF-8 in text frame")
+	}
+	return n, err
+}
+
+// returns the length of a rune at the end of b that is cut short
+func incompleteRune(b []byte) int {
+	for i := 1; i <= utf8.UTFMax-1 && i <= len(b); i++ {
+		if utf8.RuneStart(b[len(b)-i]) {
+			if utf8.FullRune(b[len(b)-i:]) {
+				return 0
+			}
+			return i
+		}
+	}
+	return 0
+}
+
+// sends a message as frames of up to streamFrameSize
+type messageWriter struct {
+	c      *WSConn
+	opcode byte
+
+	buf     []byte
+	started bool
+	closed  bool
+}
+
+func (w *messageWriter) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, fmt.Errorf("write to closed message writer")
+	}
+
+	written := 0
+	for len(p) > 0 {
+		if w.buf == nil {
+			w.buf = make([]byte, 0, streamFrameSize)
+		}
+
+		n := copy(w.buf[len(w.buf):cap(w.buf)], p)
+		w.buf = w.buf[:len(w.buf)+n]
+		p = p[n:]
+		written += n
+
+		if len(w.buf) == cap(w.buf) {
+			if err := w.flush(false); err != nil {
+				return written, err
+			}
+		}
+	}
+	return written, nil
+}
+
+// Sends the final frame. A message that fit in one frame goes out whole,
+// compressed when permessage-deflate is on.
+func (w *messageWriter) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	return w.flush(true)
+}
+
+func (w *messageWriter) flush(fin bool) error {
+	opcode := w.opcode
+	if w.started {
+		opcode = 0x0
+	}
+	w.started = true
+
+	// a fresh buffer per frame, interceptors may keep the payload
+	payload := w.buf
+	w.buf = nil
+
+	return w.c.WriteFrame(&Frame{Fin: fin, Opcode: opcode, Payload: payload})
+}
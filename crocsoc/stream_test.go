@@ -0,0 +1,181 @@
+package crocsoc
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNextReaderWriter(t *testing.T) {
+	serverConn, clientConn := net.Pipe()
+	defer serverConn.Close()
+	defer clientConn.Close()
+
+	server := NewConn(serverConn, nil, RoleServer)
+	client := NewConn(clientConn, nil, RoleClient)
+
+	// three frames' worth, with a multibyte rune straddling a frame
+	// boundary
+	msg := strings.Repeat("a", streamFrameSize-1) + "€" + strings.Repeat("b", 2*streamFrameSize)
+
+	go func() {
+		w, err := client.NextWriter(TextMessage)
+		if err != nil {
+			t.Errorf("%v", err)
+			return
+		}
+		io.Copy(w, strings.NewReader(msg))
+		// a ping between fragments
+		client.SendPingFrame([]byte("hi"))
+		w.Close()
+
+		client.WriteMessage(BinaryMessage, []byte("next"))
+	}()
+
+	// answers the ping
+	go func() {
+		for {
+			if _, err := readFrame(clientConn); err != nil {
+				return
+			}
+		}
+	}()
+
+	mt, r, err := server.NextReader()
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if mt != TextMessage {
+		t.Errorf("want: %d, got: %d", TextMessage, mt)
+	}
+
+	// small reads, splitting runes
+	var got bytes.Buffer
+	buf := make([]byte, 1000)
+	for {
+		n, err := r.Read(buf)
+		got.Write(buf[:n])
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+	}
+	if got.String() != msg {
+		t.Errorf("want: %d bytes, got: %d bytes", len(msg), got.Len())
+	}
+
+	mt, data, err := server.ReadMessage()
+	if err != nil || mt != BinaryMessage || string(data) != "next" {
+		t.Errorf("want: next, got: %d %q %v", mt, data, err)
+	}
+}
+
+func TestNextReaderDiscards(t *testing.T) {
+	serverConn, clientConn := net.Pipe()
+	defer serverConn.Close()
+	defer clientConn.Close()
+
+	server := NewConn(serverConn, nil, RoleServer)
+	client := &WSConn{Conn: clientConn, Role: RoleClient}
+
+	go func() {
+		writeFrame(clientConn, &Frame{Opcode: 0x2, Payload: []byte("skip")}, true, time.Time{})
+		writeFrame(clientConn, &Frame{Fin: true, Opcode: 0x0, Payload: []byte("ped")}, true, time.Time{})
+		client.SendTextFrame([]byte("read"))
+	}()
+
+	if _, _, err := server.NextReader(); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	// the unread message is skipped
+	_, r, err := server.NextReader()
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if data, err := io.ReadAll(r); err != nil || string(data) != "read" {
+		t.Errorf("want: read, got: %q %v", data, err)
+	}
+}
+
+func TestNextReaderLimits(t *testing.T) {
+	tests := []struct {
+		name   string
+		frames []*Frame
+		want   error
+	}{
+		{"too big", []*Frame{
+			{Opcode: 0x2, Payload: make([]byte, 60)},
+			{Fin: true, Opcode: 0x0, Payload: make([]byte, 60)},
+		}, ErrMessageTooBig},
+		{"invalid utf-8", []*Frame{
+			{Opcode: 0x1, Payload: []byte("ok")},
+			{Fin: true, Opcode: 0x0, Payload: []byte{0xff}},
+		}, nil},
+		{"truncated rune", []*Frame{
+			{Opcode: 0x1, Payload: []byte("ok")},
+			{Fin: true, Opcode: 0x0, Payload: []byte("€")[:2]},
+		}, nil},
+	}
+
+	for _, tt := range tests {
+		serverConn, clientConn := net.Pipe()
+
+		server := NewConn(serverConn, nil, RoleServer)
+		server.SetReadLimit(100)
+
+		// takes the close frame while frames are still being written
+		go io.Copy(io.Discard, clientConn)
+		go func() {
+			for _, f := range tt.frames {
+				if writeFrame(clientConn, f, true, time.Time{}) != nil {
+					return
+				}
+			}
+		}()
+
+		_, r, err := server.NextReader()
+		if err == nil {
+			_, err = io.ReadAll(r)
+		}
+
+		if err == nil || (tt.want != nil && !errors.Is(err, tt.want)) {
+			t.Errorf("%s: want: %v, got: %v", tt.name, tt.want, err)
+		}
+
+		serverConn.Close()
+		clientConn.Close()
+	}
+}
+
+func TestNextReaderCompressed(t *testing.T) {
+	serverConn, clientConn := net.Pipe()
+	defer serverConn.Close()
+	defer clientConn.Close()
+
+	server := NewConn(serverConn, nil, RoleServer)
+	server.Compression = true
+
+	msg := bytes.Repeat([]byte("stream "), 1000)
+	compressed, _ := deflate(msg)
+
+	go func() {
+		half := len(compressed) / 2
+		writeFrame(clientConn, &Frame{Rsv: 0x4, Opcode: 0x2, Payload: compressed[:half]}, true, time.Time{})
+		writeFrame(clientConn, &Frame{Fin: true, Opcode: 0x0, Payload: compressed[half:]}, true, time.Time{})
+	}()
+
+	_, r, err := server.NextReader()
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if got, err := io.ReadAll(r); err != nil || !bytes.Equal(got, msg) {
+		t.Errorf("want: %d bytes, got: %d bytes %v", len(msg), len(got), err)
+	}
+}
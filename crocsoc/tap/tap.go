@@ -0,0 +1,152 @@
+// Package tap copies a sample of the messages crossing connections to a
+// sink, for analytics and anomaly detection without the cost of mirroring
+// everything.
+//
+//	t := tap.New(tap.Config{Rate: 0.01, Sink: sink, Scrub: dropEmails})
+//	t.Attach(conn)
+package tap
+
+import (
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	"github.com/pgxtips/crocsoc/crocsoc"
+)
+
+type Direction byte
+
+const (
+	// received from the peer
+	Inbound Direction = iota
+	// sent to the peer
+	Outbound
+)
+
+// A copy of one sampled message.
+type Sample struct {
+	ConnID    uint64
+	Direction Direction
+	// 0x1 text or 0x2 binary
+	Opcode byte
+	// when the message's first frame passed
+	Time time.Time
+
+	// the start of the message, up to Config.MaxSize bytes
+	Data []byte
+	// size of the whole message
+	Size int
+}
+
+// Receives samples. Write is called on the connection's read or write path,
+// so it must be quick, e.g. handing the sample to a buffered channel.
+type Sink interface {
+	Write(Sample)
+}
+
+type Config struct {
+	// fraction of messages sampled, 0.01 for 1%
+	Rate float64
+	// bytes of each message kept, 4KB by default
+	MaxSize int
+
+	// rewrites a sample before it reaches the sink, e.g. to scrub
+	// personal data; returning false drops it
+	Scrub func(*Sample) bool
+
+	Sink Sink
+}
+
+// Samples messages of attached connections.
+type Tap struct {
+	cfg Config
+}
+
+func New(cfg Config) *Tap {
+	if cfg.MaxSize <= 0 {
+		cfg.MaxSize = 4 << 10
+	}
+	return &Tap{cfg: cfg}
+}
+
+// Samples every message conn reads or writes from now on. Messages are
+// seen unmasked, after any interceptors registered before Attach.
+func (t *Tap) Attach(conn *crocsoc.WSConn) {
+	in := &stream{tap: t, conn: conn, dir: Inbound}
+	out := &stream{tap: t, conn: conn, dir: Outbound}
+
+	conn.InterceptInbound(in.intercept)
+	conn.InterceptOutbound(out.intercept)
+}
+
+// one direction of a connection, messages may span several frames
+type stream struct {
+	tap  *Tap
+	conn *crocsoc.WSConn
+	dir  Direction
+
+	mu       sync.Mutex
+	sampling bool
+	sample   Sample
+}
+
+func (s *stream) intercept(f *crocsoc.Frame) (*crocsoc.Frame, error) {
+	switch f.Opcode {
+	case 0x1, 0x2:
+		s.begin(f)
+	case 0x0:
+		s.cont(f)
+	}
+	return f, nil
+}
+
+func (s *stream) begin(f *crocsoc.Frame) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// decided per message, continuations follow their first frame
+	s.sampling = rand.Float64() < s.tap.cfg.Rate
+	if !s.sampling {
+		return
+	}
+
+	s.sample = Sample{
+		ConnID:    s.conn.ID,
+		Direction: s.dir,
+		Opcode:    f.Opcode,
+		Time:      time.Now(),
+	}
+	s.add(f)
+}
+
+func (s *stream) cont(f *crocsoc.Frame) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.sampling {
+		s.add(f)
+	}
+}
+
+// must hold mu
+func (s *stream) add(f *crocsoc.Frame) {
+	s.sample.Size += len(f.Payload)
+
+	// copy, payloads belong to the connection
+	if room := s.tap.cfg.MaxSize - len(s.sample.Data); room > 0 {
+		s.sample.Data = append(s.sample.Data, f.Payload[:min(room, len(f.Payload))]...)
+	}
+
+	if !f.Fin {
+		return
+	}
+
+	s.sampling = false
+	sample := s.sample
+	s.sample = Sample{}
+
+	if s.tap.cfg.Scrub != nil && !s.tap.cfg.Scrub(&sample) {
+		return
+	}
+	s.tap.cfg.Sink.Write(sample)
+}
@@ -0,0 +1,89 @@
+package tap
+
+import (
+	"bytes"
+	"net"
+	"sync"
+	"testing"
+
+	"github.com/pgxtips/crocsoc/crocsoc"
+)
+
+type memSink struct {
+	mu      sync.Mutex
+	samples []Sample
+}
+
+func (m *memSink) Write(s Sample) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.samples = append(m.samples, s)
+}
+
+func TestTap(t *testing.T) {
+	serverConn, clientConn := net.Pipe()
+	defer serverConn.Close()
+	defer clientConn.Close()
+
+	sink := &memSink{}
+	tp := New(Config{
+		Rate:    1,
+		MaxSize: 4,
+		Sink:    sink,
+		Scrub: func(s *Sample) bool {
+			s.Data = bytes.ReplaceAll(s.Data, []byte("x"), []byte("*"))
+			return !bytes.HasPrefix(s.Data, []byte("drop"))
+		},
+	})
+
+	server := &crocsoc.WSConn{ID: 3, Conn: serverConn}
+	tp.Attach(server)
+
+	client := &crocsoc.WSConn{Conn: clientConn, Role: crocsoc.RoleClient}
+	go func() {
+		// a fragmented message, then one the scrubber drops
+		client.WriteFrame(&crocsoc.Frame{Fin: false, Opcode: 0x1, Payload: []byte("ax")})
+		client.WriteFrame(&crocsoc.Frame{Fin: true, Opcode: 0x0, Payload: []byte("bcdef")})
+		client.SendTextFrame([]byte("drop me"))
+	}()
+
+	for range 2 {
+		if _, err := server.ReadMessage(); err != nil {
+			t.Fatalf("%v", err)
+		}
+	}
+
+	go server.SendBinaryFrame([]byte{1, 2})
+	if _, err := client.ReadMessage(); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	if len(sink.samples) != 2 {
+		t.Fatalf("want: 2 samples, got: %d", len(sink.samples))
+	}
+
+	in := sink.samples[0]
+	if in.ConnID != 3 || in.Direction != Inbound || in.Opcode != 0x1 || string(in.Data) != "a*bc" || in.Size != 7 {
+		t.Errorf("unexpected inbound sample: %+v", in)
+	}
+
+	out := sink.samples[1]
+	if out.Direction != Outbound || out.Opcode != 0x2 || !bytes.Equal(out.Data, []byte{1, 2}) {
+		t.Errorf("unexpected outbound sample: %+v", out)
+	}
+}
+
+func TestTapRate(t *testing.T) {
+	sink := &memSink{}
+	tp := New(Config{Rate: 0.1, Sink: sink})
+	s := &stream{tap: tp, conn: &crocsoc.WSConn{}, dir: Inbound}
+
+	for range 10000 {
+		s.intercept(&crocsoc.Frame{Fin: true, Opcode: 0x1, Payload: []byte("m")})
+	}
+
+	// well within chance of 1000
+	if n := len(sink.samples); n < 800 || n > 1200 {
+		t.Errorf("sampled %d of 10000 at 10%%", n)
+	}
+}
@@ -0,0 +1,61 @@
+package crocsoc
+
+import (
+	"context"
+)
+
+/*
+Tracing hooks, shaped after the OpenTelemetry trace API so an OTel tracer
+can be adapted in a few lines without crocsoc depending on it:
+
+	type otelTracer struct{ t trace.Tracer }
+
+	func (o otelTracer) Start(ctx context.Context, name string) (context.Context, crocsoc.Span) {
+		ctx, span := o.t.Start(ctx, name)
+		return ctx, otelSpan{span}
+	}
+
+Spans are emitted for:
+	- crocsoc.upgrade  one per opening handshake handled by WsHandler
+	- crocsoc.message  one per text/binary message read (opcode, bytes)
+	- crocsoc.close    one per close frame received (close code)
+*/
+
+type Tracer interface {
+	Start(ctx context.Context, name string) (context.Context, Span)
+}
+
+type Span interface {
+	SetAttributes(attrs ...Attribute)
+	RecordError(err error)
+	End()
+}
+
+type Attribute struct {
+	Key   string
+	Value any
+}
+
+// no tracing unless SetTracer is called
+var tracer Tracer = noopTracer{}
+
+// Installs the tracer used for all connections. Must be called before any
+// connections are served.
+func SetTracer(t Tracer) {
+	if t == nil {
+		t = noopTracer{}
+	}
+	tracer = t
+}
+
+type noopTracer struct{}
+
+func (noopTracer) Start(ctx context.Context, name string) (context.Context, Span) {
+	return ctx, noopSpan{}
+}
+
+type noopSpan struct{}
+
+func (noopSpan) SetAttributes(attrs ...Attribute) {}
+func (noopSpan) RecordError(err error)            {}
+func (noopSpan) End()                             {}
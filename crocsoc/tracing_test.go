@@ -0,0 +1,71 @@
+package crocsoc
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+)
+
+type recordedSpan struct {
+	name  string
+	attrs map[string]any
+	ended bool
+}
+
+type recordingTracer struct {
+	mu    sync.Mutex
+	spans []*recordedSpan
+}
+
+func (rt *recordingTracer) Start(ctx context.Context, name string) (context.Context, Span) {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+
+	s := &recordedSpan{name: name, attrs: map[string]any{}}
+	rt.spans = append(rt.spans, s)
+	return ctx, s
+}
+
+func (s *recordedSpan) SetAttributes(attrs ...Attribute) {
+	for _, a := range attrs {
+		s.attrs[a.Key] = a.Value
+	}
+}
+
+func (s *recordedSpan) RecordError(err error) {}
+func (s *recordedSpan) End()                  { s.ended = true }
+
+func TestMessageSpan(t *testing.T) {
+	rt := &recordingTracer{}
+	SetTracer(rt)
+	defer SetTracer(nil)
+
+	d := []byte{
+		0x01, 0x03, 0x48, 0x65, 0x6c,
+		0x80, 0x02, 0x6c, 0x6f,
+	}
+
+	serverConn, clientConn := net.Pipe()
+	defer serverConn.Close()
+	defer clientConn.Close()
+
+	go clientConn.Write(d)
+
+	if _, err := ReadMessage(serverConn); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	// one span for the whole fragmented message
+	if len(rt.spans) != 1 {
+		t.Fatalf("want: 1 span, got: %d", len(rt.spans))
+	}
+
+	s := rt.spans[0]
+	if s.name != "crocsoc.message" || !s.ended {
+		t.Errorf("unexpected span: %+v", s)
+	}
+	if s.attrs["websocket.opcode"] != 1 || s.attrs["websocket.bytes"] != 5 {
+		t.Errorf("unexpected attributes: %v", s.attrs)
+	}
+}
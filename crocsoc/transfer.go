@@ -0,0 +1,180 @@
+package crocsoc
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+)
+
+/*
+File transfer over a connection, resumable after a reconnect:
+
+	sender   -> manifest   {"name":"a.bin","size":1048576,"sha256":"...","chunk_size":32768}
+	receiver -> resume     {"offset":524288}   bytes it already holds
+	sender   -> chunks     binary messages from offset to size
+	receiver -> result     {"ok":true} or {"error":"checksum mismatch"}
+
+Control messages are JSON text, chunks are binary. The checksum covers the
+whole file, including any part received before a resume.
+*/
+
+// Returned by SendFile and ReceiveFile when the received file doesn't match
+// the manifest's checksum.
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
+const defaultChunkSize = 32 << 10
+
+type FileManifest struct {
+	Name string `json:"name"`
+	Size int64  `json:"size"`
+	// hex encoded
+	SHA256    string `json:"sha256"`
+	ChunkSize int    `json:"chunk_size"`
+}
+
+type TransferOptions struct {
+	// bytes per binary message, 32KiB by default
+	ChunkSize int
+	// called after each chunk with the bytes transferred so far, including
+	// any resumed from
+	Progress func(done, total int64)
+}
+
+// Storage for a received file. *os.File satisfies it.
+type FileTarget interface {
+	io.ReaderAt
+	io.WriterAt
+}
+
+type transferResume struct {
+	Offset int64 `json:"offset"`
+}
+
+type transferResult struct {
+	OK    bool   `json:"ok,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// Sends size bytes of src as name, starting wherever the receiver's copy
+// leaves off. Returns once the receiver has verified the checksum.
+func SendFile(conn *WSConn, name string, src io.ReaderAt, size int64, opts TransferOptions) error {
+	chunkSize := opts.ChunkSize
+	if chunkSize <= 0 {
+		chunkSize = defaultChunkSize
+	}
+
+	h := sha256.New()
+	hashed, err := io.Copy(h, io.NewSectionReader(src, 0, size))
+	if err != nil {
+		return fmt.Errorf("failed to hash file: %v", err)
+	}
+	if hashed < size {
+		return fmt.Errorf("failed to hash file: %w", io.ErrUnexpectedEOF)
+	}
+
+	manifest := FileManifest{Name: name, Size: size, SHA256: hex.EncodeToString(h.Sum(nil)), ChunkSize: chunkSize}
+	if err := Typed[FileManifest](conn, JSONCodec).Send(manifest); err != nil {
+		return err
+	}
+
+	resume, err := Typed[transferResume](conn, JSONCodec).Receive()
+	if err != nil {
+		return err
+	}
+	if resume.Offset < 0 || resume.Offset > size {
+		return fmt.Errorf("invalid resume offset %d", resume.Offset)
+	}
+
+	buf := make([]byte, chunkSize)
+	for off := resume.Offset; off < size; {
+		want := int(min(int64(chunkSize), size-off))
+		n, err := src.ReadAt(buf[:want], off)
+		if err != nil && !errors.Is(err, io.EOF) {
+			return fmt.Errorf("failed to read file: %v", err)
+		}
+		// src got shorter than size since it was hashed
+		if n < want {
+			return fmt.Errorf("failed to read file: %w", io.ErrUnexpectedEOF)
+		}
+
+		if err := conn.SendBinaryFrame(buf[:n]); err != nil {
+			return err
+		}
+
+		off += int64(n)
+		if opts.Progress != nil {
+			opts.Progress(off, size)
+		}
+	}
+
+	result, err := Typed[transferResult](conn, JSONCodec).Receive()
+	if err != nil {
+		return err
+	}
+	if !result.OK {
+		if result.Error == ErrChecksumMismatch.Error() {
+			return ErrChecksumMismatch
+		}
+		return fmt.Errorf("transfer rejected: %s", result.Error)
+	}
+
+	return nil
+}
+
+// Receives a file sent with SendFile. open is called with the manifest and
+// returns where to store the file and how many of its bytes are already
+// held from an earlier attempt, 0 for a fresh transfer.
+func ReceiveFile(conn *WSConn, open func(FileManifest) (FileTarget, int64, error), opts TransferOptions) (FileManifest, error) {
+	manifest, err := Typed[FileManifest](conn, JSONCodec).Receive()
+	if err != nil {
+		return manifest, err
+	}
+
+	results := Typed[transferResult](conn, JSONCodec)
+
+	dst, have, err := open(manifest)
+	if err != nil {
+		results.Send(transferResult{Error: err.Error()})
+		return manifest, err
+	}
+	have = min(max(have, 0), manifest.Size)
+
+	if err := Typed[transferResume](conn, JSONCodec).Send(transferResume{Offset: have}); err != nil {
+		return manifest, err
+	}
+
+	for off := have; off < manifest.Size; {
+		opcode, chunk, err := conn.readMessage()
+		if err != nil {
+			return manifest, err
+		}
+		if opcode != 0x2 || int64(len(chunk)) > manifest.Size-off {
+			err := fmt.Errorf("unexpected message in file transfer")
+			results.Send(transferResult{Error: err.Error()})
+			return manifest, err
+		}
+
+		if _, err := dst.WriteAt(chunk, off); err != nil {
+			results.Send(transferResult{Error: "failed to store file"})
+			return manifest, fmt.Errorf("failed to write file: %v", err)
+		}
+
+		off += int64(len(chunk))
+		if opts.Progress != nil {
+			opts.Progress(off, manifest.Size)
+		}
+	}
+
+	h := sha256.New()
+	if _, err := io.Copy(h, io.NewSectionReader(dst, 0, manifest.Size)); err != nil {
+		return manifest, fmt.Errorf("failed to hash file: %v", err)
+	}
+	if hex.EncodeToString(h.Sum(nil)) != manifest.SHA256 {
+		results.Send(transferResult{Error: ErrChecksumMismatch.Error()})
+		return manifest, ErrChecksumMismatch
+	}
+
+	return manifest, results.Send(transferResult{OK: true})
+}
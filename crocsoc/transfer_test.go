@@ -0,0 +1,108 @@
+package crocsoc
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"testing"
+)
+
+// an in memory FileTarget
+type memFile struct {
+	data []byte
+}
+
+func (m *memFile) ReadAt(p []byte, off int64) (int, error) {
+	return copy(p, m.data[off:]), nil
+}
+
+func (m *memFile) WriteAt(p []byte, off int64) (int, error) {
+	if need := int(off) + len(p); need > len(m.data) {
+		m.data = append(m.data, make([]byte, need-len(m.data))...)
+	}
+	return copy(m.data[off:], p), nil
+}
+
+// sends file to a receiver already holding have, returning both sides' results
+func transfer(t *testing.T, file []byte, have []byte) (*memFile, error, error) {
+	t.Helper()
+
+	serverConn, clientConn := net.Pipe()
+	defer serverConn.Close()
+	defer clientConn.Close()
+
+	sent := make(chan error)
+	go func() {
+		client := &WSConn{Conn: clientConn, Role: RoleClient}
+		sent <- SendFile(client, "a.bin", bytes.NewReader(file), int64(len(file)), TransferOptions{ChunkSize: 100})
+	}()
+
+	dst := &memFile{data: append([]byte(nil), have...)}
+
+	var progress []int64
+	manifest, err := ReceiveFile(&WSConn{Conn: serverConn}, func(m FileManifest) (FileTarget, int64, error) {
+		return dst, int64(len(dst.data)), nil
+	}, TransferOptions{Progress: func(done, total int64) {
+		progress = append(progress, done)
+	}})
+
+	if err == nil {
+		if manifest.Name != "a.bin" || manifest.Size != int64(len(file)) {
+			t.Errorf("unexpected manifest: %+v", manifest)
+		}
+		if len(progress) == 0 || progress[len(progress)-1] != int64(len(file)) {
+			t.Errorf("unexpected progress: %v", progress)
+		}
+	}
+
+	return dst, err, <-sent
+}
+
+func TestTransfer(t *testing.T) {
+	file := bytes.Repeat([]byte("0123456789"), 105)
+
+	dst, rerr, serr := transfer(t, file, nil)
+	if rerr != nil || serr != nil {
+		t.Fatalf("%v %v", rerr, serr)
+	}
+	if !bytes.Equal(dst.data, file) {
+		t.Errorf("file corrupted")
+	}
+}
+
+func TestTransferResume(t *testing.T) {
+	file := bytes.Repeat([]byte("0123456789"), 105)
+
+	dst, rerr, serr := transfer(t, file, file[:550])
+	if rerr != nil || serr != nil {
+		t.Fatalf("%v %v", rerr, serr)
+	}
+	if !bytes.Equal(dst.data, file) {
+		t.Errorf("file corrupted")
+	}
+}
+
+func TestTransferChecksumMismatch(t *testing.T) {
+	file := bytes.Repeat([]byte("0123456789"), 105)
+
+	// the partial copy held from before doesn't match the file
+	_, rerr, serr := transfer(t, file, bytes.Repeat([]byte("x"), 550))
+	if !errors.Is(rerr, ErrChecksumMismatch) || !errors.Is(serr, ErrChecksumMismatch) {
+		t.Errorf("want: ErrChecksumMismatch, got: %v %v", rerr, serr)
+	}
+}
+
+func TestSendFileShortSource(t *testing.T) {
+	serverConn, clientConn := net.Pipe()
+	defer serverConn.Close()
+	defer clientConn.Close()
+
+	file := bytes.Repeat([]byte("0123456789"), 10)
+
+	// fails hashing, before anything is sent
+	err := SendFile(&WSConn{Conn: clientConn, Role: RoleClient}, "a.bin", bytes.NewReader(file), 200, TransferOptions{})
+	if !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("want: %v, got: %v", io.ErrUnexpectedEOF, err)
+	}
+}
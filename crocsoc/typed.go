@@ -0,0 +1,66 @@
+package crocsoc
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Converts values to and from message payloads for Typed connections.
+type Codec interface {
+	Marshal(v any) ([]byte, error)
+	Unmarshal(data []byte, v any) error
+	// whether payloads are sent in binary rather than text frames
+	Binary() bool
+}
+
+// Encodes values as JSON text messages.
+var JSONCodec Codec = jsonCodec{}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v any) ([]byte, error)      { return json.Marshal(v) }
+func (jsonCodec) Unmarshal(data []byte, v any) error { return json.Unmarshal(data, v) }
+func (jsonCodec) Binary() bool                       { return false }
+
+// A WSConn whose messages are all values of type T.
+type TypedConn[T any] struct {
+	Conn  *WSConn
+	Codec Codec
+}
+
+// Wraps conn so every message is sent and received as a T, e.g.
+//
+//	chat := crocsoc.Typed[ChatMessage](conn, crocsoc.JSONCodec)
+//	msg, err := chat.Receive()
+func Typed[T any](conn *WSConn, codec Codec) *TypedConn[T] {
+	return &TypedConn[T]{Conn: conn, Codec: codec}
+}
+
+func (t *TypedConn[T]) Send(v T) error {
+	data, err := t.Codec.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("failed to encode message: %v", err)
+	}
+
+	if t.Codec.Binary() {
+		return t.Conn.SendBinaryFrame(data)
+	}
+	return t.Conn.SendTextFrame(data)
+}
+
+// Reads the next message and decodes it into a T. Returns io.EOF once the
+// connection has closed.
+func (t *TypedConn[T]) Receive() (T, error) {
+	var v T
+
+	data, err := t.Conn.ReadMessage()
+	if err != nil {
+		return v, err
+	}
+
+	if err := t.Codec.Unmarshal(data, &v); err != nil {
+		return v, fmt.Errorf("failed to decode message: %v", err)
+	}
+
+	return v, nil
+}
@@ -0,0 +1,52 @@
+package crocsoc
+
+import (
+	"net"
+	"testing"
+)
+
+type chatMessage struct {
+	User string `json:"user"`
+	Text string `json:"text"`
+}
+
+func TestTypedRoundTrip(t *testing.T) {
+	serverConn, clientConn := net.Pipe()
+	defer serverConn.Close()
+	defer clientConn.Close()
+
+	server := Typed[chatMessage](&WSConn{Conn: serverConn}, JSONCodec)
+	client := Typed[chatMessage](&WSConn{Conn: clientConn, Role: RoleClient}, JSONCodec)
+
+	want := chatMessage{User: "croc", Text: "Hello"}
+
+	go func() {
+		if err := client.Send(want); err != nil {
+			t.Errorf("%v", err)
+		}
+	}()
+
+	got, err := server.Receive()
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	if got != want {
+		t.Errorf("want: %+v, got: %+v", want, got)
+	}
+}
+
+func TestTypedDecodeError(t *testing.T) {
+	serverConn, clientConn := net.Pipe()
+	defer serverConn.Close()
+	defer clientConn.Close()
+	client := &WSConn{Conn: clientConn, Role: RoleClient}
+
+	server := Typed[chatMessage](&WSConn{Conn: serverConn}, JSONCodec)
+
+	go client.SendTextFrame([]byte("not json"))
+
+	if _, err := server.Receive(); err == nil {
+		t.Errorf("invalid payload decoded")
+	}
+}
@@ -0,0 +1,272 @@
+package crocsoc
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// Upgrades HTTP requests to WebSocket connections, returning the
+// connection to the caller instead of handing it to ServeConn as WsHandler
+// does:
+//
+//	var upgrader = crocsoc.Upgrader{ReadBufferSize: 16 << 10}
+//
+//	func chat(w http.ResponseWriter, r *http.Request) {
+//		conn, err := upgrader.Upgrade(w, r, nil)
+//		if err != nil {
+//			return // the error response has been written
+//		}
+//		defer conn.NetConn().Close()
+//		...
+//	}
+//
+// Package-wide settings such as RequireSecure, RawHandshake and
+// SetMaxPendingHandshakes apply to every Upgrader. The zero Upgrader is
+// ready to use.
+type Upgrader struct {
+	// size of the buffer frames are read through, 4KB by default
+	ReadBufferSize int
+	// read limit upgraded connections start with, see SetReadLimit. 32MB
+	// when 0, negative for no limit
+	ReadLimit int64
+
+	// subprotocols the server speaks, in order of preference. The first
+	// one the client also offered is echoed back and set as the
+	// connection's Subprotocol; with no overlap the connection opens
+	// without one.
+	Subprotocols []string
+	// picks the subprotocol from those the client offered instead of
+	// Subprotocols, "" for none. Must return one of offered.
+	SelectSubprotocol func(r *http.Request, offered []string) string
+
+	// which origins may connect, the package policy set by
+	// SetOriginPolicy when nil
+	OriginPolicy *OriginPolicy
+
+	// accept permessage-deflate offers, compressing and inflating messages
+	// transparently. Inflated messages count against the read limit.
+	EnableCompression bool
+}
+
+// the Upgrader behind WsHandler
+var defaultUpgrader Upgrader
+
+// Completes the opening handshake for r and hijacks the connection.
+// responseHeader, which may be nil, is added to the 101 response, e.g. for
+// Set-Cookie. On failure the HTTP error response has already been written
+// and the returned error says why.
+func (u *Upgrader) Upgrade(w http.ResponseWriter, r *http.Request, responseHeader http.Header) (*Conn, error) {
+	// correlates the http layer's logs with the socket's lifetime
+	// the id goes into logs, profiles and traces, so only a short plain
+	// one is taken from the client
+	requestID := r.Header.Get("X-Request-ID")
+	if !validRequestID(requestID) {
+		requestID = newRequestID()
+	}
+	w.Header().Set("X-Request-ID", requestID)
+
+	// shed load before doing any work for the upgrade
+	defer endHandshake()
+	if !beginHandshake() {
+		setRetryAfter(w.Header(), SuggestedRetryAfter())
+		http.Error(w, "too many pending handshakes", http.StatusServiceUnavailable)
+		return nil, fmt.Errorf("too many pending handshakes")
+	}
+
+	slog.Info("ws handler", "request_id", requestID)
+
+	_, span := tracer.Start(r.Context(), "crocsoc.upgrade")
+	defer span.End()
+
+	span.SetAttributes(
+		Attribute{Key: "url.path", Value: r.URL.Path},
+		Attribute{Key: "client.address", Value: r.RemoteAddr},
+	)
+
+	fail := func(status int, err error) (*Conn, error) {
+		span.RecordError(err)
+		http.Error(w, err.Error(), status)
+		return nil, err
+	}
+
+	secure := IsSecure(r)
+	if requireSecure && !secure {
+		span.RecordError(fmt.Errorf("insecure upgrade rejected"))
+		http.Error(w, "secure connection required", http.StatusForbidden)
+		return nil, fmt.Errorf("secure connection required")
+	}
+
+	policy := u.OriginPolicy
+	if policy == nil {
+		policy = &originPolicy
+	}
+	if !policy.Allowed(r) {
+		return fail(http.StatusForbidden, fmt.Errorf("origin %q not allowed", r.Header.Get("Origin")))
+	}
+
+	subprotocol, err := u.selectSubprotocol(r)
+	if err != nil {
+		return fail(http.StatusInternalServerError, err)
+	}
+
+	var extensions string
+	if u.EnableCompression {
+		extensions = negotiateDeflate(r)
+	}
+
+	// before any response headers are set, so they don't end up on the 400
+	if err := validateUpgrade(r); err != nil {
+		return fail(http.StatusBadRequest, err)
+	}
+
+	// the raw response is written after hijacking, with the same headers.
+	// Sec-WebSocket-Protocol in responseHeader is dropped, only the
+	// negotiated value is sent
+	var extra []HeaderField
+	if rawHandshake {
+		extra = append(extra, HeaderField{Name: "X-Request-ID", Value: requestID})
+		if subprotocol != "" {
+			extra = append(extra, HeaderField{Name: "Sec-WebSocket-Protocol", Value: subprotocol})
+		}
+		if extensions != "" {
+			extra = append(extra, HeaderField{Name: "Sec-WebSocket-Extensions", Value: extensions})
+		}
+		// sorted, map order would change the response from run to run
+		for _, name := range slices.Sorted(maps.Keys(responseHeader)) {
+			if http.CanonicalHeaderKey(name) == "Sec-Websocket-Protocol" {
+				continue
+			}
+			for _, v := range responseHeader[name] {
+				extra = append(extra, HeaderField{Name: name, Value: v})
+			}
+		}
+	} else {
+		for name, values := range responseHeader {
+			if http.CanonicalHeaderKey(name) == "Sec-Websocket-Protocol" {
+				continue
+			}
+			w.Header()[name] = append(w.Header()[name], values...)
+		}
+		if subprotocol != "" {
+			w.Header().Set("Sec-WebSocket-Protocol", subprotocol)
+		}
+		if extensions != "" {
+			w.Header().Set("Sec-WebSocket-Extensions", extensions)
+		}
+
+		if err := OpeningHandshake(w, r); err != nil {
+			return fail(http.StatusBadRequest, err)
+		}
+	}
+
+	// hijack tcp, through any middleware wrapping w that implements
+	// Unwrap() http.ResponseWriter
+	netConn, rw, err := http.NewResponseController(w).Hijack()
+	if errors.Is(err, http.ErrNotSupported) {
+		return fail(http.StatusInternalServerError, fmt.Errorf("Hijacking not supported"))
+	}
+	if err != nil {
+		return fail(http.StatusInternalServerError, fmt.Errorf("Hijacking failed: %v", err))
+	}
+
+	if rawHandshake {
+		if err := WriteRawHandshake(rw.Writer, r, extra); err != nil {
+			span.RecordError(err)
+			netConn.Close()
+			return nil, err
+		}
+	}
+
+	// read frames through a buffer, starting with anything the http
+	// server read past the request
+	size := u.ReadBufferSize
+	if size <= 0 {
+		size = 4 << 10
+	}
+	br := bufio.NewReaderSize(rw.Reader, size)
+
+	ws := &WSConn{
+		ID:          lastConnID.Add(1),
+		Conn:        &readerConn{Conn: netConn, r: br},
+		RW:          bufio.NewReadWriter(br, rw.Writer),
+		Subprotocol: subprotocol,
+		Compression: extensions != "",
+		RequestID:   requestID,
+		Secure:      secure,
+	}
+	ws.Logger = slog.Default().With("conn_id", ws.ID, "request_id", requestID)
+
+	switch {
+	case u.ReadLimit == 0:
+		ws.SetReadLimit(defaultReadLimit)
+	case u.ReadLimit > 0:
+		ws.SetReadLimit(u.ReadLimit)
+	}
+
+	span.SetAttributes(
+		Attribute{Key: "websocket.conn_id", Value: ws.ID},
+		Attribute{Key: "websocket.request_id", Value: requestID},
+	)
+
+	return &Conn{WSConn: ws}, nil
+}
+
+// returns the subprotocol to echo to r, "" for none
+func (u *Upgrader) selectSubprotocol(r *http.Request) (string, error) {
+	offered := Subprotocols(r)
+	if len(offered) == 0 {
+		return "", nil
+	}
+
+	if u.SelectSubprotocol != nil {
+		p := u.SelectSubprotocol(r, offered)
+		if p != "" && !slices.Contains(offered, p) {
+			return "", fmt.Errorf("selected subprotocol %q was not offered", p)
+		}
+		return p, nil
+	}
+
+	for _, p := range u.Subprotocols {
+		if slices.Contains(offered, p) {
+			return p, nil
+		}
+	}
+	return "", nil
+}
+
+// Returns the subprotocols the client offered in Sec-WebSocket-Protocol,
+// in its order of preference.
+func Subprotocols(r *http.Request) []string {
+	var offered []string
+	for _, v := range r.Header.Values("Sec-WebSocket-Protocol") {
+		for _, p := range strings.Split(v, ",") {
+			if p = strings.TrimSpace(p); p != "" {
+				offered = append(offered, p)
+			}
+		}
+	}
+	return offered
+}
+
+// a hijacked connection read through the buffer that may hold its first
+// bytes
+type readerConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *readerConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
+
+// the hijacked connection, e.g. for Terminate
+func (c *readerConn) NetConn() net.Conn {
+	return c.Conn
+}
@@ -0,0 +1,156 @@
+package crocsoc
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestUpgrader(t *testing.T) {
+	upgrader := Upgrader{ReadBufferSize: 512}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, http.Header{"Set-Cookie": {"session=1"}})
+		if err != nil {
+			return
+		}
+		defer conn.NetConn().Close()
+
+		if got := conn.ReadLimit(); got != defaultReadLimit {
+			t.Errorf("want: %d, got: %d", defaultReadLimit, got)
+		}
+
+		// echo
+		for {
+			mt, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			conn.WriteMessage(mt, data)
+		}
+	}))
+	defer srv.Close()
+
+	wk := GenerateKey()
+	req, err := BuildUpgradeRequest(srv.URL, wk, nil)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	nc, err := net.Dial("tcp", srv.Listener.Addr().String())
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer nc.Close()
+
+	// a frame sent right behind the request, which the http server
+	// buffers before the connection is hijacked
+	var out bytes.Buffer
+	req.Write(&out)
+	out.Write([]byte{0x81, 0x80 | 5, 0, 0, 0, 0}) // masked with a zero key
+	out.WriteString("early")
+	nc.Write(out.Bytes())
+
+	br := bufio.NewReader(nc)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if err := ValidateResponse(resp, wk); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if resp.Header.Get("Set-Cookie") != "session=1" || resp.Header.Get("X-Request-ID") == "" {
+		t.Errorf("response headers missing: %v", resp.Header)
+	}
+
+	client := NewConn(&readerConn{Conn: nc, r: br}, nil, RoleClient)
+
+	mt, data, err := client.ReadMessage()
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if mt != TextMessage || string(data) != "early" {
+		t.Errorf("want: early, got: %d %s", mt, data)
+	}
+}
+
+func TestUpgraderRejects(t *testing.T) {
+	var upgrader Upgrader
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+
+	if _, err := upgrader.Upgrade(w, r, http.Header{"Set-Cookie": {"session=1"}}); err == nil {
+		t.Errorf("plain request upgraded")
+	}
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("want: %d, got: %d", http.StatusBadRequest, w.Code)
+	}
+	// upgrade response headers aren't meant for the error
+	if got := w.Header().Get("Set-Cookie"); got != "" {
+		t.Errorf("want: no Set-Cookie, got: %q", got)
+	}
+}
+
+func TestUpgraderSubprotocols(t *testing.T) {
+	tests := []struct {
+		upgrader Upgrader
+		offered  []string
+		want     string
+	}{
+		{Upgrader{Subprotocols: []string{"v2", "v1"}}, []string{"v1", "v2"}, "v2"},
+		{Upgrader{Subprotocols: []string{"v2", "v1"}}, []string{"v1"}, "v1"},
+		{Upgrader{Subprotocols: []string{"v2"}}, []string{"mqtt"}, ""},
+		{Upgrader{Subprotocols: []string{"v2"}}, nil, ""},
+		{Upgrader{SelectSubprotocol: func(r *http.Request, offered []string) string {
+			return offered[len(offered)-1]
+		}}, []string{"v1", "v2"}, "v2"},
+	}
+
+	for _, tt := range tests {
+		negotiated := make(chan string, 1)
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			conn, err := tt.upgrader.Upgrade(w, r, http.Header{"Sec-WebSocket-Protocol": {"bogus"}})
+			if err != nil {
+				return
+			}
+			conn.NetConn().Close()
+			negotiated <- conn.Subprotocol
+		}))
+
+		conn, resp, err := Dial(context.Background(), srv.URL, &DialOptions{Subprotocols: tt.offered})
+		if err != nil {
+			t.Errorf("%v: %v", tt.offered, err)
+			srv.Close()
+			continue
+		}
+		conn.NetConn().Close()
+
+		if got := <-negotiated; got != tt.want || conn.Subprotocol != tt.want {
+			t.Errorf("%v: want: %q, got: server %q, client %q", tt.offered, tt.want, got, conn.Subprotocol)
+		}
+		if n := len(resp.Header.Values("Sec-WebSocket-Protocol")); n > 1 {
+			t.Errorf("%v: %d Sec-WebSocket-Protocol headers", tt.offered, n)
+		}
+		srv.Close()
+	}
+}
+
+func TestUpgraderSelectUnoffered(t *testing.T) {
+	upgrader := Upgrader{SelectSubprotocol: func(*http.Request, []string) string { return "mqtt" }}
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("Sec-WebSocket-Protocol", "chat")
+
+	if _, err := upgrader.Upgrade(w, r, nil); err == nil {
+		t.Errorf("unoffered subprotocol accepted")
+	}
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("want: %d, got: %d", http.StatusInternalServerError, w.Code)
+	}
+}
@@ -1,41 +1,61 @@
 package crocsoc
 
 import (
-	"fmt"
-	"log/slog"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"net/http"
+	"runtime/pprof"
+	"strconv"
+	"sync/atomic"
 )
 
-func WsHandler(w http.ResponseWriter, r *http.Request) {
-	slog.Info("ws handler")
+// source of WSConn.ID
+var lastConnID atomic.Uint64
 
-	// handle OpeningHandshake
-	if err := OpeningHandshake(w, r); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
-		return 
-	}
-	
-	// hijack tcp 
-	hijacker, ok := w.(http.Hijacker)
-	if !ok {
-		http.Error(w, "Hijacking not supported", http.StatusInternalServerError)
+// Upgrades the request and serves the connection with ServeConn on its own
+// goroutine.
+func WsHandler(w http.ResponseWriter, r *http.Request) {
+	conn, err := defaultUpgrader.Upgrade(w, r, nil)
+	if err != nil {
 		return
 	}
 
-	conn, rw, err := hijacker.Hijack()
-	if err != nil {
-		http.Error(w, fmt.Sprintf("Hijacking failed: %v", err), http.StatusInternalServerError)
-		return 
-	}
+	// label the connection goroutine so profiles attribute its cpu and
+	// blocking time to this connection
+	labels := pprof.Labels(
+		"conn_id", strconv.FormatUint(conn.ID, 10),
+		"remote_addr", conn.NetConn().RemoteAddr().String(),
+		"request_id", conn.RequestID,
+	)
 
-	// build connection object
-	wsConn := WSConn{
-		Conn: conn,
-		RW: rw, 
-		Subprotocol: r.Header.Get("Sec-WebSocket-Protocol"),
-		IsClosed: false,
+	// offloads handling of connection to go routine for communicating frame data
+	go pprof.Do(context.Background(), labels, func(context.Context) {
+		ServeConn(conn.WSConn)
+	})
+}
+
+// longest X-Request-ID taken from a client
+const maxRequestIDLen = 128
+
+// whether id is 1 to maxRequestIDLen of [A-Za-z0-9._-]
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLen {
+		return false
 	}
+	for _, c := range []byte(id) {
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9':
+		case c == '.', c == '_', c == '-':
+		default:
+			return false
+		}
+	}
+	return true
+}
 
-	// offloads handling of connection to go routine for communicating frame data
-	go ServeConn(wsConn)
+func newRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
 }
@@ -0,0 +1,82 @@
+package crocsoc
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// middleware style wrapper hiding http.Hijacker
+type loggingWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (lw *loggingWriter) WriteHeader(status int) {
+	lw.status = status
+	lw.ResponseWriter.WriteHeader(status)
+}
+
+func (lw *loggingWriter) Unwrap() http.ResponseWriter {
+	return lw.ResponseWriter
+}
+
+func TestWsHandlerWrappedWriter(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		WsHandler(&loggingWriter{ResponseWriter: w}, r)
+	}))
+	defer srv.Close()
+
+	wk := GenerateKey()
+	req, err := BuildUpgradeRequest(srv.URL, wk, nil)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer resp.Body.Close()
+
+	if err := ValidateResponse(resp, wk); err != nil {
+		t.Errorf("%v", err)
+	}
+}
+
+func TestValidRequestID(t *testing.T) {
+	tests := []struct {
+		id   string
+		want bool
+	}{
+		{"abc-123_X.y", true},
+		{"", false},
+		{strings.Repeat("a", 128), true},
+		{strings.Repeat("a", 129), false},
+		{"a b", false},
+		{"a\nlevel=ERROR", false},
+		{"é", false},
+	}
+
+	for _, tt := range tests {
+		if got := validRequestID(tt.id); got != tt.want {
+			t.Errorf("%q: want: %v, got: %v", tt.id, tt.want, got)
+		}
+	}
+}
+
+func TestUpgraderReplacesBadRequestID(t *testing.T) {
+	var upgrader Upgrader
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("X-Request-ID", "evil\" injected=1")
+
+	// a plain request, rejected after the id is picked
+	upgrader.Upgrade(w, r, nil)
+
+	if got := w.Header().Get("X-Request-ID"); !validRequestID(got) || strings.Contains(got, "evil") {
+		t.Errorf("want: a fresh request id, got: %q", got)
+	}
+}
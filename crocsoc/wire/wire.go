@@ -0,0 +1,174 @@
+// Package wire encodes and decodes RFC 6455 frame headers and masks
+// payloads, on plain io.Readers and byte slices rather than connections, so
+// proxies, fuzzers and other transports can reuse crocsoc's framing:
+//
+//	h, err := wire.ReadHeader(r)
+//	payload := make([]byte, h.Length)
+//	io.ReadFull(r, payload)
+//	if h.Masked {
+//		wire.Mask(h.MaskKey, 0, payload)
+//	}
+//
+// The package knows nothing of messages, fragmentation or control frame
+// semantics; crocsoc builds those on top.
+package wire
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+/*
+	 0                   1                   2                   3
+	 0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1
+	+-+-+-+-+-------+-+-------------+-------------------------------+
+	|F|R|R|R| opcode|M| Payload len |    Extended payload length    |
+	|I|S|S|S|  (4)  |A|     (7)     |             (16/64)           |
+	|N|V|V|V|       |S|             |   (if payload len==126/127)   |
+	| |1|2|3|       |K|             |                               |
+	+-+-+-+-+-------+-+-------------+ - - - - - - - - - - - - - - - +
+	|     Extended payload length continued, if payload len == 127  |
+	+ - - - - - - - - - - - - - - - +-------------------------------+
+	|                               |Masking-key, if MASK set to 1  |
+	+-------------------------------+-------------------------------+
+	| Masking-key (continued)       |          Payload Data         |
+	+-------------------------------- - - - - - - - - - - - - - - - +
+*/
+
+const (
+	OpContinuation byte = 0x0
+	OpText         byte = 0x1
+	OpBinary       byte = 0x2
+	OpClose        byte = 0x8
+	OpPing         byte = 0x9
+	OpPong         byte = 0xA
+)
+
+// Returned by ReadHeader for a 64-bit length with the most significant bit
+// set.
+var ErrInvalidLength = errors.New("wire: invalid payload length")
+
+// longest possible header: 2 + 8 byte length + 4 byte masking key
+const MaxHeaderLen = 14
+
+type Header struct {
+	Fin bool
+	// RSV1-3 as the low three bits, RSV1 being 0x4
+	Rsv    byte
+	Opcode byte
+
+	Masked  bool
+	MaskKey [4]byte
+
+	// payload length
+	Length int64
+}
+
+// Whether op is a close, ping or pong opcode.
+func IsControl(op byte) bool {
+	return op&0x8 != 0
+}
+
+// Reads one frame header. Returns io.EOF if r ends before the header starts
+// and io.ErrUnexpectedEOF if it ends part way through.
+func ReadHeader(r io.Reader) (Header, error) {
+	var b [MaxHeaderLen]byte
+
+	if _, err := io.ReadFull(r, b[:2]); err != nil {
+		return Header{}, err
+	}
+
+	h := Header{
+		Fin:    b[0]&0x80 != 0,
+		Rsv:    b[0] >> 4 & 0x7,
+		Opcode: b[0] & 0x0F,
+		Masked: b[1]&0x80 != 0,
+		Length: int64(b[1] & 0x7F),
+	}
+
+	/*
+		-> If payload length 0-125, that is the payload length.
+		-> If 126, the following 2 bytes interpreted as a 16-bit unsigned integer
+		are the payload length.
+		-> If 127, the following 8 bytes interpreted as a 64-bit unsigned integer (the
+		most significant bit MUST be 0) are the payload length.
+
+		Multibyte length quantities are expressed in network byte order.
+	*/
+	switch h.Length {
+	case 126:
+		if _, err := io.ReadFull(r, b[2:4]); err != nil {
+			return Header{}, truncated(err)
+		}
+		h.Length = int64(binary.BigEndian.Uint16(b[2:4]))
+	case 127:
+		if _, err := io.ReadFull(r, b[2:10]); err != nil {
+			return Header{}, truncated(err)
+		}
+		l := binary.BigEndian.Uint64(b[2:10])
+		if l>>63 != 0 {
+			return Header{}, ErrInvalidLength
+		}
+		h.Length = int64(l)
+	}
+
+	if h.Masked {
+		if _, err := io.ReadFull(r, h.MaskKey[:]); err != nil {
+			return Header{}, truncated(err)
+		}
+	}
+
+	return h, nil
+}
+
+// Appends the encoded header to dst, using the shortest length encoding.
+func AppendHeader(dst []byte, h Header) []byte {
+	b0 := h.Rsv&0x7<<4 | h.Opcode&0x0F
+	if h.Fin {
+		b0 |= 0x80
+	}
+
+	var b1 byte
+	if h.Masked {
+		b1 = 0x80
+	}
+
+	switch {
+	case h.Length <= 125:
+		dst = append(dst, b0, b1|byte(h.Length))
+	case h.Length <= 0xFFFF:
+		dst = append(dst, b0, b1|126)
+		dst = binary.BigEndian.AppendUint16(dst, uint16(h.Length))
+	default:
+		dst = append(dst, b0, b1|127)
+		dst = binary.BigEndian.AppendUint64(dst, uint64(h.Length))
+	}
+
+	if h.Masked {
+		dst = append(dst, h.MaskKey[:]...)
+	}
+	return dst
+}
+
+/*
+Masking key (4 byte mask): [A B C D]
+Payload: [p0 ^ A, p1 ^ B, p2 ^ C, p3 ^ D, p4 ^ A,  p5 ^ B...]
+*/
+
+// Masks or unmasks b in place, b starting pos bytes into the payload.
+// Returns the position after b, for masking a payload in pieces.
+func Mask(key [4]byte, pos int, b []byte) int {
+	for i := range b {
+		b[i] ^= key[(pos+i)%4]
+	}
+	return pos + len(b)
+}
+
+// a header cut short is corrupt, not finished
+func truncated(err error) error {
+	if errors.Is(err, io.EOF) {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
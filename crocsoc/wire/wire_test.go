@@ -0,0 +1,85 @@
+package wire
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+// examples from RFC-6455 5.7
+func TestRFCExamples(t *testing.T) {
+	tests := []struct {
+		raw  []byte
+		want Header
+	}{
+		{[]byte{0x81, 0x05}, Header{Fin: true, Opcode: OpText, Length: 5}},
+		{[]byte{0x81, 0x85, 0x37, 0xfa, 0x21, 0x3d}, Header{Fin: true, Opcode: OpText, Masked: true, MaskKey: [4]byte{0x37, 0xfa, 0x21, 0x3d}, Length: 5}},
+		{[]byte{0x01, 0x03}, Header{Opcode: OpText, Length: 3}},
+		{[]byte{0x80, 0x02}, Header{Fin: true, Opcode: OpContinuation, Length: 2}},
+		{[]byte{0x89, 0x05}, Header{Fin: true, Opcode: OpPing, Length: 5}},
+		{[]byte{0x82, 0x7E, 0x01, 0x00}, Header{Fin: true, Opcode: OpBinary, Length: 256}},
+		{[]byte{0x82, 0x7F, 0, 0, 0, 0, 0, 0x01, 0, 0}, Header{Fin: true, Opcode: OpBinary, Length: 65536}},
+		// rsv1, as used by permessage-deflate
+		{[]byte{0xC1, 0x05}, Header{Fin: true, Rsv: 0x4, Opcode: OpText, Length: 5}},
+	}
+
+	for _, tt := range tests {
+		got, err := ReadHeader(bytes.NewReader(tt.raw))
+		if err != nil {
+			t.Errorf("%x: %v", tt.raw, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%x: want: %+v, got: %+v", tt.raw, tt.want, got)
+		}
+
+		if enc := AppendHeader(nil, tt.want); !bytes.Equal(enc, tt.raw) {
+			t.Errorf("encode: want: %x, got: %x", tt.raw, enc)
+		}
+	}
+}
+
+func TestMask(t *testing.T) {
+	key := [4]byte{0x37, 0xfa, 0x21, 0x3d}
+	masked := []byte{0x7f, 0x9f, 0x4d, 0x51, 0x58}
+
+	// in pieces, as a streaming reader would
+	b := append([]byte(nil), masked...)
+	pos := Mask(key, 0, b[:2])
+	Mask(key, pos, b[2:])
+
+	if string(b) != "Hello" {
+		t.Errorf("want: Hello, got: %q", b)
+	}
+}
+
+func TestReadHeaderErrors(t *testing.T) {
+	if _, err := ReadHeader(bytes.NewReader(nil)); err != io.EOF {
+		t.Errorf("want: EOF, got: %v", err)
+	}
+
+	for _, raw := range [][]byte{
+		{0x81},
+		{0x82, 0x7E, 0x01},
+		{0x82, 0x7F, 0, 0, 0},
+		{0x81, 0x85, 0x37, 0xfa},
+	} {
+		if _, err := ReadHeader(bytes.NewReader(raw)); err != io.ErrUnexpectedEOF {
+			t.Errorf("%x: want: ErrUnexpectedEOF, got: %v", raw, err)
+		}
+	}
+
+	raw := []byte{0x82, 0x7F, 0x80, 0, 0, 0, 0, 0, 0, 0}
+	if _, err := ReadHeader(bytes.NewReader(raw)); !errors.Is(err, ErrInvalidLength) {
+		t.Errorf("want: ErrInvalidLength, got: %v", err)
+	}
+}
+
+func TestIsControl(t *testing.T) {
+	for op, want := range map[byte]bool{OpText: false, OpBinary: false, OpContinuation: false, OpClose: true, OpPing: true, OpPong: true} {
+		if IsControl(op) != want {
+			t.Errorf("%x: want: %v", op, want)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package crocsoc
+
+import (
+	"errors"
+	"net"
+	"syscall"
+	"time"
+)
+
+// How a failed write should be treated.
+type WriteErrorClass int
+
+const (
+	// the write may succeed if retried, e.g. EINTR or EAGAIN
+	WriteTransient WriteErrorClass = iota
+	// the peer hung up, the connection should be closed with 1006
+	WritePeerGone
+	// anything else, including timeouts and local closes; the connection
+	// is unusable
+	WriteFatal
+)
+
+func (wc WriteErrorClass) String() string {
+	switch wc {
+	case WriteTransient:
+		return "transient"
+	case WritePeerGone:
+		return "peer gone"
+	default:
+		return "fatal"
+	}
+}
+
+// Classifies a write error, e.g. for a fan-out deciding whether to drop a
+// message for a slow connection or close it.
+func ClassifyWriteError(err error) WriteErrorClass {
+	switch {
+	case peerGone(err):
+		return WritePeerGone
+	case errors.Is(err, syscall.EINTR),
+		errors.Is(err, syscall.EAGAIN),
+		errors.Is(err, syscall.EWOULDBLOCK),
+		errors.Is(err, syscall.ENOBUFS):
+		return WriteTransient
+	default:
+		return WriteFatal
+	}
+}
+
+// attempts at a transient error when the write has no deadline
+const maxWriteRetries = 5
+
+// writes all of b, retrying transient errors with backoff until deadline,
+// or maxWriteRetries times with no deadline. Retries resume after the bytes
+// already written so the frame isn't duplicated on the wire. Sockets from
+// the net package wait out EAGAIN and EINTR themselves, ENOBUFS and conns
+// wrapping their own file descriptors can still surface them.
+func writeRetry(conn net.Conn, b []byte, deadline time.Time) error {
+	backoff := time.Millisecond
+
+	for attempt := 1; ; attempt++ {
+		n, err := conn.Write(b)
+		b = b[n:]
+
+		if err == nil {
+			return nil
+		}
+		if ClassifyWriteError(err) != WriteTransient {
+			return err
+		}
+
+		if deadline.IsZero() {
+			if attempt >= maxWriteRetries {
+				return err
+			}
+		} else if time.Now().Add(backoff).After(deadline) {
+			return err
+		}
+
+		time.Sleep(backoff)
+		backoff = min(2*backoff, 100*time.Millisecond)
+	}
+}
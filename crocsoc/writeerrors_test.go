@@ -0,0 +1,87 @@
+package crocsoc
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"slices"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestClassifyWriteError(t *testing.T) {
+	tests := []struct {
+		err  error
+		want WriteErrorClass
+	}{
+		{syscall.EAGAIN, WriteTransient},
+		{&net.OpError{Op: "write", Err: os.NewSyscallError("write", syscall.EINTR)}, WriteTransient},
+		{&net.OpError{Op: "write", Err: os.NewSyscallError("write", syscall.EPIPE)}, WritePeerGone},
+		{syscall.ECONNRESET, WritePeerGone},
+		{io.ErrClosedPipe, WritePeerGone},
+		{os.ErrDeadlineExceeded, WriteFatal},
+		{net.ErrClosed, WriteFatal},
+		{fmt.Errorf("tls: bad record"), WriteFatal},
+	}
+
+	for _, tt := range tests {
+		if got := ClassifyWriteError(tt.err); got != tt.want {
+			t.Errorf("%v: want: %v, got: %v", tt.err, tt.want, got)
+		}
+	}
+}
+
+// a net.Conn failing writes with errs in turn, after writing part of them
+type flakyConn struct {
+	net.Conn
+	errs []error
+	buf  bytes.Buffer
+}
+
+func (f *flakyConn) Write(p []byte) (int, error) {
+	if len(f.errs) > 0 {
+		err := f.errs[0]
+		f.errs = f.errs[1:]
+
+		// partial write before the error
+		n := len(p) / 2
+		f.buf.Write(p[:n])
+		return n, err
+	}
+	return f.buf.Write(p)
+}
+
+func (f *flakyConn) SetWriteDeadline(time.Time) error { return nil }
+
+func TestWriteRetry(t *testing.T) {
+	conn := &flakyConn{errs: []error{syscall.EAGAIN, syscall.EINTR}}
+	ws := &WSConn{Conn: conn}
+
+	if err := ws.SendTextFrame([]byte("hello world")); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	// resumed after the partial writes, not duplicated
+	want := append([]byte{0x81, 11}, "hello world"...)
+	if !bytes.Equal(conn.buf.Bytes(), want) {
+		t.Errorf("want: %q, got: %q", want, conn.buf.Bytes())
+	}
+
+	// gives up once the deadline passes
+	conn = &flakyConn{errs: slices.Repeat([]error{syscall.EAGAIN}, 100)}
+	ws = &WSConn{Conn: conn, WriteTimeout: 20 * time.Millisecond}
+	if err := ws.SendTextFrame([]byte("hello")); !errors.Is(err, syscall.EAGAIN) {
+		t.Errorf("want: EAGAIN, got: %v", err)
+	}
+
+	// fatal errors aren't retried
+	conn = &flakyConn{errs: []error{net.ErrClosed}}
+	ws = &WSConn{Conn: conn}
+	if err := ws.SendTextFrame([]byte("hello")); !errors.Is(err, net.ErrClosed) {
+		t.Errorf("want: ErrClosed, got: %v", err)
+	}
+}